@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lyraproj/hiera/hiera"
+	"github.com/lyraproj/hiera/provider"
+	"github.com/lyraproj/pcore/px"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fromVars     []string
+	toVars       []string
+	fromConfig   string
+	toConfig     string
+	diffExitCode bool
+)
+
+// ExitCoder is implemented by errors that call for a specific exit code
+type ExitCoder interface {
+	error
+	ExitCode() int
+}
+
+type exitError struct {
+	error
+	code int
+}
+
+func (e *exitError) ExitCode() int {
+	return e.code
+}
+
+// errDifferences is returned by the diff command when differences are found and an exit code is requested
+var errDifferences = &exitError{errors.New(`differences found`), 1}
+
+// newDiffCommand returns the "diff" command
+func newDiffCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff [<pattern> ...]",
+		Short: `Compare the effective values of keys between two scopes or two configurations`,
+		Long: "Compare the effective values of keys between two scopes or two configurations.\n" +
+			"  All keys are looked up using the \"from\" scope and configuration and then using the \"to\" scope and\n" +
+			"  configuration. Each leaf that differs is shown together with its sources. The patterns restrict the\n" +
+			"  keys in the same way as for --list.",
+		PreRun: initialize,
+		RunE:   cmdDiff}
+
+	flags := cmd.Flags()
+	flags.StringVar(&fromConfig, `from-config`, ``, `path to the hiera config file of the "from" side. Overrides --config`)
+	flags.StringVar(&toConfig, `to-config`, ``, `path to the hiera config file of the "to" side. Overrides --config`)
+	flags.StringArrayVar(&fromVars, `from-vars`, nil, `path to a JSON or YAML file with variables of the "from" side in addition to --vars`)
+	flags.StringArrayVar(&toVars, `to-vars`, nil, `path to a JSON or YAML file with variables of the "to" side in addition to --vars`)
+	flags.BoolVar(&cmdOpts.ShowSensitive, `show-sensitive`, false, `Reveal Sensitive values that differ`)
+	flags.BoolVar(&diffExitCode, `exit-code`, false, `Exit with status 1 when there are differences and with status 2 when the comparison fails`)
+	return cmd
+}
+
+func cmdDiff(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	fail := func(err error) error {
+		if diffExitCode {
+			return &exitError{err, 2}
+		}
+		return err
+	}
+	if len(fromVars) == 0 && len(toVars) == 0 && fromConfig == `` && toConfig == `` {
+		return fail(errors.New(`diff requires at least one of --from-vars, --to-vars, --from-config, or --to-config`))
+	}
+
+	effective := func(configPath string, vars []string, then func(c px.Context, data *hiera.EffectiveData)) error {
+		if configPath == `` {
+			configPath = config
+		}
+		opts := cmdOpts
+		opts.VarPaths = append(append([]string{}, cmdOpts.VarPaths...), vars...)
+		return hiera.TryWithParent(context.Background(), provider.MuxLookupKey, configOptions(configPath), func(c px.Context) error {
+			c.Set(`logLevel`, px.LogLevelFromString(logLevel))
+			then(c, hiera.Effective(c, &opts, args))
+			return nil
+		})
+	}
+
+	var changes []*hiera.Change
+	err := effective(fromConfig, fromVars, func(_ px.Context, from *hiera.EffectiveData) {
+		err := effective(toConfig, toVars, func(c px.Context, to *hiera.EffectiveData) {
+			changes = hiera.Diff(from, to)
+			renderAs := hiera.Text
+			if cmdOpts.RenderAs != `` {
+				renderAs = hiera.RenderName(cmdOpts.RenderAs)
+			}
+			hiera.RenderDiff(c, renderAs, changes, cmdOpts.ShowSensitive, cmd.OutOrStdout())
+		})
+		if err != nil {
+			panic(err)
+		}
+	})
+	switch {
+	case err != nil:
+		return fail(err)
+	case diffExitCode && len(changes) > 0:
+		return errDifferences
+	}
+	return nil
+}
@@ -13,6 +13,26 @@ func ExecuteLookup(args ...string) (output []byte, err error) {
 	logLevel = ``
 	config = ``
 	facts = nil
+	strict = false
+	allowEnv = false
+	fileDirs = nil
+	list = false
+	all = false
+	fromVars = nil
+	toVars = nil
+	fromConfig = ``
+	toConfig = ``
+	diffExitCode = false
+	varsDir = ``
+	allFiles = false
+	level = ``
+	fromLevel = ``
+	toLevel = ``
+	dryRun = false
+	fixturesFile = ``
+	pluginTransport = ``
+	pluginCallTimeout = 0
+	pluginShutdownTimeout = 0
 
 	cmd := NewCommand()
 	buf := new(bytes.Buffer)
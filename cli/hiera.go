@@ -68,6 +68,11 @@ var (
 	logLevel string
 	config   string
 	facts    []string
+	strict   bool
+	allowEnv bool
+	fileDirs []string
+	list     bool
+	all      bool
 )
 
 func NewCommand() *cobra.Command {
@@ -78,44 +83,102 @@ func NewCommand() *cobra.Command {
 		Version: fmt.Sprintf("%v", getVersion()),
 		PreRun:  initialize,
 		RunE:    cmdLookup,
-		Args:    cobra.MinimumNArgs(1)}
+		Args:    lookupArgs,
+
+		// The caller writes errors on stderr so that the output of a command that fails to signal an exit code,
+		// e.g. "diff --exit-code", consists of its rendered result only
+		SilenceErrors: true}
+
+	// Flags that are shared by all subcommands
+	pflags := cmd.PersistentFlags()
+	pflags.StringVar(&logLevel, `loglevel`, `error`, `error/warn/info/debug`)
+	pflags.StringVar(&config, `config`, ``, `path to the hiera config file. Overrides <current directory>/hiera.yaml`)
+	pflags.StringArrayVar(&cmdOpts.VarPaths, `vars`, nil, `path to a JSON or YAML file that contains key-value mappings to become variables`)
+	pflags.StringArrayVar(&cmdOpts.Variables, `var`, nil, `a key:value or key=value where value is literal expressed using Puppet DSL`)
+	pflags.StringVar(&cmdOpts.RenderAs, `render-as`, ``, `s/json/yaml/binary: Specify the output format of the results; s means plain text`)
+	pflags.BoolVar(&strict, `strict-interpolation`, false, `make interpolation expressions that cannot be resolved an error unless they have a default`)
+	pflags.BoolVar(&allowEnv, `allow-env`, false, `enable the interpolation function env()`)
+	pflags.StringArrayVar(&fileDirs, `allow-file-dir`, nil, `a directory that the interpolation function file() may read from. Relative to the directory of the hiera config`)
 
 	flags := cmd.Flags()
-	flags.StringVar(&logLevel, `loglevel`, `error`, `error/warn/info/debug`)
-	flags.StringVar(&cmdOpts.Merge, `merge`, `first`, `first/unique/hash/deep`)
-	flags.StringVar(&config, `config`, ``, `path to the hiera config file. Overrides <current directory>/hiera.yaml`)
+	flags.StringVar(&cmdOpts.Merge, `merge`, `first`, `first/unique/hash/deep or the name of a registered merge strategy`)
 	flags.Var(&dflt, `default`, `a value to return if Hiera can't find a value in data`)
 	flags.StringVar(&cmdOpts.Type, `type`, `Any`, `assert that the value has the specified type`)
-	flags.StringVar(&cmdOpts.RenderAs, `render-as`, ``, `s/json/yaml/binary: Specify the output format of the results; s means plain text`)
 	flags.BoolVar(&cmdOpts.ExplainData, `explain`, false, `Explain the details of how the lookup was performed and where the final value came from (or the reason no value was found)`)
 	flags.BoolVar(&cmdOpts.ExplainOptions, `explain-options`, false, `Explain whether a lookup_options hash affects this lookup, and how that hash was assembled`)
-	flags.StringArrayVar(&cmdOpts.VarPaths, `vars`, nil, `path to a JSON or YAML file that contains key-value mappings to become variables for this lookup`)
-	flags.StringArrayVar(&cmdOpts.Variables, `var`, nil, `a key:value or key=value where value is literal expressed using Puppet DSL`)
+	flags.BoolVar(&cmdOpts.ShowSources, `show-sources`, false, `Annotate each value in the yaml output with the hierarchy entry and location where it was found`)
 	flags.StringArrayVar(&facts, `facts`, nil, `alias for --vars for compatibility with Puppet's ruby version of Hiera`)
-
+	flags.BoolVar(&list, `list`, false, `List the keys that start with the given prefix or match the given glob pattern, together with the hierarchy entries that define them`)
+	flags.BoolVar(&all, `all`, false, `Look up all keys that start with the given prefix or match the given glob pattern and render them as one hash`)
+	flags.BoolVar(&cmdOpts.ShowSensitive, `show-sensitive`, false, `Reveal Sensitive values when looking up all keys`)
+
+	cmd.AddCommand(newPluginCommand())
+	cmd.AddCommand(newDiffCommand())
+	cmd.AddCommand(newMatrixCommand())
+	cmd.AddCommand(newValidateCommand())
+	cmd.AddCommand(newLintCommand())
+	cmd.AddCommand(newSetCommand())
+	cmd.AddCommand(newUnsetCommand())
+	cmd.AddCommand(newMvCommand())
+	cmd.AddCommand(newMoveCommand())
 	cmd.SetHelpTemplate(helpTemplate)
 	return cmd
 }
 
+// lookupArgs validates the arguments. A lookup needs at least one key whereas a listing or a lookup of all keys
+// takes an optional pattern
+func lookupArgs(cmd *cobra.Command, args []string) error {
+	if list || all {
+		return cobra.MaximumNArgs(1)(cmd, args)
+	}
+	return cobra.MinimumNArgs(1)(cmd, args)
+}
+
 func initialize(_ *cobra.Command, _ []string) {
 	issue.IncludeStacktrace(logLevel == `debug`)
 }
 
-func cmdLookup(cmd *cobra.Command, args []string) error {
-	cmd.SilenceUsage = true
-	cmdOpts.Default = dflt.StringPointer()
-	configOptions := map[string]px.Value{
+// configOptions returns the global options for a lookup that uses the given hiera configuration file
+func configOptions(configPath string) map[string]px.Value {
+	options := map[string]px.Value{
 		provider.LookupKeyFunctions: types.WrapRuntime([]hieraapi.LookupKey{provider.ConfigLookupKey, provider.Environment})}
 
-	if config != `` {
-		configOptions[hieraapi.HieraConfig] = types.WrapString(config)
+	if configPath != `` {
+		options[hieraapi.HieraConfig] = types.WrapString(configPath)
+	}
+	if strict {
+		options[hieraapi.HieraStrictInterpolation] = types.BooleanTrue
 	}
+	if allowEnv {
+		options[hieraapi.HieraEnvInterpolation] = types.BooleanTrue
+	}
+	if len(fileDirs) > 0 {
+		options[hieraapi.HieraFileInterpolationDirs] = types.WrapStrings(fileDirs)
+	}
+	return options
+}
+
+func cmdLookup(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	cmdOpts.Default = dflt.StringPointer()
 	if len(facts) > 0 {
 		cmdOpts.VarPaths = append(cmdOpts.VarPaths, facts...)
 	}
 
-	return hiera.TryWithParent(context.Background(), provider.MuxLookupKey, configOptions, func(c px.Context) error {
+	return hiera.TryWithParent(context.Background(), provider.MuxLookupKey, configOptions(config), func(c px.Context) error {
 		c.Set(`logLevel`, px.LogLevelFromString(logLevel))
+		if list || all {
+			pattern := ``
+			if len(args) > 0 {
+				pattern = args[0]
+			}
+			if list {
+				hiera.ListAndRender(c, &cmdOpts, pattern, cmd.OutOrStdout())
+			} else {
+				hiera.LookupAllAndRender(c, &cmdOpts, pattern, cmd.OutOrStdout())
+			}
+			return nil
+		}
 		hiera.LookupAndRender(c, &cmdOpts, args, cmd.OutOrStdout())
 		return nil
 	})
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lyraproj/hiera/hiera"
+	"github.com/lyraproj/hiera/provider"
+	"github.com/lyraproj/pcore/px"
+	"github.com/spf13/cobra"
+)
+
+// newLintCommand returns the "lint" command
+func newLintCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: `Find redundant, shadowed, and unused data`,
+		Long: "Find redundant, shadowed, and unused data.\n" +
+			"  All data files that match the path templates of the hierarchy are examined. Keys defined with the same\n" +
+			"  value as at a higher level for every scope that reaches them, definitions that never win under the first merge strategy for any scope in\n" +
+			"  the scope set, lookup_options for keys that do not exist, and interpolations that refer to unknown scope\n" +
+			"  variables or keys are reported. The scope set is given by --vars-dir, one scope per file.",
+		PreRun: initialize,
+		RunE:   cmdLint,
+		Args:   cobra.NoArgs}
+
+	flags := cmd.Flags()
+	flags.StringVar(&varsDir, `vars-dir`, ``, `path to a directory with one JSON or YAML file of variables for each scope in the scope set`)
+	return cmd
+}
+
+func cmdLint(cmd *cobra.Command, _ []string) error {
+	cmd.SilenceUsage = true
+	count := 0
+	err := hiera.TryWithParent(context.Background(), provider.MuxLookupKey, configOptions(config), func(c px.Context) error {
+		c.Set(`logLevel`, px.LogLevelFromString(logLevel))
+		count = hiera.LintAndRender(c, &cmdOpts, varsDir, cmd.OutOrStdout())
+		return nil
+	})
+	if err == nil && count > 0 {
+		err = &exitError{fmt.Errorf(`%d finding(s)`, count), 1}
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/lyraproj/hiera/hiera"
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/hiera/provider"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/spf13/cobra"
+)
+
+var varsDir string
+
+// newMatrixCommand returns the "matrix" command
+func newMatrixCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "matrix <key>",
+		Short: `Look up a key once for each variables file in a directory`,
+		Long: "Look up a key once for each variables file in a directory.\n" +
+			"  Each YAML or JSON file in the directory given by --vars-dir is the scope of one node, named after the\n" +
+			"  file. All lookups share the resolved configuration and the data read from files. The nodes are grouped\n" +
+			"  by the value that was found.",
+		PreRun: initialize,
+		RunE:   cmdMatrix,
+		Args:   cobra.ExactArgs(1)}
+
+	flags := cmd.Flags()
+	flags.StringVar(&cmdOpts.Merge, `merge`, `first`, `first/unique/hash/deep or the name of a registered merge strategy`)
+	flags.StringVar(&varsDir, `vars-dir`, ``, `path to a directory with one JSON or YAML file of variables for each node`)
+	_ = cmd.MarkFlagRequired(`vars-dir`)
+	return cmd
+}
+
+func cmdMatrix(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	options := configOptions(config)
+	options[hieraapi.HieraShareData] = types.BooleanTrue
+	return hiera.TryWithParent(context.Background(), provider.MuxLookupKey, options, func(c px.Context) error {
+		c.Set(`logLevel`, px.LogLevelFromString(logLevel))
+		hiera.MatrixAndRender(c, &cmdOpts, args[0], varsDir, cmd.OutOrStdout())
+		return nil
+	})
+}
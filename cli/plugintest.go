@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/lyraproj/hiera/plugintest"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	fixturesFile          string
+	pluginTransport       string
+	pluginCallTimeout     time.Duration
+	pluginShutdownTimeout time.Duration
+)
+
+// newPluginCommand returns the "plugin" command and its sub commands
+func newPluginCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plugin",
+		Short: `Commands for plugin authors`,
+		Long:  "Commands for plugin authors."}
+
+	testCmd := &cobra.Command{
+		Use:   "test <binary>",
+		Short: `Test that a plugin conforms to the Hiera plugin protocol`,
+		Long: "Test that a plugin conforms to the Hiera plugin protocol.\n" +
+			"  The plugin is started, its meta-info is validated, each advertised function is called, and the\n" +
+			"  plugin is then expected to terminate gracefully when it receives a SIGINT. A plugin that uses the stdio\n" +
+			"  transport should terminate when its stdin is closed.",
+		RunE: cmdPluginTest,
+		Args: cobra.ExactArgs(1)}
+
+	flags := testCmd.Flags()
+	flags.StringVar(&fixturesFile, `fixtures`, ``, `path to a YAML or JSON file with an array of fixtures`)
+	flags.StringVar(&pluginTransport, `transport`, `unix`, `unix/tcp/stdio: the transport that the plugin is asked to use`)
+	flags.DurationVar(&pluginCallTimeout, `call-timeout`, plugintest.CallTimeout, `the time that a call to a plugin function may take`)
+	flags.DurationVar(&pluginShutdownTimeout, `shutdown-timeout`, plugintest.ShutdownTimeout, `the time that the plugin may take to terminate`)
+
+	cmd.AddCommand(testCmd)
+	return cmd
+}
+
+func cmdPluginTest(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	var fixtures []plugintest.Fixture
+	if fixturesFile != `` {
+		bs, err := ioutil.ReadFile(fixturesFile)
+		if err != nil {
+			return err
+		}
+		if err = yaml.Unmarshal(bs, &fixtures); err != nil {
+			return fmt.Errorf(`unable to read fixtures from %s: %s`, fixturesFile, err.Error())
+		}
+	}
+
+	r, err := plugintest.Run(args[0], &plugintest.Options{
+		Transport:       pluginTransport,
+		CallTimeout:     pluginCallTimeout,
+		ShutdownTimeout: pluginShutdownTimeout}, fixtures...)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	for _, p := range r.Passed {
+		_, _ = fmt.Fprintln(out, `ok:        `+p)
+	}
+	for _, w := range r.Warnings {
+		_, _ = fmt.Fprintln(out, `warning:   `+w)
+	}
+	for _, v := range r.Violations {
+		_, _ = fmt.Fprintln(out, `violation: `+v)
+	}
+	if !r.OK() {
+		return errors.New(`plugin does not conform to the Hiera plugin protocol`)
+	}
+	return nil
+}
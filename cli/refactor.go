@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/lyraproj/hiera/hiera"
+	"github.com/lyraproj/hiera/provider"
+	"github.com/lyraproj/pcore/px"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fromLevel string
+	toLevel   string
+	dryRun    bool
+)
+
+// newMvCommand returns the "mv" command
+func newMvCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mv <old key> <new key>",
+		Short: `Rename a key in all data files`,
+		Long: "Rename a key in all data files.\n" +
+			"  All data files that match the path, glob, or mapped_paths templates of the hierarchy are edited. Either key may\n" +
+			"  be dotted. Interpolations that use lookup, hiera, or alias to look up the key, alias_of declarations, and the\n" +
+			"  lookup_options of the key are updated too. Use --dry-run to see a diff of the changes without writing them.",
+		PreRun: initialize,
+		RunE:   cmdMv,
+		Args:   cobra.ExactArgs(2)}
+
+	addRefactorFlags(cmd)
+	return cmd
+}
+
+// newMoveCommand returns the "move" command
+func newMoveCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "move <key>",
+		Short: `Move a key from the data files of one hierarchy entry to those of another`,
+		Long: "Move a key from the data files of one hierarchy entry to those of another.\n" +
+			"  Each data file of the --from entry that sets the key is paired with the data file of the --to entry that is\n" +
+			"  selected by the same interpolation values. The destination files are created when needed and source files\n" +
+			"  that have no data left are deleted. Files that share a destination must agree on the value. Use --dry-run to see a diff of the changes without writing them.",
+		PreRun: initialize,
+		RunE:   cmdMove,
+		Args:   cobra.ExactArgs(1)}
+
+	addRefactorFlags(cmd)
+	flags := cmd.Flags()
+	flags.StringVar(&fromLevel, `from`, ``, `name of the hierarchy entry that the key is moved from`)
+	flags.StringVar(&toLevel, `to`, ``, `name of the hierarchy entry that the key is moved to`)
+	_ = cmd.MarkFlagRequired(`from`)
+	_ = cmd.MarkFlagRequired(`to`)
+	return cmd
+}
+
+func addRefactorFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.BoolVar(&dryRun, `dry-run`, false, `show a diff of the changes instead of writing them`)
+}
+
+func cmdMv(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	return hiera.TryWithParent(context.Background(), provider.MuxLookupKey, configOptions(config), func(c px.Context) error {
+		c.Set(`logLevel`, px.LogLevelFromString(logLevel))
+		hiera.RenameAndRender(c, args[0], args[1], dryRun, cmd.OutOrStdout())
+		return nil
+	})
+}
+
+func cmdMove(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	return hiera.TryWithParent(context.Background(), provider.MuxLookupKey, configOptions(config), func(c px.Context) error {
+		c.Set(`logLevel`, px.LogLevelFromString(logLevel))
+		hiera.MoveAndRender(c, args[0], fromLevel, toLevel, dryRun, cmd.OutOrStdout())
+		return nil
+	})
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/lyraproj/hiera/hiera"
+	"github.com/lyraproj/hiera/provider"
+	"github.com/lyraproj/pcore/px"
+	"github.com/spf13/cobra"
+)
+
+var level string
+
+// newSetCommand returns the "set" command
+func newSetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: `Set the value of a key in the data file of a hierarchy entry`,
+		Long: "Set the value of a key in the data file of a hierarchy entry.\n" +
+			"  The hierarchy entry given by --level is resolved to its data file for the scope given by --vars and --var.\n" +
+			"  The key may be dotted. A value that is quoted or starts with '[' or '{' is a literal expressed using Puppet\n" +
+			"  DSL, any other value is a YAML scalar. Comments, ordering, and anchors of YAML files are preserved. The\n" +
+			"  file is created when it doesn't exist and the path template allows it.",
+		PreRun: initialize,
+		RunE:   cmdSet,
+		Args:   cobra.ExactArgs(2)}
+
+	addEditFlags(cmd)
+	return cmd
+}
+
+// newUnsetCommand returns the "unset" command
+func newUnsetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unset <key>",
+		Short: `Remove a key from the data file of a hierarchy entry`,
+		Long: "Remove a key from the data file of a hierarchy entry.\n" +
+			"  The hierarchy entry given by --level is resolved to its data file for the scope given by --vars and --var.\n" +
+			"  The key may be dotted. Comments, ordering, and anchors of YAML files are preserved.",
+		PreRun: initialize,
+		RunE:   cmdUnset,
+		Args:   cobra.ExactArgs(1)}
+
+	addEditFlags(cmd)
+	return cmd
+}
+
+func addEditFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&level, `level`, ``, `name of the hierarchy entry whose data file is edited`)
+	_ = cmd.MarkFlagRequired(`level`)
+}
+
+func cmdSet(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	return hiera.TryWithParent(context.Background(), provider.MuxLookupKey, configOptions(config), func(c px.Context) error {
+		c.Set(`logLevel`, px.LogLevelFromString(logLevel))
+		hiera.SetAndRender(c, &cmdOpts, level, args[0], args[1], cmd.OutOrStdout())
+		return nil
+	})
+}
+
+func cmdUnset(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	return hiera.TryWithParent(context.Background(), provider.MuxLookupKey, configOptions(config), func(c px.Context) error {
+		c.Set(`logLevel`, px.LogLevelFromString(logLevel))
+		hiera.UnsetAndRender(c, &cmdOpts, level, args[0], cmd.OutOrStdout())
+		return nil
+	})
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lyraproj/hiera/hiera"
+	"github.com/lyraproj/hiera/provider"
+	"github.com/lyraproj/pcore/px"
+	"github.com/spf13/cobra"
+)
+
+var allFiles bool
+
+// newValidateCommand returns the "validate" command
+func newValidateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: `Validate the hiera configuration and the data files that it references`,
+		Long: "Validate the hiera configuration and the data files that it references.\n" +
+			"  The configuration is checked against the Hiera type set. Each data file that the yaml_data and json_data\n" +
+			"  functions read for the given scope, or each file that matches the path templates when --all-files is\n" +
+			"  given, is parsed and its values are checked against RichData and the convert_to types declared in\n" +
+			"  lookup_options. All problems are reported with their file positions.",
+		PreRun: initialize,
+		RunE:   cmdValidate,
+		Args:   cobra.NoArgs}
+
+	flags := cmd.Flags()
+	flags.BoolVar(&allFiles, `all-files`, false, `validate all files that match the path templates of the hierarchy rather than the files of the given scope`)
+	return cmd
+}
+
+func cmdValidate(cmd *cobra.Command, _ []string) error {
+	cmd.SilenceUsage = true
+	count := 0
+	err := hiera.TryWithParent(context.Background(), provider.MuxLookupKey, configOptions(config), func(c px.Context) error {
+		c.Set(`logLevel`, px.LogLevelFromString(logLevel))
+		count = hiera.ValidateAndRender(c, &cmdOpts, allFiles, cmd.OutOrStdout())
+		return nil
+	})
+	if err == nil && count > 0 {
+		err = &exitError{fmt.Errorf(`%d problem(s) found`, count), 1}
+	}
+	return err
+}
@@ -50,6 +50,7 @@ func TestExplain(t *testing.T) {
           Sub key: "y"
             Found key: "y" value: 20
         Found key: "ipl_c" value: 'x = 10, y = 20'
+        Defined at testdata/data/common.yaml:3
     Merged result: 'x = 10, y = 20'`)
 
 		actualExplanation := explainer.String()
@@ -102,6 +103,7 @@ Searching for "ipl_c"
           Sub key: "y"
             Found key: "y" value: 20
         Found key: "ipl_c" value: 'x = 10, y = 20'
+        Defined at testdata/data/common.yaml:3
     Merged result: 'x = 10, y = 20'`)
 
 		actualExplanation := explainer.String()
@@ -2,12 +2,17 @@ package examples_test
 
 import (
 	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/lyraproj/issue/issue"
 
 	"github.com/lyraproj/dgo/util"
 
+	"github.com/lyraproj/hiera/explain"
 	"github.com/lyraproj/hiera/hiera"
 	"github.com/lyraproj/hiera/hieraapi"
 	"github.com/lyraproj/hiera/internal"
@@ -91,3 +96,153 @@ func TestMerge_deep(t *testing.T) {
 		}
 	})
 }
+
+// TestMerge_registered shows how an application can register a merge strategy of its own. The strategy
+// can then be selected by name, both in the options of a lookup and in lookup_options.
+func TestMerge_registered(t *testing.T) {
+	// The "last" strategy lets the value found in the location with the lowest priority win
+	hieraapi.RegisterMergeStrategy(`last`, func(opts map[string]px.Value) hieraapi.MergeStrategy {
+		return hieraapi.NewMergeStrategy(`last`, `last found strategy`, func(a, b px.Value) px.Value { return b }, opts)
+	})
+	defer hieraapi.UnregisterMergeStrategy(`last`)
+
+	configOptions := map[string]px.Value{hieraapi.HieraConfig: types.WrapString(`testdata/merge.yaml`)}
+	hiera.DoWithParent(context.Background(), provider.ConfigLookupKey, configOptions, func(c px.Context) {
+		opts := map[string]px.Value{`merge`: types.WrapString(`last`)}
+
+		result := hiera.Lookup(hiera.NewInvocation(c, nil, nil), `m.a`, nil, opts)
+		if result == nil || `second value of a` != result.String() {
+			t.Fatalf("unexpected result %v", result)
+		}
+
+		explainer := explain.NewExplainer(false, false)
+		hiera.Lookup(internal.NewInvocation(c, nil, explainer), `m`, nil, opts)
+		if !strings.Contains(explainer.String(), `Merge strategy "last found strategy"`) {
+			t.Fatalf("unexpected explanation %s", explainer.String())
+		}
+
+		// An unknown strategy is still an error
+		err := util.Catch(func() {
+			hiera.Lookup(hiera.NewInvocation(c, nil, nil), `m`, nil, map[string]px.Value{`merge`: types.WrapString(`first_and_last`)})
+		})
+		re, ok := err.(issue.Reported)
+		if !(ok && re.Code() == hieraapi.UnknownMergeStrategy) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
+
+// TestMerge_registeredLookupOptions shows how lookup_options in data selects a registered merge strategy. The
+// strategy must be registered when the key is looked up.
+func TestMerge_registeredLookupOptions(t *testing.T) {
+	configOptions := map[string]px.Value{hieraapi.HieraConfig: types.WrapString(`testdata/merge.yaml`)}
+	hiera.DoWithParent(context.Background(), provider.ConfigLookupKey, configOptions, func(c px.Context) {
+		hieraapi.RegisterMergeStrategy(`last`, func(opts map[string]px.Value) hieraapi.MergeStrategy {
+			return hieraapi.NewMergeStrategy(`last`, `last found strategy`, func(a, b px.Value) px.Value { return b }, opts)
+		})
+		result := hiera.Lookup(hiera.NewInvocation(c, nil, nil), `l`, nil, nil)
+		if result == nil || `second value of l` != result.String() {
+			t.Fatalf("unexpected result %v", result)
+		}
+
+		hieraapi.UnregisterMergeStrategy(`last`)
+		err := util.Catch(func() {
+			hiera.Lookup(hiera.NewInvocation(c, nil, nil), `l`, nil, nil)
+		})
+		re, ok := err.(issue.Reported)
+		if !(ok && re.Code() == hieraapi.UnknownMergeStrategy) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
+
+// TestMerge_deepHashArraysBy shows how the deep merge option "merge_hash_arrays_by" merges hashes
+// in arrays that have the same value for a given key. Elements keep the order of the hierarchy.
+func TestMerge_deepHashArraysBy(t *testing.T) {
+	configOptions := map[string]px.Value{hieraapi.HieraConfig: types.WrapString(`testdata/merge.yaml`)}
+	hiera.DoWithParent(context.Background(), provider.ConfigLookupKey, configOptions, func(c px.Context) {
+		opts := map[string]px.Value{`merge`: px.Wrap(c, map[string]string{
+			`strategy`: `deep`, `merge_hash_arrays_by`: `name`})}
+
+		result := hiera.Lookup(hiera.NewInvocation(c, nil, nil), `users`, nil, opts)
+		expected := px.Wrap(c, []map[string]interface{}{
+			{`name`: `alice`, `shell`: `/bin/zsh`, `groups`: []string{`dev`}},
+			{`name`: `bob`, `groups`: []string{`admin`}},
+			{`name`: `carol`, `shell`: `/bin/sh`}})
+		if !expected.Equals(result, nil) {
+			t.Fatalf("unexpected result %v", result)
+		}
+	})
+}
+
+func TestMerge_sources(t *testing.T) {
+	configOptions := map[string]px.Value{hieraapi.HieraConfig: types.WrapString(`testdata/merge.yaml`)}
+	hiera.DoWithParent(context.Background(), provider.ConfigLookupKey, configOptions, func(c px.Context) {
+		opts := map[string]px.Value{`merge`: px.Wrap(c, map[string]string{
+			`strategy`: `deep`, `merge_hash_arrays_by`: `name`})}
+
+		_, sources := hiera.LookupWithSources(hiera.NewInvocation(c, nil, nil), `users`, nil, opts)
+		actual := make(map[string]string, len(sources))
+		for _, ls := range sources {
+			actual[ls.PathString()] = fmt.Sprintf(`%s %s:%d`, ls.Source.Entry, filepath.Base(ls.Source.Location), ls.Source.Line)
+		}
+		expected := map[string]string{
+			`0.name`:     `First merge1.yaml:6`,
+			`0.shell`:    `First merge1.yaml:7`,
+			`0.groups.0`: `Second merge2.yaml:14`,
+			`1.name`:     `First merge1.yaml:8`,
+			`1.groups.0`: `First merge1.yaml:9`,
+			`2.name`:     `Second merge2.yaml:10`,
+			`2.shell`:    `Second merge2.yaml:11`}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("unexpected sources %v", actual)
+		}
+	})
+}
+
+func TestMerge_sourcesDottedKey(t *testing.T) {
+	configOptions := map[string]px.Value{hieraapi.HieraConfig: types.WrapString(`testdata/merge.yaml`)}
+	hiera.DoWithParent(context.Background(), provider.ConfigLookupKey, configOptions, func(c px.Context) {
+		opts := map[string]px.Value{`merge`: types.WrapString(`hash`)}
+		v, sources := hiera.LookupWithSources(hiera.NewInvocation(c, nil, nil), `m.b`, nil, opts)
+		if v == nil || v.String() != `second value of b` {
+			t.Fatalf("unexpected result %v", v)
+		}
+		if len(sources) != 1 || sources[0].PathString() != `` || sources[0].Source.Entry != `Second` {
+			t.Fatalf("unexpected sources %v", sources)
+		}
+	})
+}
+
+// TestMerge_sourcesEqualValues shows that a leaf is attributed to the layer that it was merged from, also when
+// another layer contains an equal value.
+func TestMerge_sourcesEqualValues(t *testing.T) {
+	configOptions := map[string]px.Value{hieraapi.HieraConfig: types.WrapString(`testdata/merge.yaml`)}
+	hiera.DoWithParent(context.Background(), provider.ConfigLookupKey, configOptions, func(c px.Context) {
+		sourcesOf := func(key, strategy string) map[string]string {
+			opts := map[string]px.Value{`merge`: types.WrapString(strategy)}
+			_, sources := hiera.LookupWithSources(hiera.NewInvocation(c, nil, nil), key, nil, opts)
+			actual := make(map[string]string, len(sources))
+			for _, ls := range sources {
+				actual[ls.PathString()] = fmt.Sprintf(`%s %s:%d`, ls.Source.Entry, filepath.Base(ls.Source.Location), ls.Source.Line)
+			}
+			return actual
+		}
+
+		expected := map[string]string{
+			`x`: `First merge1.yaml:18`,
+			`y`: `Second merge2.yaml:20`}
+		if actual := sourcesOf(`same`, `deep`); !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("unexpected sources %v", actual)
+		}
+
+		// The "a" of the first layer is nested in an array that the unique merge flattens
+		expected = map[string]string{
+			`0`: `First merge1.yaml:20`,
+			`1`: `First merge1.yaml:21`,
+			`2`: `Second merge2.yaml:21`}
+		if actual := sourcesOf(`tags`, `unique`); !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("unexpected sources %v", actual)
+		}
+	})
+}
@@ -6,11 +6,21 @@ require (
 	github.com/lyraproj/hierasdk v0.2.0
 	github.com/lyraproj/issue v0.0.0-20190606092846-e082d6813d15
 	github.com/lyraproj/pcore v0.0.0-20191009094231-d24a2ffc5639
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v0.0.4
 	github.com/stretchr/testify v1.3.0
-	golang.org/x/sys v0.0.0-20190804053845-51ab0e2deafa // indirect
+	github.com/tetratelabs/wazero v1.8.2
+	golang.org/x/sys v0.20.0
 	gopkg.in/yaml.v3 v3.0.0-20190905181640-827449938966
 )
 
-go 1.13
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	github.com/lyraproj/semver v0.0.0-20181213164306-02ecea2cd6a2 // indirect
+	github.com/spf13/pflag v1.0.3 // indirect
+)
+
+go 1.21
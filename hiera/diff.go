@@ -0,0 +1,287 @@
+package hiera
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/hiera/internal"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/pcore/utils"
+)
+
+// EffectiveData is the effective values of a set of keys together with the sources of their leaves
+type EffectiveData struct {
+	// Values is a hash with the keys that were found and their values
+	Values px.OrderedMap
+
+	// Sources are the sources of the leaves of each value, keyed by the key of the value
+	Sources map[string][]hieraapi.LeafSource
+}
+
+// Effective looks up all keys that match any of the given patterns using the scope given by the command options
+// and returns their values together with the sources of their leaves. The patterns are interpreted in the same way
+// as by ListAndRender. All keys are looked up when no pattern is given.
+func Effective(c px.Context, opts *CommandOptions, patterns []string) *EffectiveData {
+	ic := internal.NewInvocation(c, createScope(c, opts), nil)
+	if len(patterns) == 0 {
+		patterns = []string{``}
+	}
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	for _, pattern := range patterns {
+		for _, ks := range keysMatching(ic, pattern) {
+			if !seen[ks.Key] {
+				seen[ks.Key] = true
+				keys = append(keys, ks.Key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	values, sources := internal.LookupAllWithSources(ic, keys)
+	return &EffectiveData{Values: values, Sources: sources}
+}
+
+// ChangeKind describes how a leaf differs between two sets of effective data
+type ChangeKind string
+
+const (
+	Added   = ChangeKind(`added`)
+	Removed = ChangeKind(`removed`)
+	Changed = ChangeKind(`changed`)
+)
+
+// A Change is a difference between the effective value of a key, or of a leaf within that value, in two sets
+// of effective data
+type Change struct {
+	// Kind tells if the leaf was added, removed, or changed
+	Kind ChangeKind
+
+	// Key is the key that was looked up
+	Key string
+
+	// Path is the path to the leaf within the value of the key. It is empty when the whole value differs.
+	Path []interface{}
+
+	// From is the value in the first set of data or nil when the leaf was added
+	From px.Value
+
+	// To is the value in the second set of data or nil when the leaf was removed
+	To px.Value
+
+	// FromSource is the source of the value in the first set of data, if known
+	FromSource *hieraapi.Source
+
+	// ToSource is the source of the value in the second set of data, if known
+	ToSource *hieraapi.Source
+}
+
+// PathString returns the key and the path of the leaf in dotted key notation
+func (ch *Change) PathString() string {
+	ps := make([]string, len(ch.Path)+1)
+	ps[0] = ch.Key
+	for i, p := range ch.Path {
+		ps[i+1] = fmt.Sprint(p)
+	}
+	return strings.Join(ps, `.`)
+}
+
+// Diff compares two sets of effective data and returns the changes, ordered by key. Hashes are compared entry by
+// entry and arrays of equal length element by element. Any other difference is a change of the value as a whole.
+// Sensitive values are compared by their unwrapped values.
+func Diff(from, to *EffectiveData) []*Change {
+	keys := make(map[string]bool)
+	from.Values.EachKey(func(k px.Value) { keys[k.String()] = true })
+	to.Values.EachKey(func(k px.Value) { keys[k.String()] = true })
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	d := &differ{from: from, to: to}
+	for _, k := range names {
+		var fv, tv px.Value
+		if v, ok := from.Values.Get4(k); ok {
+			fv = v
+		}
+		if v, ok := to.Values.Get4(k); ok {
+			tv = v
+		}
+		d.diff(k, nil, fv, tv)
+	}
+	return d.changes
+}
+
+type differ struct {
+	from    *EffectiveData
+	to      *EffectiveData
+	changes []*Change
+}
+
+func (d *differ) diff(key string, path []interface{}, fv, tv px.Value) {
+	switch {
+	case fv == nil && tv == nil:
+		return
+	case fv == nil:
+		d.add(Added, key, path, fv, tv)
+		return
+	case tv == nil:
+		d.add(Removed, key, path, fv, tv)
+		return
+	}
+
+	if fh, ok := fv.(*types.Hash); ok {
+		if th, ok := tv.(*types.Hash); ok {
+			seen := make(map[string]bool)
+			fh.EachPair(func(k, fe px.Value) {
+				seen[k.String()] = true
+				var te px.Value
+				if v, ok := th.Get(k); ok {
+					te = v
+				}
+				d.diff(key, appendPath(path, k), fe, te)
+			})
+			th.EachPair(func(k, te px.Value) {
+				if !seen[k.String()] {
+					d.diff(key, appendPath(path, k), nil, te)
+				}
+			})
+			return
+		}
+	}
+	if fa, ok := fv.(*types.Array); ok {
+		if ta, ok := tv.(*types.Array); ok && fa.Len() == ta.Len() {
+			fa.EachWithIndex(func(fe px.Value, i int) {
+				d.diff(key, append(path[:len(path):len(path)], i), fe, ta.At(i))
+			})
+			return
+		}
+	}
+	if !unwrapSensitive(fv).Equals(unwrapSensitive(tv), nil) {
+		d.add(Changed, key, path, fv, tv)
+	}
+}
+
+func (d *differ) add(kind ChangeKind, key string, path []interface{}, fv, tv px.Value) {
+	ch := &Change{Kind: kind, Key: key, Path: path, From: fv, To: tv}
+	if fv != nil {
+		ch.FromSource = sourceAt(d.from.Sources[key], path)
+	}
+	if tv != nil {
+		ch.ToSource = sourceAt(d.to.Sources[key], path)
+	}
+	d.changes = append(d.changes, ch)
+}
+
+func appendPath(path []interface{}, k px.Value) []interface{} {
+	var pe interface{}
+	if i, ok := k.(px.Integer); ok {
+		pe = int(i.Int())
+	} else {
+		pe = k.String()
+	}
+	return append(path[:len(path):len(path)], pe)
+}
+
+func unwrapSensitive(v px.Value) px.Value {
+	if s, ok := v.(*types.Sensitive); ok {
+		return s.Unwrap()
+	}
+	return v
+}
+
+// sourceAt returns the source of the leaf at the given path, or of the first leaf below that path when the path
+// denotes a hash or an array
+func sourceAt(leaves []hieraapi.LeafSource, path []interface{}) *hieraapi.Source {
+	for _, l := range leaves {
+		if len(l.Path) < len(path) {
+			continue
+		}
+		match := true
+		for i, p := range path {
+			if fmt.Sprint(l.Path[i]) != fmt.Sprint(p) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return l.Source
+		}
+	}
+	return nil
+}
+
+// puppetText returns the value in Puppet syntax so that strings are quoted
+func puppetText(v px.Value) string {
+	if s, ok := v.(px.StringValue); ok {
+		b := bytes.NewBufferString(``)
+		utils.PuppetQuote(b, s.String())
+		return b.String()
+	}
+	return px.ToString(v)
+}
+
+// RenderDiff renders the given changes on the given io.Writer. The text rendering shows one line per change,
+// prefixed with '+', '-', or '~', followed by the sources of the values. The json and yaml renderings produce an
+// array with one hash per change. Sensitive values are redacted unless showSensitive is true.
+func RenderDiff(c px.Context, renderAs RenderName, changes []*Change, showSensitive bool, out io.Writer) {
+	value := func(v px.Value) px.Value {
+		if s, ok := v.(*types.Sensitive); ok {
+			if showSensitive {
+				return s.Unwrap()
+			}
+			return types.WrapString(internal.RedactedSensitive)
+		}
+		return v
+	}
+
+	text := func(v px.Value) string {
+		return puppetText(value(v))
+	}
+
+	if renderAs == Text {
+		for _, ch := range changes {
+			switch ch.Kind {
+			case Added:
+				utils.Fprintf(out, "+ %s: %s\n", ch.PathString(), text(ch.To))
+			case Removed:
+				utils.Fprintf(out, "- %s: %s\n", ch.PathString(), text(ch.From))
+			default:
+				utils.Fprintf(out, "~ %s: %s => %s\n", ch.PathString(), text(ch.From), text(ch.To))
+			}
+			if ch.FromSource != nil {
+				utils.Fprintf(out, "    from: %s\n", ch.FromSource)
+			}
+			if ch.ToSource != nil {
+				utils.Fprintf(out, "    to:   %s\n", ch.ToSource)
+			}
+		}
+		return
+	}
+
+	es := make([]px.Value, len(changes))
+	for i, ch := range changes {
+		he := []*types.HashEntry{
+			types.WrapHashEntry2(`change`, types.WrapString(string(ch.Kind))),
+			types.WrapHashEntry2(`key`, types.WrapString(ch.PathString()))}
+		if ch.From != nil {
+			he = append(he, types.WrapHashEntry2(`from`, value(ch.From)))
+		}
+		if ch.To != nil {
+			he = append(he, types.WrapHashEntry2(`to`, value(ch.To)))
+		}
+		if ch.FromSource != nil {
+			he = append(he, types.WrapHashEntry2(`from_source`, types.WrapString(ch.FromSource.String())))
+		}
+		if ch.ToSource != nil {
+			he = append(he, types.WrapHashEntry2(`to_source`, types.WrapString(ch.ToSource.String())))
+		}
+		es[i] = types.WrapHash(he)
+	}
+	Render(c, renderAs, types.WrapValues(es), out)
+}
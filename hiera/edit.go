@@ -0,0 +1,492 @@
+package hiera
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/hiera/internal"
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/pcore/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// SetValue sets the given key to the given value in the data file that the hierarchy entry with the given name
+// reads for the scope given by the command options and returns the path of that file. The key may be a dotted key
+// in which case missing hashes are created. The file is edited as a YAML document so that comments, ordering, and
+// anchors are preserved. It is created when it doesn't exist and the path template of the entry allows it.
+func SetValue(c px.Context, opts *CommandOptions, level, key string, value px.Value) string {
+	ic := internal.NewInvocation(c, createScope(c, opts), nil)
+	file, fn := internal.DataFile(ic, level)
+	ed := &editor{key: key, path: file}
+	doc := ed.read(fn)
+	ed.set(doc.Content[0], hieraapi.NewKey(key).Parts(), valueNode(value))
+	ed.write(doc, fn)
+	return file
+}
+
+// UnsetValue removes the given key from the data file that the hierarchy entry with the given name reads for the
+// scope given by the command options and returns the path of that file. An error is raised when the key isn't set
+// in that file.
+func UnsetValue(c px.Context, opts *CommandOptions, level, key string) string {
+	ic := internal.NewInvocation(c, createScope(c, opts), nil)
+	file, fn := internal.DataFile(ic, level)
+	ed := &editor{key: key, path: file}
+	doc := ed.read(fn)
+	ed.unset(doc.Content[0], hieraapi.NewKey(key).Parts())
+	ed.write(doc, fn)
+	return file
+}
+
+// SetAndRender parses the given value, sets the given key to that value using SetValue, and reports the edited
+// file on the given io.Writer. A value that is quoted or that starts with '[' or '{' is parsed in the same way as
+// the value of a variable given on the command line. Any other value is parsed as a YAML scalar so that numbers,
+// booleans, and null get their type.
+func SetAndRender(c px.Context, opts *CommandOptions, level, key, value string, out io.Writer) {
+	file := SetValue(c, opts, level, key, parseEditValue(c, key, value))
+	utils.Fprintf(out, "set '%s' in %s\n", key, file)
+}
+
+// UnsetAndRender removes the given key using UnsetValue and reports the edited file on the given io.Writer
+func UnsetAndRender(c px.Context, opts *CommandOptions, level, key string, out io.Writer) {
+	file := UnsetValue(c, opts, level, key)
+	utils.Fprintf(out, "unset '%s' in %s\n", key, file)
+}
+
+func parseEditValue(c px.Context, key, vs string) px.Value {
+	for _, pfx := range needParsePrefix {
+		if strings.HasPrefix(strings.TrimSpace(vs), pfx) {
+			return parseCommandLineValue(c, key, vs)
+		}
+	}
+	n := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(vs), n); err == nil && len(n.Content) == 1 && n.Content[0].Kind == yaml.ScalarNode {
+		sn := n.Content[0]
+		switch sn.ShortTag() {
+		case `!!int`:
+			if i, err := strconv.ParseInt(sn.Value, 0, 64); err == nil {
+				return types.WrapInteger(i)
+			}
+		case `!!float`:
+			if f, err := strconv.ParseFloat(sn.Value, 64); err == nil {
+				return types.WrapFloat(f)
+			}
+		case `!!bool`:
+			return types.WrapBoolean(sn.Value == `true`)
+		case `!!null`:
+			return px.Undef
+		}
+	}
+	return types.WrapString(strings.TrimSpace(vs))
+}
+
+// editor edits the value of a key in a data file
+type editor struct {
+	key  string
+	path string
+
+	// text is the content of the data file when it was read
+	text []byte
+
+	// keys maps the key nodes of the hash in the document that was read to their position in that hash
+	keys map[*yaml.Node]int
+}
+
+func (ed *editor) fail(format string, args ...interface{}) {
+	panic(px.Error(hieraapi.CannotEditKey, issue.H{`key`: ed.key, `path`: ed.path, `reason`: fmt.Sprintf(format, args...)}))
+}
+
+// read parses the data file into a YAML document with a hash. A document with an empty hash is returned when the
+// file doesn't exist or is empty.
+func (ed *editor) read(fn string) *yaml.Node {
+	doc := &yaml.Node{}
+	bs, err := ioutil.ReadFile(ed.path)
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	if err = yaml.Unmarshal(bs, doc); err != nil {
+		panic(err)
+	}
+	if len(doc.Content) == 0 {
+		doc.Kind = yaml.DocumentNode
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: `!!map`}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		if fn == `json_data` {
+			panic(px.Error(hieraapi.JSONNOtHash, issue.H{`path`: ed.path}))
+		}
+		panic(px.Error(hieraapi.YamlNotHash, issue.H{`path`: ed.path}))
+	}
+	ed.text = bs
+	ed.keys = make(map[*yaml.Node]int, len(root.Content)/2)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		ed.keys[root.Content[i]] = i / 2
+	}
+	return doc
+}
+
+// write writes the document to the data file, as JSON when the file is read by the json_data function
+func (ed *editor) write(doc *yaml.Node, fn string) {
+	writeDataFiles(map[string][]byte{ed.path: ed.encode(doc, fn)})
+}
+
+// encode returns the document in the form that is written to the data file.
+//
+// A YAML file keeps the text of each top-level entry that the edit didn't change, including the comments and blank
+// lines around it. Changed and added entries are encoded using the indentation found in the file. The whole file is
+// encoded when its text can't be reused in this way.
+//
+// A JSON file is always encoded as a whole, using the indentation of its first indented line. Numbers that JSON
+// cannot represent, such as YAML's .inf and .nan, cause an error.
+func (ed *editor) encode(doc *yaml.Node, fn string) []byte {
+	if fn == `json_data` {
+		b := bytes.NewBufferString(``)
+		ed.writeJSONNode(b, doc.Content[0])
+		ib := bytes.NewBufferString(``)
+		if err := json.Indent(ib, b.Bytes(), ``, jsonIndent(ed.text)); err != nil {
+			panic(err)
+		}
+		ib.WriteByte('\n')
+		return ib.Bytes()
+	}
+
+	untagMergeKeys(doc)
+	l := yamlLayout{indent: 2, seqIndent: -1}
+	orig := &yaml.Node{}
+	if yaml.Unmarshal(ed.text, orig) == nil {
+		l = newYAMLLayout(orig)
+	}
+	full := l.encode(doc)
+	if bytes.HasPrefix(bytes.TrimSpace(ed.text), []byte(`---`)) {
+		full = append([]byte("---\n"), full...)
+	}
+	if spliced := spliceYAML(l, ed.text, ed.keys, doc); spliced != nil && sameYAML(spliced, full) {
+		return spliced
+	}
+	return full
+}
+
+// jsonIndent returns the leading white space of the first indented line of the given JSON text or two spaces
+// when no line is indented
+func jsonIndent(text []byte) string {
+	for _, l := range lines(text) {
+		if t := strings.TrimLeft(l, " \t"); strings.TrimSpace(t) != `` && len(t) < len(l) {
+			return l[:len(l)-len(t)]
+		}
+	}
+	return `  `
+}
+
+// writeDataFiles writes the given contents to the files with the given paths. Each content is first written to a
+// temporary file in the directory of its file and all temporary files are then renamed so that an error leaves no
+// file partially written. The mode of an existing file is kept and the directory of a new file is created when
+// needed. A file with nil content is deleted once all other files have been written.
+func writeDataFiles(contents map[string][]byte) {
+	temps := make(map[string]string, len(contents))
+	defer func() {
+		for _, tmp := range temps {
+			_ = os.Remove(tmp)
+		}
+	}()
+	var deletes []string
+	for path, bs := range contents {
+		if bs == nil {
+			deletes = append(deletes, path)
+			continue
+		}
+		temps[path] = writeTempFile(path, bs)
+	}
+	for path, tmp := range temps {
+		if err := os.Rename(tmp, path); err != nil {
+			panic(err)
+		}
+		delete(temps, path)
+	}
+	for _, path := range deletes {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			panic(err)
+		}
+	}
+}
+
+// writeTempFile writes the given bytes to a new temporary file in the directory of the given path and returns the
+// name of the temporary file
+func writeTempFile(path string, bs []byte) string {
+	mode := os.FileMode(0644)
+	dir := filepath.Dir(path)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode()
+	} else if err = os.MkdirAll(dir, 0755); err != nil {
+		panic(err)
+	}
+	f, err := ioutil.TempFile(dir, `.`+filepath.Base(path)+`.*`)
+	if err != nil {
+		panic(err)
+	}
+	tmp := f.Name()
+	if err = f.Chmod(mode); err == nil {
+		if _, err = f.Write(bs); err == nil {
+			err = f.Sync()
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		panic(err)
+	}
+	return tmp
+}
+
+// untagMergeKeys removes the tags of the merge keys of the given node and its descendants. The merge keys would
+// otherwise be written with an explicit !!merge tag.
+func untagMergeKeys(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && n.Tag == `!!merge` {
+		n.Tag = ``
+	}
+	for _, c := range n.Content {
+		untagMergeKeys(c)
+	}
+}
+
+// child returns the node of the given key segment in the given hash or array node and the index of that node
+// in the content of the collection. Nil and -1 are returned when the collection has no such entry.
+func (ed *editor) child(n *yaml.Node, seg interface{}) (*yaml.Node, int) {
+	switch seg := seg.(type) {
+	case string:
+		if n.Kind != yaml.MappingNode {
+			ed.fail(`'%s' is not a key of a hash`, seg)
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == seg {
+				return n.Content[i+1], i + 1
+			}
+		}
+	case int:
+		if n.Kind != yaml.SequenceNode {
+			ed.fail(`%d is not an index of an array`, seg)
+		}
+		if seg < 0 {
+			seg += len(n.Content)
+		}
+		if seg >= 0 && seg < len(n.Content) {
+			return n.Content[seg], seg
+		}
+	default:
+		ed.fail(`the segment '%s' is not a key or an index`, seg)
+	}
+	return nil, -1
+}
+
+// walk returns the node of the given key segment in the given collection. The node must not be an alias since
+// edits through an alias would change every node that uses the same anchor.
+func (ed *editor) walk(n *yaml.Node, seg interface{}) *yaml.Node {
+	c, _ := ed.child(n, seg)
+	if c != nil && c.Kind == yaml.AliasNode {
+		ed.fail(`the value of '%v' is the alias '*%s'`, seg, c.Value)
+	}
+	return c
+}
+
+// set sets the value at the given path within the given collection node, creating missing hashes along the way
+func (ed *editor) set(n *yaml.Node, parts []interface{}, value *yaml.Node) {
+	seg := parts[0]
+	if len(parts) > 1 {
+		c := ed.walk(n, seg)
+		if c == nil {
+			if _, ok := parts[1].(string); !ok {
+				ed.fail(`there is no array to index at '%v'`, seg)
+			}
+			c = &yaml.Node{Kind: yaml.MappingNode, Tag: `!!map`}
+			ed.add(n, seg, c)
+		}
+		ed.set(c, parts[1:], value)
+		return
+	}
+
+	c, _ := ed.child(n, seg)
+	if c == nil {
+		ed.add(n, seg, value)
+		return
+	}
+	// Replace the node in place so that comments are kept and aliases of its anchor see the new value
+	value.Anchor = c.Anchor
+	value.HeadComment, value.LineComment, value.FootComment = c.HeadComment, c.LineComment, c.FootComment
+	*c = *value
+}
+
+// add adds a new entry to the given collection node. Entries can only be added to arrays at their end.
+func (ed *editor) add(n *yaml.Node, seg interface{}, value *yaml.Node) {
+	switch seg := seg.(type) {
+	case string:
+		n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: `!!str`, Value: seg}, value)
+	case int:
+		if seg != len(n.Content) {
+			ed.fail(`index %d is out of bounds`, seg)
+		}
+		n.Content = append(n.Content, value)
+	}
+}
+
+// unset removes the entry at the given path within the given collection node
+func (ed *editor) unset(n *yaml.Node, parts []interface{}) {
+	if c := find(n, parts); c != nil && c.Anchor != `` {
+		ed.fail(`the value is the anchor '&%s'`, c.Anchor)
+	}
+	ed.remove(n, parts)
+}
+
+// remove removes the entry at the given path within the given collection node and returns its key node and its
+// value node. The key node is nil when the entry is an array element.
+func (ed *editor) remove(n *yaml.Node, parts []interface{}) (*yaml.Node, *yaml.Node) {
+	for _, seg := range parts[:len(parts)-1] {
+		if n = ed.walk(n, seg); n == nil {
+			ed.fail(`the key is not set`)
+		}
+	}
+	c, i := ed.child(n, parts[len(parts)-1])
+	if c == nil {
+		ed.fail(`the key is not set`)
+	}
+	if n.Kind == yaml.MappingNode {
+		k := n.Content[i-1]
+		n.Content = append(n.Content[:i-1], n.Content[i+1:]...)
+		return k, c
+	}
+	n.Content = append(n.Content[:i], n.Content[i+1:]...)
+	return nil, c
+}
+
+// find returns the node at the given path within the given node, or nil when there is no such node. Aliases are
+// not followed.
+func find(n *yaml.Node, parts []interface{}) *yaml.Node {
+	for _, seg := range parts {
+		var c *yaml.Node
+		switch seg := seg.(type) {
+		case string:
+			if n.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(n.Content); i += 2 {
+					if n.Content[i].Value == seg {
+						c = n.Content[i+1]
+						break
+					}
+				}
+			}
+		case int:
+			if n.Kind == yaml.SequenceNode {
+				if seg < 0 {
+					seg += len(n.Content)
+				}
+				if seg >= 0 && seg < len(n.Content) {
+					c = n.Content[seg]
+				}
+			}
+		}
+		if c == nil {
+			return nil
+		}
+		n = c
+	}
+	return n
+}
+
+// valueNode returns a YAML node for the given value. Values that have no YAML representation are represented by
+// their string form.
+func valueNode(v px.Value) *yaml.Node {
+	switch v := v.(type) {
+	case *types.Hash:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: `!!map`}
+		v.EachPair(func(k, e px.Value) { n.Content = append(n.Content, valueNode(k), valueNode(e)) })
+		return n
+	case *types.Array:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: `!!seq`}
+		v.Each(func(e px.Value) { n.Content = append(n.Content, valueNode(e)) })
+		return n
+	case px.StringValue:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: `!!str`, Value: v.String()}
+	case px.Integer:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: `!!int`, Value: strconv.FormatInt(v.Int(), 10)}
+	case px.Float:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: `!!float`, Value: strconv.FormatFloat(v.Float(), 'g', -1, 64)}
+	case px.Boolean:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: `!!bool`, Value: strconv.FormatBool(v.Bool())}
+	case *types.UndefValue:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: `!!null`, Value: `null`}
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: `!!str`, Value: v.String()}
+	}
+}
+
+// writeJSONNode writes the given YAML node as compact JSON on the given buffer
+func (ed *editor) writeJSONNode(b *bytes.Buffer, n *yaml.Node) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		ed.writeJSONNode(b, n.Alias)
+	case yaml.MappingNode:
+		b.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeJSONString(b, n.Content[i].Value)
+			b.WriteByte(':')
+			ed.writeJSONNode(b, n.Content[i+1])
+		}
+		b.WriteByte('}')
+	case yaml.SequenceNode:
+		b.WriteByte('[')
+		for i, e := range n.Content {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			ed.writeJSONNode(b, e)
+		}
+		b.WriteByte(']')
+	default:
+		switch n.ShortTag() {
+		case `!!int`, `!!float`:
+			b.WriteString(ed.jsonNumber(n))
+		case `!!bool`:
+			b.WriteString(strings.ToLower(n.Value))
+		case `!!null`:
+			b.WriteString(`null`)
+		default:
+			writeJSONString(b, n.Value)
+		}
+	}
+}
+
+// jsonNumber returns the JSON form of the given YAML number. Integers such as 0x1F and 1_000 are converted to
+// decimal form. Infinity and NaN cannot be represented in JSON.
+func (ed *editor) jsonNumber(n *yaml.Node) string {
+	if json.Valid([]byte(n.Value)) {
+		return n.Value
+	}
+	v := strings.Replace(n.Value, `_`, ``, -1)
+	if n.ShortTag() == `!!int` {
+		if i, err := strconv.ParseInt(v, 0, 64); err == nil {
+			return strconv.FormatInt(i, 10)
+		}
+	} else if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	ed.fail(`the number %s cannot be represented in JSON`, n.Value)
+	return ``
+}
+
+func writeJSONString(b *bytes.Buffer, s string) {
+	bs, _ := json.Marshal(s)
+	b.Write(bs)
+}
@@ -0,0 +1,130 @@
+package hiera
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// editFile writes the given text to a data file, applies the edit to the hash read from it, and returns the text
+// that the editor writes back
+func editFile(t *testing.T, name, fn, text string, edit func(ed *editor, root *yaml.Node)) string {
+	t.Helper()
+	dir, err := ioutil.TempDir(``, `edit`)
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(text), 0644))
+	ed := &editor{key: `x`, path: path}
+	doc := ed.read(fn)
+	edit(ed, doc.Content[0])
+	ed.write(doc, fn)
+	return readText(t, path)
+}
+
+func readText(t *testing.T, path string) string {
+	t.Helper()
+	bs, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(bs)
+}
+
+func scalar(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
+
+func TestEditor_keepsLayout(t *testing.T) {
+	text := `# Settings
+a:
+    x: 1   # aligned
+    list:
+        -   one
+        -   two
+
+# Second
+b:
+    y: 1
+
+
+c: [1, 2]
+# end of file
+`
+	require.Equal(t, `# Settings
+a:
+    x: 1   # aligned
+    list:
+        -   one
+        -   two
+
+# Second
+b:
+    y: 2
+    z:
+        - 3
+
+
+c: [1, 2]
+# end of file
+
+d:
+    e: true
+`, editFile(t, `data.yaml`, `yaml_data`, text, func(ed *editor, root *yaml.Node) {
+		ed.set(root, hieraapi.NewKey(`b.y`).Parts(), scalar(`!!int`, `2`))
+		ed.set(root, hieraapi.NewKey(`b.z`).Parts(), &yaml.Node{Kind: yaml.SequenceNode, Tag: `!!seq`, Content: []*yaml.Node{scalar(`!!int`, `3`)}})
+		ed.set(root, hieraapi.NewKey(`d.e`).Parts(), scalar(`!!bool`, `true`))
+	}))
+}
+
+func TestEditor_removeKeepsLayout(t *testing.T) {
+	text := `a: 1
+
+# about b
+b: 2
+
+c: 3
+`
+	require.Equal(t, "a: 1\n\nc: 3\n", editFile(t, `data.yaml`, `yaml_data`, text, func(ed *editor, root *yaml.Node) {
+		ed.unset(root, hieraapi.NewKey(`b`).Parts())
+	}))
+	require.Equal(t, "a: 1\n\n# about b\nb: 2\n", editFile(t, `data.yaml`, `yaml_data`, text, func(ed *editor, root *yaml.Node) {
+		ed.unset(root, hieraapi.NewKey(`c`).Parts())
+	}))
+}
+
+func TestEditor_jsonNumbers(t *testing.T) {
+	text := "{\n    \"a\": 1\n}\n"
+	require.Equal(t, `{
+    "a": 1,
+    "hex": 31,
+    "big": 1000000,
+    "half": 0.5,
+    "exp": 1e3
+}
+`, editFile(t, `data.json`, `json_data`, text, func(ed *editor, root *yaml.Node) {
+		ed.add(root, `hex`, scalar(`!!int`, `0x1F`))
+		ed.add(root, `big`, scalar(`!!int`, `1_000_000`))
+		ed.add(root, `half`, scalar(`!!float`, `.5`))
+		ed.add(root, `exp`, scalar(`!!float`, `1e3`))
+	}))
+
+	for _, v := range []string{`.inf`, `-.Inf`, `.NaN`} {
+		func() {
+			defer func() {
+				err, ok := recover().(error)
+				require.True(t, ok)
+				require.Regexp(t, `data\.json': the number `+regexp.QuoteMeta(v)+` cannot be represented in JSON`, err.Error())
+			}()
+			editFile(t, `data.json`, `json_data`, text, func(ed *editor, root *yaml.Node) {
+				ed.add(root, `n`, scalar(`!!float`, v))
+			})
+		}()
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"regexp"
 	"strings"
 
@@ -45,6 +46,12 @@ type CommandOptions struct {
 
 	// ExplainOptions should be set to true to explain how lookup options were found for the lookup
 	ExplainOptions bool
+
+	// ShowSources should be set to true to annotate each leaf of the found value with its source
+	ShowSources bool
+
+	// ShowSensitive should be set to true to reveal Sensitive values when all values are looked up
+	ShowSensitive bool
 }
 
 // NewInvocation creates a new lookup invocation using the given scope and explainer.
@@ -65,6 +72,38 @@ func Lookup(ic hieraapi.Invocation, name string, defaultValue px.Value, options
 	return internal.Lookup(ic, name, defaultValue, options)
 }
 
+// LookupWithSources performs a lookup in the same way as Lookup and returns the found value together with
+// the source of each of its leaves. This is useful when the value is the result of a merge. Leaves that
+// don't originate from the data, such as the default value, have no source.
+func LookupWithSources(ic hieraapi.Invocation, name string, defaultValue px.Value, options map[string]px.Value) (px.Value, []hieraapi.LeafSource) {
+	return internal.WithSources(ic, func() px.Value {
+		return internal.Lookup(ic, name, defaultValue, options)
+	})
+}
+
+// Keys returns the keys that start with the given prefix and that are defined by the hierarchy of the
+// configuration used by the given invocation, sorted by name and each with the sources that define it in
+// hierarchy order. Only hierarchy entries that use a data provider that can list its keys contribute, i.e.
+// entries using a data_hash function or a lookup_key plugin function that advertises that it can list its keys.
+func Keys(ic hieraapi.Invocation, prefix string) []hieraapi.KeySources {
+	return internal.Keys(ic, prefix)
+}
+
+// LookupAll looks up all keys that start with the given prefix and that are listed by Keys, and returns a hash
+// with the keys and their values. The lookup_options of each key are applied just as in any other lookup.
+// Sensitive values are replaced by the string "Sensitive [value redacted]" unless showSensitive is true.
+func LookupAll(ic hieraapi.Invocation, prefix string, showSensitive bool) px.OrderedMap {
+	return internal.LookupAll(ic, keyNames(Keys(ic, prefix)), showSensitive)
+}
+
+func keyNames(kss []hieraapi.KeySources) []string {
+	names := make([]string, len(kss))
+	for i, ks := range kss {
+		names[i] = ks.Key
+	}
+	return names
+}
+
 // Lookup2 performs a lookup using the given parameters.
 //
 // ic - The lookup invocation
@@ -143,10 +182,22 @@ func LookupAndRender(c px.Context, opts *CommandOptions, args []string, out io.W
 
 	var explainer explain.Explainer
 	if opts.ExplainData || opts.ExplainOptions {
+		if opts.ShowSources {
+			panic(fmt.Errorf(`sources cannot be shown together with an explanation`))
+		}
 		explainer = explain.NewExplainer(opts.ExplainOptions, opts.ExplainOptions && !opts.ExplainData)
 	}
 
-	found := Lookup2(internal.NewInvocation(c, createScope(c, opts), explainer), args, tp, dv, nil, nil, options, nil)
+	ic := internal.NewInvocation(c, createScope(c, opts), explainer)
+	var found px.Value
+	var sources []hieraapi.LeafSource
+	if opts.ShowSources {
+		found, sources = internal.WithSources(ic, func() px.Value {
+			return Lookup2(ic, args, tp, dv, nil, nil, options, nil)
+		})
+	} else {
+		found = Lookup2(ic, args, tp, dv, nil, nil, options, nil)
+	}
 	if explainer != nil {
 		renderAs := Text
 		if opts.RenderAs != `` {
@@ -164,10 +215,69 @@ func LookupAndRender(c px.Context, opts *CommandOptions, args []string, out io.W
 	if opts.RenderAs != `` {
 		renderAs = RenderName(opts.RenderAs)
 	}
+	if opts.ShowSources {
+		if renderAs != YAML {
+			panic(fmt.Errorf(`sources can only be shown when rendering as yaml`))
+		}
+		RenderWithSources(c, found, sources, out)
+		return true
+	}
 	Render(c, renderAs, found, out)
 	return true
 }
 
+// ListAndRender lists the keys that match the given pattern using the scope given by the command options and
+// renders them together with their sources on the given io.Writer in accordance with the `RenderAs` option. The
+// pattern is a prefix unless it contains any of the characters '*', '?', or '[', in which case it is a glob
+// pattern as understood by path.Match. An empty pattern lists all keys.
+func ListAndRender(c px.Context, opts *CommandOptions, pattern string, out io.Writer) {
+	ic := internal.NewInvocation(c, createScope(c, opts), nil)
+	entries := make([]*types.HashEntry, 0)
+	for _, ks := range keysMatching(ic, pattern) {
+		sources := make([]px.Value, len(ks.Sources))
+		for i, src := range ks.Sources {
+			sources[i] = types.WrapString(src.String())
+		}
+		entries = append(entries, types.WrapHashEntry2(ks.Key, types.WrapValues(sources)))
+	}
+	Render(c, renderName(opts), types.WrapHash(entries), out)
+}
+
+// LookupAllAndRender looks up all keys that match the given pattern using the scope given by the command options
+// and renders one hash with all keys and values on the given io.Writer in accordance with the `RenderAs` option.
+// The pattern is interpreted in the same way as by ListAndRender.
+func LookupAllAndRender(c px.Context, opts *CommandOptions, pattern string, out io.Writer) {
+	ic := internal.NewInvocation(c, createScope(c, opts), nil)
+	Render(c, renderName(opts), internal.LookupAll(ic, keyNames(keysMatching(ic, pattern)), opts.ShowSensitive), out)
+}
+
+func renderName(opts *CommandOptions) RenderName {
+	if opts.RenderAs != `` {
+		return RenderName(opts.RenderAs)
+	}
+	return YAML
+}
+
+// keysMatching returns the keys that match the given prefix or glob pattern
+func keysMatching(ic hieraapi.Invocation, pattern string) []hieraapi.KeySources {
+	ix := strings.IndexAny(pattern, `*?[`)
+	if ix < 0 {
+		return Keys(ic, pattern)
+	}
+	kss := Keys(ic, pattern[:ix])
+	matching := kss[:0]
+	for _, ks := range kss {
+		ok, err := path.Match(pattern, ks.Key)
+		if err != nil {
+			panic(err)
+		}
+		if ok {
+			matching = append(matching, ks)
+		}
+	}
+	return matching
+}
+
 func parseCommandLineValue(c px.Context, key, vs string) px.Value {
 	vs = strings.TrimSpace(vs)
 	for _, pfx := range needParsePrefix {
@@ -3,6 +3,8 @@ package hiera_test
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -24,6 +26,15 @@ func init() {
 	options = map[string]px.Value{`path`: types.WrapString(`./testdata/sample_data.yaml`)}
 }
 
+// withOptions returns a copy of the common options with one added option
+func withOptions(key string, value px.Value) map[string]px.Value {
+	opts := map[string]px.Value{key: value}
+	for k, v := range options {
+		opts[k] = v
+	}
+	return opts
+}
+
 func ExampleLookup_first() {
 	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) {
 		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `first`, nil, nil))
@@ -124,6 +135,149 @@ func ExampleLookup_interpolateBadFunction() {
 	// Output: Unknown interpolation method 'bad'
 }
 
+func ExampleLookup_interpolateEnv() {
+	_ = os.Setenv(`HIERA_TEST_ENV`, `from env`)
+	defer func() {
+		_ = os.Unsetenv(`HIERA_TEST_ENV`)
+	}()
+	opts := withOptions(hieraapi.HieraEnvInterpolation, types.BooleanTrue)
+	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, opts, func(c px.Context) {
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipEnv`, nil, opts))
+	})
+	// Output: from env and default
+}
+
+func ExampleLookup_interpolateEnvUnset() {
+	opts := withOptions(hieraapi.HieraEnvInterpolation, types.BooleanTrue)
+	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, opts, func(c px.Context) {
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipEnvUnset`, nil, opts))
+	})
+	// Output: []
+}
+
+func ExampleLookup_interpolateEnvDisabled() {
+	printErr(hiera.TryWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) error {
+		hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipEnv`, nil, options)
+		return nil
+	}))
+	// Output: Interpolation method 'env' must be enabled using the option Hiera::EnvInterpolation
+}
+
+func ExampleLookup_interpolateJoin() {
+	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) {
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipJoin`, nil, options))
+	})
+	// Output: one, two, three
+}
+
+func ExampleLookup_interpolateBase64() {
+	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) {
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipBase64`, nil, options))
+	})
+	// Output: dmFsdWUgb2YgZmlyc3Q= is value of first
+}
+
+func ExampleLookup_interpolateFile() {
+	opts := withOptions(hieraapi.HieraFileInterpolationDirs, types.WrapString(`testdata`))
+	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, opts, func(c px.Context) {
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipFile`, nil, opts))
+	})
+	// Output: hello from file
+}
+
+func TestLookup_interpolateFileAbsoluteDir(t *testing.T) {
+	dir, err := filepath.Abs(`testdata`)
+	require.Nil(t, err)
+	opts := withOptions(hieraapi.HieraFileInterpolationDirs, types.WrapString(dir))
+	// The configuration is relative to the current directory while the allowed directory is absolute
+	opts[hieraapi.HieraConfig] = types.WrapString(`hiera.yaml`)
+	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, opts, func(c px.Context) {
+		require.Equal(t, `hello from file`, hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipFile`, nil, opts).String())
+	})
+}
+
+func ExampleLookup_interpolateFileDisabled() {
+	printErr(hiera.TryWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) error {
+		hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipFile`, nil, options)
+		return nil
+	}))
+	// Output: Interpolation method 'file' must be enabled using the option Hiera::FileInterpolationDirs
+}
+
+func ExampleLookup_interpolateFileNotAllowed() {
+	opts := withOptions(hieraapi.HieraFileInterpolationDirs, types.WrapValues([]px.Value{types.WrapString(`testdata/deprecated`)}))
+	printErr(hiera.TryWithParent(context.Background(), provider.YamlLookupKey, opts, func(c px.Context) error {
+		hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipFileOutside`, nil, opts)
+		return nil
+	}))
+	// Output: Interpolation method 'file' is not allowed to read 'testdata/deprecated/../greeting.txt'
+}
+
+func ExampleLookup_interpolateBareName() {
+	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) {
+		scope := types.WrapStringToInterfaceMap(c, map[string]interface{}{`foo(`: `bare`})
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, scope, nil), `ipBareName`, nil, options))
+	})
+	// Output: bare name
+}
+
+func ExampleRegisterInterpolationFunction() {
+	hieraapi.RegisterInterpolationFunction(`upcase`, hieraapi.InterpolationInData, func(_ hieraapi.Invocation, args []px.Value) px.Value {
+		return types.WrapString(strings.ToUpper(args[0].String()))
+	})
+	defer hieraapi.UnregisterInterpolationFunction(`upcase`)
+	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) {
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipRegistered`, nil, options))
+	})
+	// Output: ONE TWO THREE
+}
+
+func ExampleLookup_interpolateSyntaxError() {
+	printErr(hiera.TryWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) error {
+		hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipSyntax`, nil, options)
+		return nil
+	}))
+	// Output: Syntax error in interpolation expression 'join(lookup('array')': missing ')'
+}
+
+func ExampleLookup_interpolateWrongArgCount() {
+	printErr(hiera.TryWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) error {
+		hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipArgs`, nil, options)
+		return nil
+	}))
+	// Output: Interpolation method 'base64_encode' expects 1 argument, got 2
+}
+
+func ExampleLookup_interpolateDefault() {
+	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) {
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipDefault`, nil, options))
+	})
+	// Output: fallback and other
+}
+
+func ExampleLookup_interpolateDefaultCall() {
+	hieraapi.RegisterInterpolationFunction(`nothing`, hieraapi.InterpolationInData, func(_ hieraapi.Invocation, _ []px.Value) px.Value {
+		return px.Undef
+	})
+	defer hieraapi.UnregisterInterpolationFunction(`nothing`)
+	opts := withOptions(hieraapi.HieraEnvInterpolation, types.BooleanTrue)
+	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, opts, func(c px.Context) {
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipDefaultCall`, nil, opts))
+	})
+	// Output: fallback and value of first
+}
+
+func ExampleLookup_interpolateStrict() {
+	strictOptions := map[string]px.Value{
+		`path`:                            options[`path`],
+		hieraapi.HieraStrictInterpolation: types.BooleanTrue}
+	printErr(hiera.TryWithParent(context.Background(), provider.YamlLookupKey, strictOptions, func(c px.Context) error {
+		hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipStrict`, nil, options)
+		return nil
+	}))
+	// Output: Unable to resolve interpolation expression 'worl' in key 'ipStrict'
+}
+
 func ExampleLookup_notFoundWithoutDefault() {
 	printErr(hiera.TryWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) error {
 		hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `nonexistent`, nil, options)
@@ -227,3 +381,39 @@ func ExampleLookup_mapProvider() {
 	// value of a
 	// value of b
 }
+
+func ExampleLookup_deprecationHandler() {
+	configOptions := map[string]px.Value{
+		hieraapi.HieraConfig: types.WrapString(`./testdata/deprecated/hiera.yaml`),
+		hieraapi.HieraDeprecationHandler: types.WrapRuntime(hieraapi.DeprecationHandler(func(key, message string) {
+			fmt.Printf("%s: %s\n", key, message)
+		}))}
+
+	hiera.DoWithParent(context.Background(), provider.ConfigLookupKey, configOptions, func(c px.Context) {
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `old_port`, nil, nil))
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `port`, nil, nil))
+	})
+
+	// Output:
+	// old_port: use port instead
+	// 8080
+	// 8080
+}
+
+func ExampleLookup_interpolateUnresolvedArgument() {
+	hiera.DoWithParent(context.Background(), provider.YamlLookupKey, options, func(c px.Context) {
+		fmt.Println(hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipUnresolvedArg`, nil, options))
+	})
+	// Output: [] []
+}
+
+func ExampleLookup_interpolateStrictArgument() {
+	strictOptions := map[string]px.Value{
+		`path`:                            options[`path`],
+		hieraapi.HieraStrictInterpolation: types.BooleanTrue}
+	printErr(hiera.TryWithParent(context.Background(), provider.YamlLookupKey, strictOptions, func(c px.Context) error {
+		hiera.Lookup(internal.NewInvocation(c, px.EmptyMap, nil), `ipStrictArg`, nil, options)
+		return nil
+	}))
+	// Output: Unable to resolve interpolation expression 'lookup('missing')' in key 'ipStrictArg'
+}
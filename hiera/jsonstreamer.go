@@ -0,0 +1,112 @@
+package hiera
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/serialization"
+	"github.com/lyraproj/pcore/types"
+)
+
+// jsonLevel is an array or a hash that is being streamed together with the number of elements streamed so far.
+// The elements of a hash are its keys and values.
+type jsonLevel struct {
+	hash  bool
+	count int
+}
+
+// jsonStreamer is a px.ValueConsumer that produces JSON. It replaces the streamer of the serialization package,
+// which keeps a single state that isn't restored after the second element of an array. When that element is a
+// hash, the state of the hash leaks out and the fourth element of the array is preceded by ':' instead of ','.
+// This streamer keeps track of each nested array and hash instead.
+type jsonStreamer struct {
+	out    io.Writer
+	levels []*jsonLevel
+}
+
+func newJSONStreamer(out io.Writer) px.ValueConsumer {
+	return &jsonStreamer{out: out}
+}
+
+func (j *jsonStreamer) AddArray(_ int, doer px.Doer) {
+	j.nested(false, '[', ']', doer)
+}
+
+func (j *jsonStreamer) AddHash(_ int, doer px.Doer) {
+	j.nested(true, '{', '}', doer)
+}
+
+func (j *jsonStreamer) Add(element px.Value) {
+	j.delimit()
+	var v []byte
+	var err error
+	switch e := element.(type) {
+	case px.StringValue:
+		v, err = json.Marshal(e.String())
+	case px.Float:
+		v, err = json.Marshal(e.Float())
+	case px.Integer:
+		v, err = json.Marshal(e.Int())
+	case px.Boolean:
+		v, err = json.Marshal(e.Bool())
+	case *types.UndefValue:
+		v = []byte(`null`)
+	default:
+		// The serializer converts all other values before they reach the streamer, so anything else is a bug that
+		// must not be hidden by a null
+		err = fmt.Errorf(`unable to stream a value of type %s as JSON`, element.PType())
+	}
+	if err != nil {
+		panic(err)
+	}
+	j.write(v)
+}
+
+func (j *jsonStreamer) AddRef(ref int) {
+	j.delimit()
+	j.write([]byte(fmt.Sprintf(`{"%s":%d}`, serialization.PcoreRefKey, ref)))
+}
+
+func (j *jsonStreamer) CanDoBinary() bool {
+	return false
+}
+
+func (j *jsonStreamer) CanDoComplexKeys() bool {
+	return false
+}
+
+func (j *jsonStreamer) StringDedupThreshold() int {
+	return 20
+}
+
+func (j *jsonStreamer) nested(hash bool, start, end byte, doer px.Doer) {
+	j.delimit()
+	j.write([]byte{start})
+	j.levels = append(j.levels, &jsonLevel{hash: hash})
+	doer()
+	j.levels = j.levels[:len(j.levels)-1]
+	j.write([]byte{end})
+}
+
+// delimit writes the delimiter that precedes the next element of the current array or hash
+func (j *jsonStreamer) delimit() {
+	if len(j.levels) == 0 {
+		return
+	}
+	l := j.levels[len(j.levels)-1]
+	switch {
+	case l.hash && l.count%2 == 1:
+		j.write([]byte{':'})
+	case l.count > 0:
+		j.write([]byte{','})
+	}
+	l.count++
+}
+
+func (j *jsonStreamer) write(bs []byte) {
+	if _, err := j.out.Write(bs); err != nil {
+		panic(err)
+	}
+}
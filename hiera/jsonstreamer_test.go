@@ -0,0 +1,87 @@
+package hiera
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/lyraproj/pcore/pcore"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/serialization"
+	"github.com/lyraproj/pcore/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONStreamer_scalars(t *testing.T) {
+	for _, tc := range []struct {
+		value    px.Value
+		expected string
+	}{
+		{types.WrapString("a \"quoted\" string\n"), `"a \"quoted\" string\n"`},
+		{types.WrapInteger(-42), `-42`},
+		{types.WrapFloat(1.5), `1.5`},
+		{types.BooleanTrue, `true`},
+		{px.Undef, `null`},
+	} {
+		b := new(bytes.Buffer)
+		newJSONStreamer(b).Add(tc.value)
+		require.Equal(t, tc.expected, b.String())
+	}
+}
+
+func TestJSONStreamer_nested(t *testing.T) {
+	b := new(bytes.Buffer)
+	js := newJSONStreamer(b)
+	js.AddHash(3, func() {
+		js.Add(types.WrapString(`a`))
+		js.AddArray(2, func() {
+			js.AddHash(1, func() {
+				js.Add(types.WrapString(`x`))
+				js.Add(types.WrapInteger(1))
+			})
+			js.AddArray(0, func() {})
+		})
+		js.Add(types.WrapString(`b`))
+		js.AddHash(0, func() {})
+		js.Add(types.WrapString(`c`))
+		js.Add(px.Undef)
+	})
+	require.Equal(t, `{"a":[{"x":1},[]],"b":{},"c":null}`, b.String())
+}
+
+func TestJSONStreamer_ref(t *testing.T) {
+	b := new(bytes.Buffer)
+	js := newJSONStreamer(b)
+	js.AddArray(2, func() {
+		js.Add(types.WrapString(`a`))
+		js.AddRef(0)
+	})
+	require.Equal(t, fmt.Sprintf(`["a",{"%s":0}]`, serialization.PcoreRefKey), b.String())
+}
+
+func TestJSONStreamer_unsupported(t *testing.T) {
+	require.Panics(t, func() {
+		newJSONStreamer(new(bytes.Buffer)).Add(types.WrapBinary([]byte{1, 2}))
+	})
+	require.Panics(t, func() {
+		newJSONStreamer(new(bytes.Buffer)).Add(types.WrapFloat(math.Inf(1)))
+	})
+}
+
+// The streamer of the serialization package doesn't restore its state after the second element of an array when
+// that element is a hash, so the fourth element of an array of hashes is preceded by ':' instead of ','
+func TestJSONStreamer_serialized(t *testing.T) {
+	pcore.Do(func(c px.Context) {
+		v := types.WrapStringToInterfaceMap(c, map[string]interface{}{
+			`list`: []interface{}{
+				map[string]interface{}{`name`: `one`},
+				map[string]interface{}{`name`: `two`},
+				map[string]interface{}{`name`: `three`},
+				map[string]interface{}{`name`: `four`}},
+		})
+		b := new(bytes.Buffer)
+		Render(c, JSON, v, b)
+		require.Equal(t, "{\"list\":[{\"name\":\"one\"},{\"name\":\"two\"},{\"name\":\"three\"},{\"name\":\"four\"}]}\n", b.String())
+	})
+}
@@ -0,0 +1,57 @@
+package hiera
+
+import (
+	"io"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/hiera/internal"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/pcore/utils"
+)
+
+// Lint finds data smells in the data files of the hiera configuration. The scope set consists of one scope for
+// each variables file in the given directory or, when no directory is given, of the scope given by the command
+// options. References to scope variables in interpolations are only checked when a scope set is given using
+// variables or a directory.
+func Lint(c px.Context, opts *CommandOptions, varsDir string) []*hieraapi.LintFinding {
+	var ics []hieraapi.Invocation
+	if varsDir != `` {
+		for _, ns := range scopesInDir(c, opts, varsDir) {
+			ics = append(ics, internal.NewInvocation(c, ns.Scope, nil))
+		}
+	}
+	if len(ics) == 0 {
+		ics = []hieraapi.Invocation{internal.NewInvocation(c, createScope(c, opts), nil)}
+	}
+	return internal.Lint(ics, varsDir != `` || len(opts.VarPaths) > 0 || len(opts.Variables) > 0)
+}
+
+// LintAndRender finds data smells in the data files of the hiera configuration and renders them on the given
+// io.Writer in accordance with the `RenderAs` option. The text rendering shows one finding per line. The json and
+// yaml renderings produce an array with one hash per finding. The number of findings is returned.
+func LintAndRender(c px.Context, opts *CommandOptions, varsDir string, out io.Writer) int {
+	findings := Lint(c, opts, varsDir)
+	renderAs := Text
+	if opts.RenderAs != `` {
+		renderAs = RenderName(opts.RenderAs)
+	}
+	if renderAs == Text {
+		for _, f := range findings {
+			utils.Fprintln(out, f)
+		}
+		return len(findings)
+	}
+
+	fs := make([]px.Value, len(findings))
+	for i, f := range findings {
+		fs[i] = types.WrapHash([]*types.HashEntry{
+			types.WrapHashEntry2(`kind`, types.WrapString(string(f.Kind))),
+			types.WrapHashEntry2(`file`, types.WrapString(f.Location.File())),
+			types.WrapHashEntry2(`line`, types.WrapInteger(int64(f.Location.Line()))),
+			types.WrapHashEntry2(`column`, types.WrapInteger(int64(f.Location.Pos()))),
+			types.WrapHashEntry2(`message`, types.WrapString(f.Message))})
+	}
+	Render(c, renderAs, types.WrapValues(fs), out)
+	return len(findings)
+}
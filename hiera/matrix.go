@@ -0,0 +1,150 @@
+package hiera
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lyraproj/hiera/internal"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/pcore/utils"
+)
+
+// NamedScope is a scope that is identified by a name, typically the name of a node
+type NamedScope struct {
+	Name  string
+	Scope px.Keyed
+}
+
+// MatrixGroup is a value together with the names of the scopes that produced it
+type MatrixGroup struct {
+	// Value is the value that was found or nil when no value was found
+	Value px.Value
+
+	// Scopes are the names of the scopes that produced the value
+	Scopes []string
+}
+
+// Matrix looks up the given key once for each of the given scopes and groups the scopes by the value that was
+// found. The groups are ordered by the first scope that produced their value. All lookups use the given context so
+// that the resolved configuration is shared between them.
+func Matrix(c px.Context, key string, scopes []NamedScope, options map[string]px.Value) []*MatrixGroup {
+	groups := make([]*MatrixGroup, 0)
+	for _, ns := range scopes {
+		v := matrixLookup(c, key, ns, options)
+		var g *MatrixGroup
+		for _, eg := range groups {
+			if eg.Value == nil && v == nil || eg.Value != nil && v != nil && eg.Value.Equals(v, nil) {
+				g = eg
+				break
+			}
+		}
+		if g == nil {
+			g = &MatrixGroup{Value: v}
+			groups = append(groups, g)
+		}
+		g.Scopes = append(g.Scopes, ns.Name)
+	}
+	return groups
+}
+
+// matrixLookup looks up the given key using the given scope. Errors are prefixed with the name of the scope.
+func matrixLookup(c px.Context, key string, ns NamedScope, options map[string]px.Value) px.Value {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(error); ok {
+				panic(fmt.Errorf(`%s: %s`, ns.Name, err.Error()))
+			}
+			panic(r)
+		}
+	}()
+	return internal.TryLookup(internal.NewInvocation(c, ns.Scope, nil), key, options)
+}
+
+// matrixExtensions are the extensions of the files that are considered by MatrixAndRender
+var matrixExtensions = []string{`.yaml`, `.yml`, `.json`}
+
+// scopesInDir returns one scope for each variables file in the given directory, named after the file. The
+// variables given by the command options are common to all scopes and are overridden by the variables of each file.
+func scopesInDir(c px.Context, opts *CommandOptions, varsDir string) []NamedScope {
+	fis, err := ioutil.ReadDir(varsDir)
+	if err != nil {
+		panic(err)
+	}
+	files := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		if !fi.IsDir() && utils.ContainsString(matrixExtensions, filepath.Ext(fi.Name())) {
+			files = append(files, fi.Name())
+		}
+	}
+	sort.Strings(files)
+
+	scopes := make([]NamedScope, len(files))
+	for i, f := range files {
+		nodeOpts := *opts
+		nodeOpts.VarPaths = append(append([]string{}, opts.VarPaths...), filepath.Join(varsDir, f))
+		scopes[i] = NamedScope{Name: strings.TrimSuffix(f, filepath.Ext(f)), Scope: createScope(c, &nodeOpts)}
+	}
+	return scopes
+}
+
+// MatrixAndRender looks up the given key once for each variables file found in the given directory and renders
+// the values on the given io.Writer. The name of each node is the name of its file without the extension. The
+// variables given by the command options are common to all nodes and are overridden by the variables of each file.
+//
+// The text rendering is a table with one row for each unique value and the nodes that produced it. The json and
+// yaml renderings produce a hash with the value of each node and the groups of nodes that share a value.
+func MatrixAndRender(c px.Context, opts *CommandOptions, key, varsDir string, out io.Writer) {
+	scopes := scopesInDir(c, opts, varsDir)
+	options := make(map[string]px.Value)
+	if !(opts.Merge == `` || opts.Merge == `first`) {
+		options[`merge`] = types.WrapString(opts.Merge)
+	}
+	groups := Matrix(c, key, scopes, options)
+
+	renderAs := Text
+	if opts.RenderAs != `` {
+		renderAs = RenderName(opts.RenderAs)
+	}
+	if renderAs == Text {
+		tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		utils.Fprintln(tw, "VALUE\tCOUNT\tNODES")
+		for _, g := range groups {
+			value := `(not found)`
+			if g.Value != nil {
+				value = puppetText(g.Value)
+			}
+			utils.Fprintf(tw, "%s\t%d\t%s\n", value, len(g.Scopes), strings.Join(g.Scopes, `, `))
+		}
+		if err := tw.Flush(); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	nodes := make([]*types.HashEntry, 0, len(scopes))
+	ges := make([]px.Value, len(groups))
+	for i, g := range groups {
+		value := g.Value
+		if value == nil {
+			value = px.Undef
+		}
+		names := make([]px.Value, len(g.Scopes))
+		for j, n := range g.Scopes {
+			names[j] = types.WrapString(n)
+			nodes = append(nodes, types.WrapHashEntry2(n, value))
+		}
+		ges[i] = types.WrapHash([]*types.HashEntry{
+			types.WrapHashEntry2(`value`, value),
+			types.WrapHashEntry2(`nodes`, types.WrapValues(names))})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Key().String() < nodes[j].Key().String() })
+	Render(c, renderAs, types.WrapHash([]*types.HashEntry{
+		types.WrapHashEntry2(`nodes`, types.WrapHash(nodes)),
+		types.WrapHashEntry2(`groups`, types.WrapValues(ges))}), out)
+}
@@ -0,0 +1,410 @@
+package hiera
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/hiera/internal"
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/utils"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v3"
+)
+
+// A FileChange is the change of the content of one data file made by RenameKey or MoveKey
+type FileChange struct {
+	// Path is the path of the data file
+	Path string
+
+	// Before is the content of the file before the change or nil when the file is created
+	Before []byte
+
+	// After is the content of the file after the change or nil when the file is deleted
+	After []byte
+}
+
+// RenameKey renames the given key in all data files that match the location templates of the hierarchy entries
+// that use the yaml_data or json_data function. The key may be dotted and so may the new key, so that a key can be
+// moved into or out of a hash. Interpolation expressions that use the lookup, hiera, or alias function to look up
+// the key or one of its dotted sub keys are updated, including calls that are nested or that provide a default,
+// and so are alias_of declarations. The lookup_options of the key are renamed when both keys are root keys. The
+// changes are returned and written unless dryRun is true.
+func RenameKey(c px.Context, oldKey, newKey string, dryRun bool) []*FileChange {
+	op := hieraapi.NewKey(oldKey).Parts()
+	np := hieraapi.NewKey(newKey).Parts()
+	switch {
+	case oldKey == newKey:
+		panic(px.Error(hieraapi.CannotRefactorKey, issue.H{`key`: oldKey, `reason`: `the new key is the same as the old key`}))
+	case op[0] == `lookup_options` || np[0] == `lookup_options`:
+		panic(px.Error(hieraapi.CannotRefactorKey, issue.H{`key`: oldKey, `reason`: `lookup_options is not a data key`}))
+	}
+
+	r := newRefactoring(internal.NewInvocation(c, px.EmptyMap, nil))
+	for _, tf := range internal.TemplateFiles(r.ic, ``) {
+		if _, seen := r.files[tf.Path]; seen {
+			continue
+		}
+		f := r.open(tf.Path, tf.Function)
+		f.renameValue(oldKey, newKey, op, np)
+		f.renameReferences(r.ic, oldKey, newKey, len(op) == 1 && len(np) == 1)
+	}
+	return r.finish(dryRun)
+}
+
+// MoveKey moves the given key from the data files of the hierarchy entry named from to the data files of the
+// hierarchy entry named to. The values of the interpolation expressions that select a source file, such as the
+// node name in 'nodes/%{trusted.certname}.yaml', also select its destination file which is created when needed.
+// A source file that has no data left is deleted. An error is raised when a destination file already has a different value for the key or when several source
+// files that share a destination have different values. The changes are returned and written unless dryRun is
+// true.
+func MoveKey(c px.Context, key, from, to string, dryRun bool) []*FileChange {
+	if from == to {
+		panic(px.Error(hieraapi.CannotRefactorKey, issue.H{`key`: key, `reason`: `the source and destination hierarchy entries are the same`}))
+	}
+	parts := hieraapi.NewKey(key).Parts()
+	r := newRefactoring(internal.NewInvocation(c, px.EmptyMap, nil))
+
+	// moved tracks the value moved to each destination file and the source file that it came from
+	type move struct {
+		value  *yaml.Node
+		source string
+	}
+	moved := make(map[string]*move)
+	for _, tf := range internal.TemplateFiles(r.ic, from) {
+		src := r.open(tf.Path, tf.Function)
+		src.ed.key = key
+		v := find(src.root(), parts)
+		if v == nil {
+			continue
+		}
+		file, fn := internal.TemplateFileFor(r.ic, to, tf.Vars)
+		if file == tf.Path {
+			continue
+		}
+		if hasAnchor(v) || v.Kind == yaml.AliasNode {
+			src.ed.fail(`the value is, or contains, an anchor or an alias that can't be moved to another file`)
+		}
+		dst := r.open(file, fn)
+		dst.ed.key = key
+		if m, ok := moved[file]; ok {
+			if !nodeEqual(m.value, v) {
+				dst.ed.fail(`the value in '%s' differs from the value in '%s'`, tf.Path, m.source)
+			}
+		} else {
+			if dv := find(dst.root(), parts); dv != nil {
+				if !nodeEqual(dv, v) {
+					dst.ed.fail(`it is already set to a different value`)
+				}
+			} else {
+				dst.ed.set(dst.root(), parts, copyNode(v))
+				dst.changed = true
+			}
+			moved[file] = &move{value: v, source: tf.Path}
+		}
+		src.ed.remove(src.root(), parts)
+		src.prune(parts)
+		src.changed = true
+		src.deleteWhenEmpty = true
+	}
+	return r.finish(dryRun)
+}
+
+// RenameAndRender renames the given key using RenameKey and reports the changes on the given io.Writer
+func RenameAndRender(c px.Context, oldKey, newKey string, dryRun bool, out io.Writer) {
+	RenderFileChanges(RenameKey(c, oldKey, newKey, dryRun), dryRun, out)
+}
+
+// MoveAndRender moves the given key using MoveKey and reports the changes on the given io.Writer
+func MoveAndRender(c px.Context, key, from, to string, dryRun bool, out io.Writer) {
+	RenderFileChanges(MoveKey(c, key, from, to, dryRun), dryRun, out)
+}
+
+// RenderFileChanges writes the given changes to the given io.Writer. A unified diff of each file is written when
+// dryRun is true. Otherwise, the path of each file is written together with a note on whether it was created or
+// updated.
+func RenderFileChanges(changes []*FileChange, dryRun bool, out io.Writer) {
+	for _, fc := range changes {
+		if !dryRun {
+			if fc.Before == nil {
+				utils.Fprintf(out, "created %s\n", fc.Path)
+			} else if fc.After == nil {
+				utils.Fprintf(out, "deleted %s\n", fc.Path)
+			} else {
+				utils.Fprintf(out, "updated %s\n", fc.Path)
+			}
+			continue
+		}
+		from := fc.Path
+		if fc.Before == nil {
+			from = os.DevNull
+		}
+		to := fc.Path
+		if fc.After == nil {
+			to = os.DevNull
+		}
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        lines(fc.Before),
+			B:        lines(fc.After),
+			FromFile: from,
+			ToFile:   to,
+			Context:  3})
+		if err != nil {
+			panic(err)
+		}
+		utils.Fprintf(out, `%s`, diff)
+	}
+}
+
+// lines splits the given content into lines that retain their line endings
+func lines(content []byte) []string {
+	ls := strings.SplitAfter(string(content), "\n")
+	if ls[len(ls)-1] == `` {
+		ls = ls[:len(ls)-1]
+	}
+	return ls
+}
+
+// refactoring keeps track of the data files that are edited by a refactoring
+type refactoring struct {
+	ic    hieraapi.Invocation
+	files map[string]*refactorFile
+	order []*refactorFile
+}
+
+// refactorFile is a data file that is edited by a refactoring
+type refactorFile struct {
+	ed      *editor
+	fn      string
+	doc     *yaml.Node
+	before  []byte
+	changed bool
+
+	// deleteWhenEmpty is true when the file is deleted rather than written when the edit leaves it without data
+	deleteWhenEmpty bool
+}
+
+func newRefactoring(ic hieraapi.Invocation) *refactoring {
+	return &refactoring{ic: ic, files: make(map[string]*refactorFile)}
+}
+
+// open returns the data file with the given path, reading it unless it has been read already
+func (r *refactoring) open(path, fn string) *refactorFile {
+	if f, ok := r.files[path]; ok {
+		return f
+	}
+	before, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	f := &refactorFile{ed: &editor{path: path}, fn: fn, before: before}
+	f.doc = f.ed.read(fn)
+	r.files[path] = f
+	r.order = append(r.order, f)
+	return f
+}
+
+// finish returns the changes of all changed files and writes them unless dryRun is true. Nothing is written
+// until all files have been edited, and the files are replaced only after all of them have been written to
+// temporary files, so that an error leaves all files untouched.
+func (r *refactoring) finish(dryRun bool) []*FileChange {
+	changes := make([]*FileChange, 0)
+	for _, f := range r.order {
+		if !f.changed {
+			continue
+		}
+		if f.deleteWhenEmpty && len(f.root().Content) == 0 {
+			if f.before != nil {
+				changes = append(changes, &FileChange{Path: f.ed.path, Before: f.before})
+			}
+			continue
+		}
+		after := f.ed.encode(f.doc, f.fn)
+		if f.before != nil && bytes.Equal(f.before, after) {
+			continue
+		}
+		changes = append(changes, &FileChange{Path: f.ed.path, Before: f.before, After: after})
+	}
+	if !dryRun {
+		contents := make(map[string][]byte, len(changes))
+		for _, fc := range changes {
+			contents[fc.Path] = fc.After
+		}
+		writeDataFiles(contents)
+	}
+	return changes
+}
+
+func (f *refactorFile) root() *yaml.Node {
+	return f.doc.Content[0]
+}
+
+// renameValue moves the value of the old key to the new key. The entry is renamed in place when both keys
+// denote entries of the same hash.
+func (f *refactorFile) renameValue(oldKey, newKey string, op, np []interface{}) {
+	f.ed.key = oldKey
+	root := f.root()
+	v := find(root, op)
+	if v == nil {
+		return
+	}
+	if find(root, np) != nil {
+		f.ed.fail(`the new key '%s' is already set`, newKey)
+	}
+	if kn := keyNode(root, op); kn != nil && sameParent(op, np) {
+		kn.Value = np[len(np)-1].(string)
+		f.changed = true
+		return
+	}
+	if hasAnchor(v) {
+		// The value would end up after the aliases of its anchor
+		f.ed.fail(`the value is, or contains, an anchor`)
+	}
+	kn, _ := f.ed.remove(root, op)
+	f.prune(op)
+	f.ed.set(root, np, v)
+	if nk := keyNode(root, np); kn != nil && nk != nil {
+		nk.HeadComment, nk.LineComment, nk.FootComment = kn.HeadComment, kn.LineComment, kn.FootComment
+	}
+	f.changed = true
+}
+
+// prune removes the hashes along the given path that have become empty
+func (f *refactorFile) prune(parts []interface{}) {
+	for i := len(parts) - 1; i > 0; i-- {
+		n := find(f.root(), parts[:i])
+		if n == nil || n.Kind != yaml.MappingNode || len(n.Content) > 0 || n.Anchor != `` {
+			break
+		}
+		f.ed.remove(f.root(), parts[:i])
+	}
+}
+
+// renameReferences updates the interpolation expressions and the alias_of declarations that refer to the old key
+// or one of its sub keys. A warning is logged for each interpolation expression that can't be parsed. The
+// lookup_options of the old key are renamed when renameOptions is true.
+func (f *refactorFile) renameReferences(ic hieraapi.Invocation, oldKey, newKey string, renameOptions bool) {
+	rename := func(k string) string {
+		if k == oldKey {
+			return newKey
+		}
+		if strings.HasPrefix(k, oldKey+`.`) {
+			return newKey + k[len(oldKey):]
+		}
+		return k
+	}
+
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, `%{`) {
+			s, errs := internal.RenameLookupKeys(n.Value, rename)
+			for _, msg := range errs {
+				ic.Logger().Logf(px.WARNING, `references to '%s' in %s:%d are not updated: %s`, oldKey, f.ed.path, n.Line, msg)
+			}
+			if s != n.Value {
+				n.Value = s
+				f.changed = true
+			}
+		}
+		for _, c := range n.Content {
+			walk(c)
+		}
+	}
+	walk(f.root())
+
+	opts := find(f.root(), []interface{}{`lookup_options`})
+	if opts == nil || opts.Kind != yaml.MappingNode {
+		return
+	}
+	f.ed.key = `lookup_options`
+	for i := 0; i+1 < len(opts.Content); i += 2 {
+		if a := find(opts.Content[i+1], []interface{}{`alias_of`}); a != nil && a.Kind == yaml.ScalarNode {
+			if s := rename(a.Value); s != a.Value {
+				a.Value = s
+				f.changed = true
+			}
+		}
+	}
+	if !renameOptions {
+		return
+	}
+	if kn := keyNode(f.root(), []interface{}{`lookup_options`, oldKey}); kn != nil {
+		if find(opts, []interface{}{newKey}) != nil {
+			f.ed.fail(`the new key '%s' already has lookup_options`, newKey)
+		}
+		kn.Value = newKey
+		f.changed = true
+	}
+}
+
+// keyNode returns the key node of the hash entry at the given path or nil when there is no such entry
+func keyNode(n *yaml.Node, parts []interface{}) *yaml.Node {
+	seg, ok := parts[len(parts)-1].(string)
+	if !ok {
+		return nil
+	}
+	p := find(n, parts[:len(parts)-1])
+	if p == nil || p.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(p.Content); i += 2 {
+		if p.Content[i].Value == seg {
+			return p.Content[i]
+		}
+	}
+	return nil
+}
+
+// sameParent returns true when the given paths denote entries of the same hash
+func sameParent(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	if _, ok := b[len(b)-1].(string); !ok {
+		return false
+	}
+	return reflect.DeepEqual(a[:len(a)-1], b[:len(b)-1])
+}
+
+// hasAnchor returns true when the given node or one of its descendants has an anchor
+func hasAnchor(n *yaml.Node) bool {
+	if n.Anchor != `` {
+		return true
+	}
+	for _, c := range n.Content {
+		if hasAnchor(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeEqual returns true when the given nodes represent the same value. Comments and styles are ignored.
+func nodeEqual(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.ShortTag() != b.ShortTag() || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i, c := range a.Content {
+		if !nodeEqual(c, b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// copyNode returns a deep copy of the given node
+func copyNode(n *yaml.Node) *yaml.Node {
+	c := *n
+	if n.Content != nil {
+		c.Content = make([]*yaml.Node, len(n.Content))
+		for i, e := range n.Content {
+			c.Content[i] = copyNode(e)
+		}
+	}
+	return &c
+}
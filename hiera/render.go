@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/lyraproj/hiera/hieraapi"
 	"github.com/lyraproj/pcore/pcore"
 	"github.com/lyraproj/pcore/px"
 	"github.com/lyraproj/pcore/serialization"
@@ -34,14 +35,7 @@ func Render(c px.Context, renderAs RenderName, value px.Value, out io.Writer) {
 		}
 
 		if renderAs == `yaml` {
-			rf := c.Reflector().Reflect(v)
-			var iv interface{}
-			if rf.IsValid() && rf.CanInterface() {
-				iv = rf.Interface()
-			} else {
-				iv = value.String()
-			}
-			bs, err := yaml.Marshal(iv)
+			bs, err := yaml.Marshal(yamlData(c, value, v))
 			utils.WriteString(out, string(bs))
 			if err != nil {
 				panic(err)
@@ -50,7 +44,7 @@ func Render(c px.Context, renderAs RenderName, value px.Value, out io.Writer) {
 			he := make([]*types.HashEntry, 0, 2)
 			he = append(he, types.WrapHashEntry2(`rich_data`, types.BooleanFalse))
 			he = append(he, types.WrapHashEntry2(`local_reference`, types.BooleanFalse))
-			serialization.NewSerializer(pcore.RootContext(), types.WrapHash(he)).Convert(value, serialization.NewJsonStreamer(out))
+			serialization.NewSerializer(pcore.RootContext(), types.WrapHash(he)).Convert(value, newJSONStreamer(out))
 			utils.WriteByte(out, '\n')
 		}
 	case Binary:
@@ -65,3 +59,70 @@ func Render(c px.Context, renderAs RenderName, value px.Value, out io.Writer) {
 		panic(fmt.Errorf(`unknown rendering '%s'`, renderAs))
 	}
 }
+
+// RenderWithSources renders the given value as yaml and adds a comment with the source to each leaf that
+// has a source in the given slice.
+func RenderWithSources(c px.Context, value px.Value, sources []hieraapi.LeafSource, out io.Writer) {
+	ser := serialization.NewSerializer(c, px.EmptyMap)
+	dc := px.NewCollector()
+	ser.Convert(value, dc)
+
+	bs, err := yaml.Marshal(yamlData(c, value, dc.Value()))
+	if err != nil {
+		panic(err)
+	}
+	doc := &yaml.Node{}
+	if err = yaml.Unmarshal(bs, doc); err != nil {
+		panic(err)
+	}
+	if len(doc.Content) == 1 {
+		for _, ls := range sources {
+			if n := findNode(doc.Content[0], ls.Path); n != nil {
+				n.LineComment = ls.Source.String()
+			}
+		}
+	}
+	if bs, err = yaml.Marshal(doc); err != nil {
+		panic(err)
+	}
+	utils.WriteString(out, string(bs))
+}
+
+func yamlData(c px.Context, value, data px.Value) interface{} {
+	rf := c.Reflector().Reflect(data)
+	if rf.IsValid() && rf.CanInterface() {
+		return rf.Interface()
+	}
+	return value.String()
+}
+
+// findNode returns the node at the given path or nil if no such node exists
+func findNode(n *yaml.Node, path []interface{}) *yaml.Node {
+	for _, p := range path {
+		switch p := p.(type) {
+		case int:
+			if !(n.Kind == yaml.SequenceNode && p < len(n.Content)) {
+				return nil
+			}
+			n = n.Content[p]
+		case string:
+			if n.Kind != yaml.MappingNode {
+				return nil
+			}
+			var found *yaml.Node
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == p {
+					found = n.Content[i+1]
+					break
+				}
+			}
+			if found == nil {
+				return nil
+			}
+			n = found
+		default:
+			return nil
+		}
+	}
+	return n
+}
@@ -0,0 +1,69 @@
+package hiera
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/hiera/internal"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/pcore/utils"
+)
+
+// Validate validates the hiera configuration and the data files that it references using the scope given by the
+// command options and returns all problems found. When allFiles is true, the data files are all files that match
+// the path templates of the hierarchy rather than the files that exist for the scope.
+func Validate(c px.Context, opts *CommandOptions, allFiles bool) []*hieraapi.Problem {
+	return internal.Validate(internal.NewInvocation(c, createScope(c, opts), nil), allFiles)
+}
+
+// jsonProblem is the JSON rendering of a hieraapi.Problem
+type jsonProblem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// ValidateAndRender validates the hiera configuration and the data files that it references and renders the
+// problems on the given io.Writer in accordance with the `RenderAs` option. The text rendering shows one problem
+// per line. The json and yaml renderings produce an array with one hash per problem. The number of problems is
+// returned.
+func ValidateAndRender(c px.Context, opts *CommandOptions, allFiles bool, out io.Writer) int {
+	problems := Validate(c, opts, allFiles)
+	renderAs := Text
+	if opts.RenderAs != `` {
+		renderAs = RenderName(opts.RenderAs)
+	}
+	switch renderAs {
+	case Text:
+		for _, p := range problems {
+			utils.Fprintln(out, p)
+		}
+		return len(problems)
+	case JSON:
+		jps := make([]jsonProblem, len(problems))
+		for i, p := range problems {
+			jps[i] = jsonProblem{p.Location.File(), p.Location.Line(), p.Location.Pos(), p.Message}
+		}
+		bs, err := json.Marshal(jps)
+		if err != nil {
+			panic(err)
+		}
+		utils.WriteString(out, string(bs))
+		utils.WriteByte(out, '\n')
+		return len(problems)
+	}
+
+	ps := make([]px.Value, len(problems))
+	for i, p := range problems {
+		ps[i] = types.WrapHash([]*types.HashEntry{
+			types.WrapHashEntry2(`file`, types.WrapString(p.Location.File())),
+			types.WrapHashEntry2(`line`, types.WrapInteger(int64(p.Location.Line()))),
+			types.WrapHashEntry2(`column`, types.WrapInteger(int64(p.Location.Pos()))),
+			types.WrapHashEntry2(`message`, types.WrapString(p.Message))})
+	}
+	Render(c, renderAs, types.WrapValues(ps), out)
+	return len(problems)
+}
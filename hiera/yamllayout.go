@@ -0,0 +1,270 @@
+package hiera
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlLayout is the indentation used by the text of a YAML data file
+type yamlLayout struct {
+	// indent is the indentation of a hash that is the value of a hash entry
+	indent int
+
+	// seqIndent is the indentation of an array that is the value of a hash entry or -1 when the file has no such
+	// array. Zero means that the dashes of the array are aligned with the key.
+	seqIndent int
+}
+
+// newYAMLLayout returns the layout of the text that the given node was parsed from
+func newYAMLLayout(n *yaml.Node) yamlLayout {
+	l := yamlLayout{seqIndent: -1}
+	l.detect(n)
+	if l.indent < 2 || l.indent > 9 {
+		l.indent = 2
+	}
+	return l
+}
+
+func (l *yamlLayout) detect(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if v.Style&yaml.FlowStyle != 0 || v.Line <= k.Line {
+				continue
+			}
+			switch v.Kind {
+			case yaml.MappingNode:
+				if l.indent == 0 {
+					l.indent = v.Column - k.Column
+				}
+			case yaml.SequenceNode:
+				if l.seqIndent < 0 {
+					l.seqIndent = v.Column - k.Column
+				}
+			}
+		}
+	}
+	for _, c := range n.Content {
+		l.detect(c)
+	}
+}
+
+// encode returns the given node encoded as YAML text that uses the layout
+func (l yamlLayout) encode(n *yaml.Node) []byte {
+	b := bytes.NewBufferString(``)
+	enc := yaml.NewEncoder(b)
+	enc.SetIndent(l.indent)
+	if err := enc.Encode(n); err != nil {
+		panic(err)
+	}
+	if err := enc.Close(); err != nil {
+		panic(err)
+	}
+	if l.seqIndent < 0 {
+		return b.Bytes()
+	}
+	return l.indentSequences(b.Bytes())
+}
+
+// entry returns the text of a hash with the given entry. The comments above and below the key are omitted unless
+// comments is true since they are kept as text when an entry is spliced into a file.
+func (l yamlLayout) entry(k, v *yaml.Node, comments bool) []byte {
+	if !comments {
+		kc := *k
+		kc.HeadComment, kc.FootComment = ``, ``
+		k = &kc
+		if v.Kind == yaml.ScalarNode || v.Kind == yaml.AliasNode {
+			vc := *v
+			vc.FootComment = ``
+			v = &vc
+		}
+	}
+	return l.encode(&yaml.Node{Kind: yaml.MappingNode, Tag: `!!map`, Content: []*yaml.Node{k, v}})
+}
+
+// indentSequences changes the indentation of each block array that is the value of a hash entry in the given text
+// so that it matches the seqIndent of the layout
+func (l yamlLayout) indentSequences(text []byte) []byte {
+	doc := &yaml.Node{}
+	if yaml.Unmarshal(text, doc) != nil || len(doc.Content) == 0 {
+		return text
+	}
+	ls := lines(text)
+	shift := make([]int, len(ls))
+	var walk func(n *yaml.Node, end int)
+	walk = func(n *yaml.Node, end int) {
+		step := 1
+		if n.Kind == yaml.MappingNode {
+			step = 2
+		}
+		for i := 0; i+step <= len(n.Content); i += step {
+			e := end
+			if i+step < len(n.Content) {
+				e = n.Content[i+step].Line - 1
+			}
+			c := n.Content[i]
+			if step == 2 {
+				k := c
+				c = n.Content[i+1]
+				if c.Kind == yaml.SequenceNode && c.Style&yaml.FlowStyle == 0 && c.Line > k.Line {
+					// Comments and blank lines that precede the next entry belong to that entry
+					for e > c.Line && isCommentOrBlank(ls[e-1], k.Column-1) {
+						e--
+					}
+					d := l.seqIndent - (c.Column - k.Column)
+					for ln := c.Line - 1; ln < e; ln++ {
+						shift[ln] += d
+					}
+				}
+			}
+			walk(c, e)
+		}
+	}
+	walk(doc.Content[0], len(ls))
+
+	b := bytes.NewBufferString(``)
+	for i, s := range ls {
+		if d := shift[i]; d > 0 && strings.TrimSpace(s) != `` {
+			b.WriteString(strings.Repeat(` `, d))
+		} else if d < 0 {
+			t := strings.TrimLeft(s, ` `)
+			if len(s)-len(t) > -d {
+				t = s[-d:]
+			}
+			s = t
+		}
+		b.WriteString(s)
+	}
+	return b.Bytes()
+}
+
+// isCommentOrBlank returns true if the given line is blank or a comment that is indented no more than maxIndent
+func isCommentOrBlank(line string, maxIndent int) bool {
+	t := strings.TrimLeft(line, ` `)
+	return strings.TrimSpace(t) == `` || strings.HasPrefix(t, `#`) && len(line)-len(t) <= maxIndent
+}
+
+// spliceYAML returns the text of the edited document doc written over the text that the document was read from.
+// The text of each top-level entry that the edit didn't change is kept together with the comments and blank lines
+// that surround it and only the changed and added entries are encoded. The keys map the key nodes of the document
+// as it was read to their position. Nil is returned when the text can't be spliced.
+func spliceYAML(l yamlLayout, text []byte, keys map[*yaml.Node]int, doc *yaml.Node) []byte {
+	orig := &yaml.Node{}
+	if yaml.Unmarshal(text, orig) != nil || len(orig.Content) == 0 {
+		return nil
+	}
+	om, root := orig.Content[0], doc.Content[0]
+	n := len(om.Content) / 2
+	if om.Kind != yaml.MappingNode || om.Style&yaml.FlowStyle != 0 || n == 0 || n != len(keys) {
+		return nil
+	}
+	untagMergeKeys(om)
+
+	// The lines of entry j are keyLine[j] to end[j]. It is preceded by the comments from start[j] and followed by
+	// the blank lines and comments that precede start[j+1].
+	ls := lines(text)
+	keyLine, start, end := make([]int, n), make([]int, n), make([]int, n)
+	for j := 0; j < n; j++ {
+		k := om.Content[2*j]
+		if k.Column != 1 {
+			return nil
+		}
+		keyLine[j] = k.Line - 1
+	}
+	for j := 0; j < n; j++ {
+		e := len(ls)
+		if j+1 < n {
+			e = keyLine[j+1]
+		}
+		for e > keyLine[j]+1 && isCommentOrBlank(ls[e-1], 0) {
+			e--
+		}
+		end[j] = e
+		s := keyLine[j]
+		lo := 0
+		if j > 0 {
+			lo = end[j-1]
+		}
+		for s > lo && strings.HasPrefix(ls[s-1], `#`) {
+			s--
+		}
+		start[j] = s
+	}
+	gap := func(j int) []string {
+		if j+1 < n {
+			return ls[end[j]:start[j+1]]
+		}
+		return ls[end[j]:]
+	}
+
+	// Added entries are separated by a blank line when all entries of the file are
+	separated := n > 1
+	for j := 0; j+1 < n && separated; j++ {
+		separated = false
+		for _, s := range ls[end[j]:start[j+1]] {
+			if strings.TrimSpace(s) == `` {
+				separated = true
+				break
+			}
+		}
+	}
+
+	b := bytes.NewBufferString(``)
+	write := func(ls []string) {
+		for _, s := range ls {
+			b.WriteString(s)
+		}
+	}
+	newLine := func() {
+		if b.Len() > 0 && !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+			b.WriteByte('\n')
+		}
+	}
+	write(ls[:start[0]])
+	used := make([]bool, n)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+		if j, ok := keys[k]; ok && !used[j] {
+			used[j] = true
+			write(ls[start[j]:keyLine[j]])
+			if ne := l.entry(k, v, false); !bytes.Equal(l.entry(om.Content[2*j], om.Content[2*j+1], false), ne) {
+				newLine()
+				b.Write(ne)
+			} else {
+				write(ls[keyLine[j]:end[j]])
+			}
+			write(gap(j))
+			continue
+		}
+		newLine()
+		if separated && b.Len() > 0 && !bytes.HasSuffix(b.Bytes(), []byte("\n\n")) {
+			b.WriteByte('\n')
+		}
+		b.Write(l.entry(k, v, true))
+	}
+	if !used[n-1] {
+		// Keep the comments at the end of the file
+		write(gap(n - 1))
+	}
+	newLine()
+	bs := b.Bytes()
+	if !bytes.HasSuffix(text, []byte("\n\n")) {
+		// Don't leave the blank lines that separated a removed entry at the end of the file
+		for bytes.HasSuffix(bs, []byte("\n\n")) {
+			bs = bs[:len(bs)-1]
+		}
+	}
+	return bs
+}
+
+// sameYAML returns true if the given texts contain equal YAML data
+func sameYAML(a, b []byte) bool {
+	var av, bv interface{}
+	if yaml.Unmarshal(a, &av) != nil || yaml.Unmarshal(b, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
@@ -19,6 +19,33 @@ const HieraConfig = `Hiera::Config`
 // by the 'scope' lookup_key provider function and when doing variable interpolations
 const HieraScope = `Hiera::Scope`
 
+// HieraStrictInterpolation is an option that, when set to true, makes an interpolation expression that cannot be
+// resolved an error unless the expression has a default. Hierarchy entries can override this option using the
+// key "strict_interpolation".
+const HieraStrictInterpolation = `Hiera::StrictInterpolation`
+
+// HieraShareData is an option that, when set to true, makes the data that the yaml_data and json_data functions
+// read from files shared between all lookup invocations that use the same context. Files are then read once even
+// when many scopes are evaluated, but changes made to them after they were read are not noticed.
+const HieraShareData = `Hiera::ShareData`
+
+// HieraEnvInterpolation is an option that, when set to true, enables the interpolation function env() in data and
+// in the hiera configuration. The function is disabled by default since it can reveal any environment variable of
+// the process to anyone who can edit a data file.
+const HieraEnvInterpolation = `Hiera::EnvInterpolation`
+
+// HieraFileInterpolationDirs is an option whose value is a directory, or an array of directories, that the
+// interpolation function file() is allowed to read from. A relative directory is relative to the directory of the
+// hiera configuration. The function is disabled when the option is not set.
+const HieraFileInterpolationDirs = `Hiera::FileInterpolationDirs`
+
+// HieraDeprecationHandler is an option whose value is a DeprecationHandler wrapped in a runtime value. The handler
+// is called each time a key that is declared deprecated in the lookup_options is looked up.
+const HieraDeprecationHandler = `Hiera::DeprecationHandler`
+
+// A DeprecationHandler is called with the key and the deprecation message when a deprecated key is looked up
+type DeprecationHandler func(key, message string)
+
 // Kind is a function kind.
 type Kind string
 
@@ -72,6 +99,26 @@ type Entry interface {
 	// PluginFile returns pluginfile
 	PluginFile() string
 
+	// PluginAddress returns the address of an externally managed plugin server or an empty string when the
+	// plugin is an executable that Hiera should start.
+	PluginAddress() string
+
+	// PluginDigests returns the hex encoded SHA-256 digests of the plugin executables that are allowed to run
+	// or nil if no such allowlist has been configured
+	PluginDigests() []string
+
+	// PluginPublicKeys returns the base64 encoded ed25519 public keys that may be used for verifying the
+	// signature of a plugin executable or nil if no such keys have been configured
+	PluginPublicKeys() []string
+
+	// PluginProcess returns the configuration of the plugin process environment and limits or nil if no
+	// such configuration exists
+	PluginProcess() *PluginProcess
+
+	// StrictInterpolation returns true when interpolation expressions in the configuration of this entry and in
+	// the values that it provides must be resolved
+	StrictInterpolation() bool
+
 	// Function returns data_dir, data_hash, or lookup_key function
 	Function() Function
 
@@ -88,6 +135,34 @@ type Entry interface {
 	Locations() []Location
 }
 
+// PluginProcess controls the environment, working directory, user, and resource limits of a plugin process
+// that Hiera starts.
+type PluginProcess struct {
+	// Env contains extra environment variables for the plugin process
+	Env map[string]string
+
+	// PassthroughAll is true when all environment variables of Hiera are passed to the plugin process
+	PassthroughAll bool
+
+	// Passthrough are the names of environment variables of Hiera that are passed to the plugin process
+	Passthrough []string
+
+	// WorkDir is the working directory of the plugin process. Empty means the working directory of Hiera
+	WorkDir string
+
+	// User is the name or numeric id of the user that the plugin process runs as. Empty means the user of Hiera
+	User string
+
+	// CPUSeconds is the limit of CPU time in seconds. Zero means no limit
+	CPUSeconds uint64
+
+	// MemoryBytes is the limit of the address space in bytes. Zero means no limit
+	MemoryBytes uint64
+
+	// OpenFiles is the limit of the number of open files. Zero means no limit
+	OpenFiles uint64
+}
+
 // A Config represents a full hiera.yaml version 5 configuration.
 type Config interface {
 	// Root returns the directory holding this Config
@@ -10,3 +10,19 @@ type DataProvider interface {
 	// FullName returns a descriptive name of the data provider. Used by the explainer
 	FullName() string
 }
+
+// A KeyLister is a DataProvider that can list the keys that it has values for. It is an optional capability
+// that is used when searching for keys rather than looking them up.
+type KeyLister interface {
+	DataProvider
+
+	// ListKeys calls the given function with each root key that this provider has a value for, together with
+	// the source of that value
+	ListKeys(invocation Invocation, f func(key string, source Source))
+}
+
+// KeySources is a key together with the sources that define a value for it, in hierarchy order
+type KeySources struct {
+	Key     string
+	Sources []Source
+}
@@ -9,5 +9,6 @@ import "github.com/lyraproj/pcore/px"
 //
 // When both values are hashes, DeepMerge is called recursively entries with identical keys.
 // When both values are arrays, the merge creates a union of the unique elements from the two arrays.
-// No recursive merge takes place for the array elements.
+// No recursive merge takes place for the array elements unless the option "merge_hash_arrays_by"
+// names a key. Hash elements that have equal values for that key are then merged recursively.
 var DeepMerge func(a, b px.Value, options map[string]px.Value) (px.Value, bool)
@@ -0,0 +1,74 @@
+package hieraapi
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/lyraproj/pcore/px"
+)
+
+// InterpolationContext is a set of contexts in which interpolation takes place
+type InterpolationContext int
+
+const (
+	// InterpolationInData is the interpolation of values that are found in data
+	InterpolationInData = InterpolationContext(1 << iota)
+
+	// InterpolationInConfig is the interpolation of paths, uris, and options in the hiera configuration
+	InterpolationInConfig
+)
+
+// InterpolationFunction is a function that can be called from an interpolation expression, e.g.
+// "%{join(lookup('list'), ',')}". The arguments are evaluated before the function is called. Nested calls
+// are evaluated from the inside out and the function is not called when an argument cannot be resolved. The
+// function returns nil or undef when its result cannot be resolved. The default of the expression then applies.
+type InterpolationFunction func(ic Invocation, args []px.Value) px.Value
+
+type interpolationFunction struct {
+	contexts InterpolationContext
+	function InterpolationFunction
+}
+
+// builtinInterpolationMethods are the methods that are handled by the interpolation itself
+var builtinInterpolationMethods = map[string]bool{
+	`alias`:   true,
+	`hiera`:   true,
+	`literal`: true,
+	`lookup`:  true,
+	`scope`:   true,
+}
+
+var interpolationFunctionsLock sync.RWMutex
+var interpolationFunctions = map[string]*interpolationFunction{}
+
+// RegisterInterpolationFunction registers a function that can be called from interpolation expressions in
+// the given contexts. A function that is called in a context that isn't included in the given contexts will
+// result in an error, just as any method call in the configuration does by default. The built-in methods
+// alias, hiera, literal, lookup, and scope cannot be replaced.
+func RegisterInterpolationFunction(name string, contexts InterpolationContext, f InterpolationFunction) {
+	if builtinInterpolationMethods[name] {
+		panic(fmt.Errorf(`the built-in interpolation method %s cannot be replaced`, name))
+	}
+	interpolationFunctionsLock.Lock()
+	interpolationFunctions[name] = &interpolationFunction{contexts, f}
+	interpolationFunctionsLock.Unlock()
+}
+
+// UnregisterInterpolationFunction removes the function with the given name that was registered using
+// RegisterInterpolationFunction. Nothing happens if no such function is registered.
+func UnregisterInterpolationFunction(name string) {
+	interpolationFunctionsLock.Lock()
+	delete(interpolationFunctions, name)
+	interpolationFunctionsLock.Unlock()
+}
+
+// GetInterpolationFunction returns the function registered under the given name together with the contexts
+// in which it is allowed and a boolean to indicate if such a function was found.
+func GetInterpolationFunction(name string) (InterpolationFunction, InterpolationContext, bool) {
+	interpolationFunctionsLock.RLock()
+	defer interpolationFunctionsLock.RUnlock()
+	if f, ok := interpolationFunctions[name]; ok {
+		return f.function, f.contexts, true
+	}
+	return nil, 0, false
+}
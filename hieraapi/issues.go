@@ -8,27 +8,39 @@ import (
 )
 
 const (
+	CannotEditKey                       = `HIERA_CANNOT_EDIT_KEY`
+	CannotRefactorKey                   = `HIERA_CANNOT_REFACTOR_KEY`
 	DigMismatch                         = `HIERA_DIG_MISMATCH`
 	EmptyKeySegment                     = `HIERA_EMPTY_KEY_SEGMENT`
 	EndlessRecursion                    = `HIERA_ENDLESS_RECURSION`
 	FirstKeySegmentInt                  = `HIERA_FIRST_KEY_SEGMENT_INT`
 	HierarchyNameMultiplyDefined        = `HIERA_HIERARCHY_NAME_MULTIPLY_DEFINED`
 	InterpolationAliasNotEntireString   = `HIERA_INTERPOLATION_ALIAS_NOT_ENTIRE_STRING`
+	InterpolationFileNotAllowed         = `HIERA_INTERPOLATION_FILE_NOT_ALLOWED`
+	InterpolationFunctionDisabled       = `HIERA_INTERPOLATION_FUNCTION_DISABLED`
 	InterpolationMethodSyntaxNotAllowed = `HIERA_INTERPOLATION_METHOD_SYNTAX_NOT_ALLOWED`
+	InterpolationSyntaxError            = `HIERA_INTERPOLATION_SYNTAX_ERROR`
 	JSONNOtHash                         = `HIERA_JSON_NOT_HASH`
 	KeyNotFound                         = `HIERA_KEY_NOT_FOUND`
+	KeyTypeMismatch                     = `HIERA_KEY_TYPE_MISMATCH`
 	MissingDataProviderFunction         = `HIERA_MISSING_DATA_PROVIDER_FUNCTION`
 	MissingRequiredOption               = `HIERA_MISSING_REQUIRED_OPTION`
 	MissingRequiredEnvironmentVariable  = `HIERA_MISSING_REQUIRED_ENVIRONMENT_VARIABLE`
 	MultipleDataProviderFunctions       = `HIERA_MULTIPLE_DATA_PROVIDER_FUNCTIONS`
 	MultipleLocationSpecs               = `HIERA_MULTIPLE_LOCATION_SPECS`
 	NameNotFound                        = `HIERA_NAME_NOT_FOUND`
+	NoDataFile                          = `HIERA_NO_DATA_FILE`
+	NotAFileDataEntry                   = `HIERA_NOT_A_FILE_DATA_ENTRY`
 	NotAnyNameFound                     = `HIERA_NOT_ANY_NAME_FOUND`
 	NotInitialized                      = `HIERA_NOT_INITIALIZED`
 	OptionReservedByHiera               = `HIERA_OPTION_RESERVED_BY_HIERA`
+	PluginIntegrityViolation            = `HIERA_PLUGIN_INTEGRITY_VIOLATION`
 	UnterminatedQuote                   = `HIERA_UNTERMINATED_QUOTE`
+	UnknownHierarchyEntry               = `HIERA_UNKNOWN_HIERARCHY_ENTRY`
 	UnknownInterpolationMethod          = `HIERA_UNKNOWN_INTERPOLATION_METHOD`
 	UnknownMergeStrategy                = `HIERA_UNKNOWN_MERGE_STRATEGY`
+	UnresolvedInterpolation             = `HIERA_UNRESOLVED_INTERPOLATION`
+	WrongInterpolationArgCount          = `HIERA_WRONG_INTERPOLATION_ARG_COUNT`
 	YamlNotHash                         = `HIERA_YAML_NOT_HASH`
 )
 
@@ -40,6 +52,10 @@ func joinNames(v interface{}) string {
 }
 
 func init() {
+	issue.Hard(CannotEditKey, `Unable to edit key '%{key}' in '%{path}': %{reason}`)
+
+	issue.Hard(CannotRefactorKey, `Unable to refactor key '%{key}': %{reason}`)
+
 	issue.Hard(DigMismatch,
 		`lookup() Got %{type} when a hash-like object was expected to access value using '%{segment}' from key '%{key}'`)
 
@@ -53,12 +69,21 @@ func init() {
 
 	issue.Hard(InterpolationAliasNotEntireString, `'alias' interpolation is only permitted if the expression is equal to the entire string`)
 
+	issue.Hard(InterpolationFileNotAllowed, `Interpolation method 'file' is not allowed to read '%{path}'`)
+
+	issue.Hard(InterpolationFunctionDisabled, `Interpolation method '%{name}' must be enabled using the option %{option}`)
+
 	issue.Hard(InterpolationMethodSyntaxNotAllowed, `Interpolation using method syntax is not allowed in this context`)
 
+	issue.Hard(InterpolationSyntaxError, `Syntax error in interpolation expression '%{expression}': %{detail}`)
+
 	issue.Hard(JSONNOtHash, `File '%{path}' does not contain a JSON object`)
 
 	issue.Hard(KeyNotFound, `key not found`)
 
+	issue.Hard(KeyTypeMismatch,
+		`lookup() value of key '%{key}' from %{provider} does not match the type declared in lookup_options: %{detail}`)
+
 	issue.Hard2(MissingDataProviderFunction, `One of %{keys} must be defined in hierarchy '%{name}'`,
 		issue.HF{`keys`: joinNames})
 
@@ -74,6 +99,10 @@ func init() {
 
 	issue.Hard(NameNotFound, `lookup() did not find a value for the name '%{name}'`)
 
+	issue.Hard(NoDataFile, `Hierarchy entry '%{name}' has no data file for the current scope: %{reason}`)
+
+	issue.Hard(NotAFileDataEntry, `Hierarchy entry '%{name}' does not read its data from yaml_data or json_data files`)
+
 	issue.Hard2(NotAnyNameFound, `lookup() did not find a value for any of the names [%{name_list}]`,
 		issue.HF{`name_list`: joinNames})
 
@@ -81,11 +110,19 @@ func init() {
 
 	issue.Hard(OptionReservedByHiera, `Option key '%{key}' used in hierarchy '%{name}' is reserved by Hiera`)
 
+	issue.Hard(PluginIntegrityViolation, `Refusing to start plugin '%{path}': %{reason}`)
+
+	issue.Hard(UnknownHierarchyEntry, `No hierarchy entry named '%{name}'`)
+
 	issue.Hard(UnknownInterpolationMethod, `Unknown interpolation method '%{name}'`)
 
 	issue.Hard(UnknownMergeStrategy, `Unknown merge strategy '%{name}'`)
 
+	issue.Hard(UnresolvedInterpolation, `Unable to resolve interpolation expression '%{expression}' in %{subject}`)
+
 	issue.Hard(UnterminatedQuote, `Unterminated quote in key '%{key}'`)
 
+	issue.Hard(WrongInterpolationArgCount, `Interpolation method '%{name}' expects %{expected}, got %{actual}`)
+
 	issue.Hard(YamlNotHash, `File '%{path}' does not contain a YAML hash`)
 }
@@ -18,7 +18,9 @@ type Key interface {
 	// a nested chain of single entry hashes is returned.
 	Bury(px.Value) px.Value
 
-	// Return the parts of this key. Each part is either a string or an int value
+	// Return the parts of this key. Each part is either a string, an int value, or a wildcard or filter
+	// segment. Wildcards and filters implement fmt.Stringer and are returned in their source form by String(). They
+	// are encoded as JSON objects such as {"pattern":"*"} so that they cannot be confused with string parts.
 	Parts() []interface{}
 
 	// Return the root key, i.e. the first part.
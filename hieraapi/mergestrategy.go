@@ -1,6 +1,9 @@
 package hieraapi
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/lyraproj/issue/issue"
 	"github.com/lyraproj/pcore/px"
 )
@@ -15,7 +18,7 @@ const (
 )
 
 // GetMergeStrategy returns the MergeStrategy that corresponds to the given name. The
-// options argument is only applicable to deep merge
+// options argument is only applicable to deep merge and to registered strategies
 var GetMergeStrategy func(name MergeStrategyName, options map[string]px.Value) MergeStrategy
 
 // MergeStrategy is responsible for merging or prioritizing the result of several lookups into one.
@@ -32,3 +35,48 @@ type MergeStrategy interface {
 	// Options returns the options for this strategy or an empty map if strategy has no options
 	Options() px.OrderedMap
 }
+
+// MergeStrategyFactory creates a MergeStrategy using the options given in the lookup_options or
+// in the options passed to the lookup.
+type MergeStrategyFactory func(options map[string]px.Value) MergeStrategy
+
+// MergeFunction merges the value b into the value a and returns the result. The value a is the result
+// of merging the values found in locations that have higher priority than the location where b was found.
+type MergeFunction func(a, b px.Value) px.Value
+
+// NewMergeStrategy returns a MergeStrategy that uses the given function to merge the values found in
+// the locations of the hierarchy. The strategy reports to the explainer in the same way as the built-in
+// strategies. The label is used in explanations and error messages, e.g. "max merge strategy".
+var NewMergeStrategy func(name MergeStrategyName, label string, merge MergeFunction, options map[string]px.Value) MergeStrategy
+
+var mergeStrategiesLock sync.RWMutex
+var mergeStrategies = map[MergeStrategyName]MergeStrategyFactory{}
+
+// RegisterMergeStrategy registers a merge strategy that can be selected by name from lookup_options and
+// in the options of a lookup, just like the built-in strategies. The built-in strategies cannot be replaced.
+func RegisterMergeStrategy(name MergeStrategyName, factory MergeStrategyFactory) {
+	switch name {
+	case First, Unique, Hash, Deep:
+		panic(fmt.Errorf(`the built-in merge strategy %s cannot be replaced`, name))
+	}
+	mergeStrategiesLock.Lock()
+	mergeStrategies[name] = factory
+	mergeStrategiesLock.Unlock()
+}
+
+// UnregisterMergeStrategy removes the merge strategy with the given name that was registered using
+// RegisterMergeStrategy. Nothing happens if no such strategy is registered.
+func UnregisterMergeStrategy(name MergeStrategyName) {
+	mergeStrategiesLock.Lock()
+	delete(mergeStrategies, name)
+	mergeStrategiesLock.Unlock()
+}
+
+// RegisteredMergeStrategy returns the factory for the registered merge strategy with the given name and
+// a boolean to indicate if such a strategy was found.
+func RegisteredMergeStrategy(name MergeStrategyName) (MergeStrategyFactory, bool) {
+	mergeStrategiesLock.RLock()
+	defer mergeStrategiesLock.RUnlock()
+	f, ok := mergeStrategies[name]
+	return f, ok
+}
@@ -0,0 +1,19 @@
+package hieraapi
+
+import (
+	"strings"
+
+	"github.com/lyraproj/pcore/px"
+)
+
+// DescribeMismatch describes why the given value is not an instance of the given type, e.g. "expects an Integer
+// value, got String"
+func DescribeMismatch(expected px.Type, value px.Value) string {
+	d := px.DescribeMismatch(``, expected, px.DetailedValueType(value))
+
+	// The description is prefixed with a function name which is empty here
+	if ix := strings.Index(d, `: `); ix >= 0 {
+		d = d[ix+2:]
+	}
+	return d
+}
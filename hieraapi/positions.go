@@ -0,0 +1,51 @@
+package hieraapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+)
+
+// Positions maps paths in a data hash to the locations in a file where the values at those paths are defined. A
+// path is the sequence of hash keys and array indexes that leads to a value from the root of the data hash.
+type Positions map[string]issue.Location
+
+// PositionsFunc returns the positions of the values in a data hash. It is used to defer the parsing of the positions
+// until they are needed, e.g. by an explanation or when the sources of a value are shown.
+type PositionsFunc func() Positions
+
+// DataHashWithPositions is a DataHash function that also returns a function that produces the positions of the
+// values in the returned hash. The returned function may be nil when the positions are unknown.
+type DataHashWithPositions func(ctx ServerContext) (px.OrderedMap, PositionsFunc)
+
+// Add adds the location of the value at the given path
+func (p Positions) Add(path []interface{}, location issue.Location) {
+	p[positionKey(path)] = location
+}
+
+// Get returns the location of the value at the given path or nil if that location is unknown
+func (p Positions) Get(path []interface{}) issue.Location {
+	if p == nil {
+		return nil
+	}
+	return p[positionKey(path)]
+}
+
+// Get returns the location of the value at the given path or nil if that location is unknown. The positions are
+// produced by this call unless they have been produced already.
+func (f PositionsFunc) Get(path []interface{}) issue.Location {
+	if f == nil {
+		return nil
+	}
+	return f().Get(path)
+}
+
+func positionKey(path []interface{}) string {
+	ps := make([]string, len(path))
+	for i, p := range path {
+		ps[i] = fmt.Sprint(p)
+	}
+	return strings.Join(ps, "\x00")
+}
@@ -0,0 +1,63 @@
+package hieraapi
+
+import (
+	"fmt"
+
+	"github.com/lyraproj/issue/issue"
+)
+
+// A Problem is a problem found when validating a configuration or the data files that it references
+type Problem struct {
+	// Location is the file and, when known, the line and column where the problem was found
+	Location issue.Location
+
+	// Message describes the problem
+	Message string
+}
+
+// String returns the problem in the form "<file>:<line>:<column>: <message>". The line and column are omitted
+// when they are unknown.
+func (p *Problem) String() string {
+	l := p.Location
+	switch {
+	case l.Line() > 0 && l.Pos() > 0:
+		return fmt.Sprintf(`%s:%d:%d: %s`, l.File(), l.Line(), l.Pos(), p.Message)
+	case l.Line() > 0:
+		return fmt.Sprintf(`%s:%d: %s`, l.File(), l.Line(), p.Message)
+	}
+	return fmt.Sprintf(`%s: %s`, l.File(), p.Message)
+}
+
+// LintKind is the kind of a data smell found when linting the data of a configuration
+type LintKind string
+
+const (
+	// LintRedundant is a key that is defined with the same value at a higher level of the hierarchy for every scope
+	// that reaches the file
+	LintRedundant = LintKind(`redundant`)
+
+	// LintShadowed is a definition of a key that never wins under the first merge strategy
+	LintShadowed = LintKind(`shadowed`)
+
+	// LintUnusedLookupOptions is a lookup_options entry for a key that isn't defined
+	LintUnusedLookupOptions = LintKind(`unused_lookup_options`)
+
+	// LintUnknownInterpolation is an interpolation that refers to an unknown scope variable or key
+	LintUnknownInterpolation = LintKind(`unknown_interpolation`)
+
+	// LintDeprecated is a definition of a key that is deprecated or that is an alias of another key
+	LintDeprecated = LintKind(`deprecated_key`)
+)
+
+// A LintFinding is a data smell found when linting the data of a configuration
+type LintFinding struct {
+	Problem
+
+	// Kind is the kind of smell
+	Kind LintKind
+}
+
+// String returns the finding in the same form as a Problem followed by its kind in brackets
+func (f *LintFinding) String() string {
+	return fmt.Sprintf(`%s [%s]`, f.Problem.String(), f.Kind)
+}
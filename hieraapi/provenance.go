@@ -0,0 +1,52 @@
+package hieraapi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Source describes where a value was found
+type Source struct {
+	// Entry is the name of the hierarchy entry
+	Entry string
+
+	// Location is the resolved location, e.g. the path of a data file, or an empty string when the
+	// hierarchy entry has no locations
+	Location string
+
+	// Provider is the full name of the data provider, e.g. "data_hash function 'yaml_data'"
+	Provider string
+
+	// Line is the line in the file at Location where the value is defined or zero when the line is unknown
+	Line int
+}
+
+// String returns the entry name followed by the location and line, if any
+func (s *Source) String() string {
+	if s.Location == `` {
+		return s.Entry
+	}
+	if s.Line > 0 {
+		return fmt.Sprintf(`%s: %s:%d`, s.Entry, s.Location, s.Line)
+	}
+	return s.Entry + `: ` + s.Location
+}
+
+// LeafSource associates a leaf in a found value with its source
+type LeafSource struct {
+	// Path is the path to the leaf in the found value. Each element is a string hash key or an int array index.
+	// The path is empty when the found value itself is a leaf.
+	Path []interface{}
+
+	// Source is where the leaf was found
+	Source *Source
+}
+
+// PathString returns the path of the leaf in dotted key notation
+func (l *LeafSource) PathString() string {
+	ps := make([]string, len(l.Path))
+	for i, p := range l.Path {
+		ps[i] = fmt.Sprint(p)
+	}
+	return strings.Join(ps, `.`)
+}
@@ -0,0 +1,53 @@
+package hieraapi
+
+import "sync"
+
+// WasmLimits are the resource limits imposed on a WebAssembly module. A zero value means no limit.
+type WasmLimits struct {
+	// MemoryBytes is the maximum size of the linear memory of the module
+	MemoryBytes uint64
+
+	// Calls is the maximum number of function calls that the module may perform during one call. Only calls are
+	// counted, not instructions, so a loop that doesn't call functions is not bounded by this limit.
+	Calls uint64
+}
+
+// WasmModule is a WASI WebAssembly module that provides Hiera functions.
+type WasmModule interface {
+	// Meta returns the meta-info of the module. It is the same JSON object that a plugin executable writes on
+	// stdout when it starts, i.e. the "version" and the "functions" map.
+	Meta() ([]byte, error)
+
+	// Call performs a call to the module. The request and the response are the same JSON objects that are
+	// used by plugins that use the stdio transport.
+	Call(request []byte) ([]byte, error)
+
+	// Close releases all resources held by the module
+	Close() error
+}
+
+// WasmRuntime is capable of loading WASI WebAssembly modules and run them in-process.
+type WasmRuntime interface {
+	// Load compiles the given module code and applies the given limits to the module.
+	Load(code []byte, limits WasmLimits) (WasmModule, error)
+}
+
+var wasmLock sync.RWMutex
+var wasmRuntime WasmRuntime
+
+// RegisterWasmRuntime registers the runtime that Hiera uses to load WebAssembly plugins, i.e. files with the
+// extension ".wasm" in the plugin directory, instead of the bundled runtime. Passing nil unregisters the current
+// runtime so that the bundled runtime is used again.
+func RegisterWasmRuntime(rt WasmRuntime) {
+	wasmLock.Lock()
+	wasmRuntime = rt
+	wasmLock.Unlock()
+}
+
+// GetWasmRuntime returns the registered WebAssembly runtime or nil if no runtime has been registered, in which case
+// the bundled runtime is used.
+func GetWasmRuntime() WasmRuntime {
+	wasmLock.RLock()
+	defer wasmLock.RUnlock()
+	return wasmRuntime
+}
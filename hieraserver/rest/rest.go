@@ -5,10 +5,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/lyraproj/hiera/hiera"
 	"github.com/lyraproj/hiera/hieraapi"
@@ -40,7 +44,7 @@ func newCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:    "server",
 		Short:  `Server - Start a Hiera REST server`,
-		Long:   "Server - Start a REST server that performs lookups in a Hiera data storage.\n  Responds to key lookups under the /lookup endpoint",
+		Long:   "Server - Start a REST server that performs lookups in a Hiera data storage.\n  Responds to key lookups under the /lookup endpoint and to requests for metrics under the /metrics endpoint",
 		PreRun: initialize,
 		Run:    startServer,
 		Args:   cobra.NoArgs}
@@ -61,13 +65,52 @@ func initialize(_ *cobra.Command, _ []string) {
 
 var keyPattern = regexp.MustCompile(`^/lookup/(.*)$`)
 
-func startServer(cmd *cobra.Command, _ []string) {
-	configOptions := map[string]px.Value{
-		provider.LookupKeyFunctions: types.WrapRuntime([]hieraapi.LookupKey{provider.ConfigLookupKey, provider.Environment})}
+// keyCounter counts events per key
+type keyCounter struct {
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+// deprecatedLookups counts the lookups of keys that are deprecated in lookup_options
+var deprecatedLookups = &keyCounter{counts: make(map[string]int)}
+
+func (kc *keyCounter) increment(key string) {
+	kc.lock.Lock()
+	kc.counts[key]++
+	kc.lock.Unlock()
+}
+
+// writeMetric writes the counts as a counter with the given name and help text in the Prometheus text format
+func (kc *keyCounter) writeMetric(w io.Writer, name, help string) {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+	keys := make([]string, 0, len(kc.counts))
+	for k := range kc.counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(w, "%s{key=\"%s\"} %d\n", name, labelEscaper.Replace(k), kc.counts[k])
+	}
+}
 
-	configOptions[hieraapi.HieraConfig] = types.WrapString(config)
+// labelEscaper escapes a label value of the Prometheus text format where backslash, double-quote, and line feed
+// are the only characters that must be escaped.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// serverOptions returns the options used when creating the Hiera context of the server
+func serverOptions(configPath string) map[string]px.Value {
+	return map[string]px.Value{
+		provider.LookupKeyFunctions: types.WrapRuntime([]hieraapi.LookupKey{provider.ConfigLookupKey, provider.Environment}),
+		hieraapi.HieraConfig:        types.WrapString(configPath),
+		hieraapi.HieraDeprecationHandler: types.WrapRuntime(hieraapi.DeprecationHandler(func(key, _ string) {
+			deprecatedLookups.increment(key)
+		}))}
+}
 
-	hiera.DoWithParent(context.Background(), provider.MuxLookupKey, configOptions, func(ctx px.Context) {
+func startServer(cmd *cobra.Command, _ []string) {
+	hiera.DoWithParent(context.Background(), provider.MuxLookupKey, serverOptions(config), func(ctx px.Context) {
 		ctx.Set(`logLevel`, px.LogLevelFromString(logLevel))
 		router := CreateRouter(ctx)
 		err := http.ListenAndServe(addr+":"+strconv.Itoa(port), router)
@@ -118,7 +161,13 @@ func CreateRouter(ctx px.Context) http.Handler {
 		}
 	}
 
+	metrics := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		deprecatedLookups.writeMetric(w, `hiera_deprecated_key_lookups_total`, `Number of lookups of keys that are deprecated in lookup_options`)
+	}
+
 	router := http.NewServeMux()
 	router.HandleFunc("/lookup/", doLookup)
+	router.HandleFunc("/metrics", metrics)
 	return router
 }
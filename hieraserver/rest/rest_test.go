@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lyraproj/hiera/hiera"
+	"github.com/lyraproj/hiera/provider"
+	"github.com/lyraproj/pcore/px"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateRouter_metrics(t *testing.T) {
+	deprecatedLookups = &keyCounter{counts: make(map[string]int)}
+	configPath, err := filepath.Abs(filepath.Join(`testdata`, `deprecated`, `hiera.yaml`))
+	require.NoError(t, err)
+
+	hiera.DoWithParent(context.Background(), provider.MuxLookupKey, serverOptions(configPath), func(ctx px.Context) {
+		server := httptest.NewServer(CreateRouter(ctx))
+		defer server.Close()
+
+		require.Equal(t, `30`, strings.TrimSpace(get(t, server.URL+`/lookup/timeout`)))
+
+		metrics := get(t, server.URL+`/metrics`)
+		require.Contains(t, metrics, "\nhiera_deprecated_key_lookups_total{key=\"timeout\"} 1\n")
+	})
+}
+
+func TestKeyCounter_writeMetric(t *testing.T) {
+	kc := &keyCounter{counts: map[string]int{"a\\b\"c\nd\te": 2}}
+	out := bytes.Buffer{}
+	kc.writeMetric(&out, `x_total`, `some help`)
+	require.Equal(t, "# HELP x_total some help\n# TYPE x_total counter\nx_total{key=\"a\\\\b\\\"c\\nd\te\"} 2\n", out.String())
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
@@ -0,0 +1,22 @@
+package internal
+
+import "github.com/lyraproj/issue/issue"
+
+// catch calls the given function and returns the message of the error that it panics with, or an empty string
+// when it returns normally
+func catch(f func()) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch r := r.(type) {
+			case issue.Reported:
+				msg = r.WithLocation(nil).Error()
+			case error:
+				msg = r.Error()
+			default:
+				panic(r)
+			}
+		}
+	}()
+	f()
+	return
+}
@@ -41,6 +41,11 @@ type entry struct {
 	dataDir    string
 	pluginDir  string
 	pluginFile string
+	pluginAddr string
+	digests    []string
+	publicKeys []string
+	process    *hieraapi.PluginProcess
+	strict     *bool
 	options    px.OrderedMap
 	optsMap    map[string]px.Value
 	function   hieraapi.Function
@@ -68,6 +73,26 @@ func (e *entry) PluginFile() string {
 	return e.pluginFile
 }
 
+func (e *entry) PluginAddress() string {
+	return e.pluginAddr
+}
+
+func (e *entry) PluginDigests() []string {
+	return e.digests
+}
+
+func (e *entry) PluginPublicKeys() []string {
+	return e.publicKeys
+}
+
+func (e *entry) PluginProcess() *hieraapi.PluginProcess {
+	return e.process
+}
+
+func (e *entry) StrictInterpolation() bool {
+	return e.strict != nil && *e.strict
+}
+
 func (e *entry) Function() hieraapi.Function {
 	return e.function
 }
@@ -117,77 +142,117 @@ func (e *entry) CreateProvider() hieraapi.DataProvider {
 }
 
 func (e *entry) Resolve(ic hieraapi.Invocation, defaults hieraapi.Entry) hieraapi.Entry {
-	// Resolve interpolated strings and locations
 	ce := *e
 
-	if ce.function == nil {
+	if ce.strict == nil {
+		var strict bool
 		if defaults == nil {
-			ce.function = &function{kind: hieraapi.KindDataHash, name: `yaml_data`}
+			strict = globalStrictInterpolation(ic)
 		} else {
-			ce.function = defaults.Function()
+			strict = defaults.StrictInterpolation()
 		}
-	} else if f, fc := ce.function.Resolve(ic); fc {
-		ce.function = f
+		ce.strict = &strict
+	}
+	subject := `defaults`
+	if defaults != nil {
+		subject = fmt.Sprintf(`hierarchy entry '%s'`, e.name)
+	}
+	ic.(*invocation).withInterpolationMode(*ce.strict, subject, func() px.Value {
+		ce.resolve(ic, defaults)
+		return nil
+	})
+	return &ce
+}
+
+// resolve resolves interpolated strings and locations of this entry, which is a copy of the original entry
+func (e *entry) resolve(ic hieraapi.Invocation, defaults hieraapi.Entry) {
+	if e.function == nil {
+		if defaults == nil {
+			e.function = &function{kind: hieraapi.KindDataHash, name: `yaml_data`}
+		} else {
+			e.function = defaults.Function()
+		}
+	} else if f, fc := e.function.Resolve(ic); fc {
+		e.function = f
 	}
 
-	if ce.function == nil {
+	if e.function == nil {
 		panic(px.Error(hieraapi.MissingDataProviderFunction, issue.H{`keys`: hieraapi.FunctionKeys, `name`: e.name}))
 	}
 
-	if ce.dataDir == `` {
+	if e.dataDir == `` {
 		if defaults == nil {
-			ce.dataDir = defaultDataDir()
+			e.dataDir = defaultDataDir()
 		} else {
-			ce.dataDir = defaults.DataDir()
+			e.dataDir = defaults.DataDir()
 		}
 	} else {
-		if d, dc := interpolateString(ic, ce.dataDir, false); dc {
-			ce.dataDir = d.String()
+		if d, dc := interpolateString(ic, e.dataDir, false); dc {
+			e.dataDir = d.String()
 		}
 	}
 
-	if ce.pluginDir == `` {
+	if e.pluginDir == `` {
 		if defaults == nil {
-			ce.pluginDir = defaultPluginDir()
+			e.pluginDir = defaultPluginDir()
 		} else {
-			ce.pluginDir = defaults.PluginDir()
+			e.pluginDir = defaults.PluginDir()
 		}
 	} else {
-		if d, dc := interpolateString(ic, ce.pluginDir, false); dc {
-			ce.pluginDir = d.String()
+		if d, dc := interpolateString(ic, e.pluginDir, false); dc {
+			e.pluginDir = d.String()
 		}
 	}
-	if !filepath.IsAbs(ce.pluginDir) {
-		ce.pluginDir = filepath.Join(e.cfg.root, ce.pluginDir)
+	if !filepath.IsAbs(e.pluginDir) {
+		e.pluginDir = filepath.Join(e.cfg.root, e.pluginDir)
+	}
+
+	if e.digests == nil && defaults != nil {
+		e.digests = defaults.PluginDigests()
+	}
+	if e.publicKeys == nil && defaults != nil {
+		e.publicKeys = defaults.PluginPublicKeys()
+	}
+	if e.process == nil && defaults != nil {
+		e.process = defaults.PluginProcess()
+	}
+	if e.process != nil && e.process.WorkDir != `` && !filepath.IsAbs(e.process.WorkDir) {
+		pp := *e.process
+		pp.WorkDir = filepath.Join(e.cfg.root, pp.WorkDir)
+		e.process = &pp
+	}
+
+	if e.pluginAddr != `` {
+		if a, ac := interpolateString(ic, e.pluginAddr, false); ac {
+			e.pluginAddr = a.String()
+		}
 	}
 
-	if ce.options == nil {
+	if e.options == nil {
 		if defaults != nil {
-			ce.options = defaults.Options()
-			ce.optsMap = defaults.OptionsMap()
+			e.options = defaults.Options()
+			e.optsMap = defaults.OptionsMap()
 		}
-	} else if ce.options.Len() > 0 {
-		if o, oc := doInterpolate(ic, ce.options, false); oc {
-			ce.options = o.(*types.Hash)
+	} else if e.options.Len() > 0 {
+		if o, oc := doInterpolate(ic, e.options, false); oc {
+			e.options = o.(*types.Hash)
 		}
-		ce.optsMap = ce.options.ToStringMap()
+		e.optsMap = e.options.ToStringMap()
 	}
 
 	var dataRoot string
-	if filepath.IsAbs(ce.dataDir) {
-		dataRoot = ce.dataDir
+	if filepath.IsAbs(e.dataDir) {
+		dataRoot = e.dataDir
 	} else {
-		dataRoot = filepath.Join(e.cfg.root, ce.dataDir)
+		dataRoot = filepath.Join(e.cfg.root, e.dataDir)
 	}
-	if ce.locations != nil {
-		ne := make([]hieraapi.Location, 0, len(ce.locations))
-		for _, l := range ce.locations {
+	if e.locations != nil {
+		ne := make([]hieraapi.Location, 0, len(e.locations))
+		for _, l := range e.locations {
 			ne = append(ne, l.Resolve(ic, dataRoot)...)
 		}
-		ce.locations = ne
+		e.locations = ne
 	}
-
-	return &ce
 }
 
 type hieraCfg struct {
@@ -361,6 +426,17 @@ func (hc *hieraCfg) createEntry(ic hieraapi.Invocation, name string, entryHash *
 			entry.pluginDir = v.String()
 		case ks == `pluginfile`:
 			entry.pluginFile = v.String()
+		case ks == `plugin_address`:
+			entry.pluginAddr = v.String()
+		case ks == `plugin_digests`:
+			entry.digests = stringSlice(v.(*types.Array))
+		case ks == `plugin_public_keys`:
+			entry.publicKeys = stringSlice(v.(*types.Array))
+		case ks == `plugin_process`:
+			entry.process = createPluginProcess(v.(*types.Hash))
+		case ks == `strict_interpolation`:
+			strict := v.(px.Boolean).Bool()
+			entry.strict = &strict
 		case utils.ContainsString(hieraapi.LocationKeys, ks):
 			if entry.locations != nil {
 				panic(px.Error(hieraapi.MultipleLocationSpecs, issue.H{`keys`: hieraapi.LocationKeys, `name`: name}))
@@ -393,6 +469,47 @@ func (hc *hieraCfg) createEntry(ic hieraapi.Invocation, name string, entryHash *
 	return entry
 }
 
+func createPluginProcess(hash *types.Hash) *hieraapi.PluginProcess {
+	pp := &hieraapi.PluginProcess{}
+	if ev, ok := hash.Get4(`env`); ok {
+		em := ev.(*types.Hash)
+		pp.Env = make(map[string]string, em.Len())
+		em.EachPair(func(k, v px.Value) { pp.Env[k.String()] = v.String() })
+	}
+	if pv, ok := hash.Get4(`passthrough_env`); ok {
+		if pa, ok := pv.(*types.Array); ok {
+			pp.Passthrough = stringSlice(pa)
+		} else {
+			pp.PassthroughAll = pv.(px.Boolean).Bool()
+		}
+	}
+	if wv, ok := hash.Get4(`workdir`); ok {
+		pp.WorkDir = wv.String()
+	}
+	if uv, ok := hash.Get4(`user`); ok {
+		pp.User = uv.String()
+	}
+	if lv, ok := hash.Get4(`limits`); ok {
+		lm := lv.(*types.Hash)
+		limit := func(name string) uint64 {
+			if iv, ok := lm.Get4(name); ok {
+				return uint64(iv.(px.Integer).Int())
+			}
+			return 0
+		}
+		pp.CPUSeconds = limit(`cpu_seconds`)
+		pp.MemoryBytes = limit(`memory_bytes`)
+		pp.OpenFiles = limit(`open_files`)
+	}
+	return pp
+}
+
+func stringSlice(a *types.Array) []string {
+	ss := make([]string, a.Len())
+	a.EachWithIndex(func(v px.Value, i int) { ss[i] = v.String() })
+	return ss
+}
+
 type resolvedConfig struct {
 	config           *hieraCfg
 	providers        []hieraapi.DataProvider
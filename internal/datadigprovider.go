@@ -57,6 +57,7 @@ func (dh *DataDigProvider) lookupKey(ic hieraapi.Invocation, location hieraapi.L
 	cache, _ := dh.hashes.LoadOrStore(cacheKey, &sync.Map{})
 	value := dh.providerFunction(ic)(newServerContext(ic, cache.(*sync.Map), opts), key)
 	if value != nil {
+		requireEntryInterpolationMode(ic, dh.hierarchyEntry)
 		ic.ReportFound(key.Source(), value)
 	} else {
 		ic.ReportNotFound(key)
@@ -83,6 +84,11 @@ func (dh *DataDigProvider) loadFunction(ic hieraapi.Invocation) (pf hieraapi.Dat
 	return func(pc hieraapi.ServerContext, key hieraapi.Key) px.Value { return nil }
 }
 
+// HierarchyEntry returns the hierarchy entry that this provider was created from
+func (dh *DataDigProvider) HierarchyEntry() hieraapi.Entry {
+	return dh.hierarchyEntry
+}
+
 func (dh *DataDigProvider) FullName() string {
 	return fmt.Sprintf(`data_dig function '%s'`, dh.hierarchyEntry.Function().Name())
 }
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+)
+
+// DataFile returns the path of the data file that the hierarchy entry with the given name reads for the scope
+// of the given invocation, together with the name of the yaml_data or json_data function that reads it. The
+// first location of the entry that exists is returned. When none exists, the first location is returned provided
+// that the entry uses path templates and that every interpolation expression of the template has a value, since
+// the file can then be created. An error is raised when the entry doesn't exist, doesn't use one of the file data
+// functions, or has no such file.
+func DataFile(ic hieraapi.Invocation, level string) (file, fn string) {
+	cfg := ic.Config()
+	providers := append(cfg.Hierarchy()[:len(cfg.Hierarchy()):len(cfg.Hierarchy())], cfg.DefaultHierarchy()...)
+	for _, dp := range providers {
+		ep, ok := dp.(hierarchyEntryProvider)
+		if !ok || ep.HierarchyEntry().Name() != level {
+			continue
+		}
+		he := ep.HierarchyEntry()
+		if _, ok := dp.(*DataHashProvider); !(ok && isFileDataFunction(he.Function())) {
+			panic(px.Error(hieraapi.NotAFileDataEntry, issue.H{`name`: level}))
+		}
+		fn = he.Function().Name()
+
+		locations := he.Locations()
+		for _, loc := range locations {
+			if loc.Exists() {
+				return loc.Resolved(), fn
+			}
+		}
+		if len(locations) == 0 {
+			panic(px.Error(hieraapi.NoDataFile, issue.H{`name`: level, `reason`: `no location matches`}))
+		}
+		t, ok := templateOf(cfg.Config().(*hieraCfg), level)
+		if !ok {
+			panic(px.Error(hieraapi.NoDataFile, issue.H{`name`: level, `reason`: `only files that match a path template can be created`}))
+		}
+		for _, match := range iplPattern.FindAllString(t.original, -1) {
+			if Interpolate(ic, types.WrapString(match), false).String() == `` {
+				panic(px.Error(hieraapi.NoDataFile, issue.H{`name`: level, `reason`: `interpolation '` + match + `' has no value`}))
+			}
+		}
+		return locations[0].Resolved(), fn
+	}
+	panic(px.Error(hieraapi.UnknownHierarchyEntry, issue.H{`name`: level}))
+}
+
+// templateOf returns the first path template of the hierarchy entry with the given name. False is returned when
+// the entry uses globs, mapped paths, or uris rather than paths.
+func templateOf(cfg *hieraCfg, level string) (*path, bool) {
+	if e := cfg.entryNamed(level); e != nil && len(e.locations) > 0 {
+		p, ok := e.locations[0].(*path)
+		return p, ok
+	}
+	return nil, false
+}
+
+// allEntries returns the entries of the hierarchy followed by the entries of the default hierarchy
+func (cfg *hieraCfg) allEntries() []hieraapi.Entry {
+	return append(cfg.hierarchy[:len(cfg.hierarchy):len(cfg.hierarchy)], cfg.defaultHierarchy...)
+}
+
+// entryNamed returns the entry with the given name from the hierarchy or the default hierarchy, or nil when no
+// such entry exists
+func (cfg *hieraCfg) entryNamed(name string) *entry {
+	for _, he := range cfg.allEntries() {
+		if he.Name() == name {
+			return he.(*entry)
+		}
+	}
+	return nil
+}
+
+// A TemplateFile is a data file that matches a location template of a hierarchy entry
+type TemplateFile struct {
+	// Path is the path of the file
+	Path string
+
+	// Function is the name of the function that reads the file, i.e. yaml_data or json_data
+	Function string
+
+	// Entry is the name of the hierarchy entry
+	Entry string
+
+	// Vars are the values of the interpolation expressions of the path template that the file matches, keyed by
+	// expression. It is nil when the entry uses globs or mapped paths.
+	Vars map[string]string
+}
+
+// TemplateFiles returns the data files that match the location templates of the entries of the hierarchy and the
+// default hierarchy that use the yaml_data or json_data function, in hierarchy order. Only the files of the entry
+// with the given name are returned unless the name is empty. Each interpolation expression of a template matches
+// any file name.
+func TemplateFiles(ic hieraapi.Invocation, level string) []*TemplateFile {
+	cfg := ic.Config().Config().(*hieraCfg)
+	found := false
+	tfs := make([]*TemplateFile, 0)
+	for _, he := range cfg.allEntries() {
+		if level != `` && he.Name() != level {
+			continue
+		}
+		found = true
+		e := he.(*entry)
+		fn := functionOf(cfg, e)
+		if !isFileDataFunction(fn) {
+			if level != `` {
+				panic(px.Error(hieraapi.NotAFileDataEntry, issue.H{`name`: level}))
+			}
+			continue
+		}
+		for _, file := range templateMatches(cfg, e) {
+			tfs = append(tfs, &TemplateFile{Path: file, Function: fn.Name(), Entry: e.name, Vars: templateVars(cfg, e, file)})
+		}
+	}
+	if level != `` && !found {
+		panic(px.Error(hieraapi.UnknownHierarchyEntry, issue.H{`name`: level}))
+	}
+	return tfs
+}
+
+// TemplateFileFor returns the path of the data file of the hierarchy entry with the given name that is selected
+// by the given values of interpolation expressions, together with the name of the function that reads it. The
+// entry must use a path template and the values must cover all interpolation expressions of its first template.
+func TemplateFileFor(ic hieraapi.Invocation, level string, vars map[string]string) (file, fn string) {
+	cfg := ic.Config().Config().(*hieraCfg)
+	e := cfg.entryNamed(level)
+	if e == nil {
+		panic(px.Error(hieraapi.UnknownHierarchyEntry, issue.H{`name`: level}))
+	}
+	f := functionOf(cfg, e)
+	if !isFileDataFunction(f) {
+		panic(px.Error(hieraapi.NotAFileDataEntry, issue.H{`name`: level}))
+	}
+	t, ok := templateOf(cfg, level)
+	if !ok {
+		panic(px.Error(hieraapi.NoDataFile, issue.H{`name`: level, `reason`: `only files that match a path template can be created`}))
+	}
+	file = interpolationPattern.ReplaceAllStringFunc(filepath.Join(dataDirOf(cfg, e), t.original), func(match string) string {
+		v, ok := vars[expressionOf(match)]
+		if !ok {
+			panic(px.Error(hieraapi.NoDataFile, issue.H{`name`: level, `reason`: `interpolation '` + match + `' has no value`}))
+		}
+		return v
+	})
+	return file, f.Name()
+}
+
+// templateVars returns the values of the interpolation expressions of the first path template of the given entry
+// that matches the given file, or nil when no path template matches
+func templateVars(cfg *hieraCfg, e *entry, file string) map[string]string {
+	for _, l := range e.locations {
+		p, ok := l.(*path)
+		if !ok {
+			continue
+		}
+		template := filepath.Join(dataDirOf(cfg, e), p.original)
+		exprs := interpolationPattern.FindAllString(template, -1)
+		parts := interpolationPattern.Split(template, -1)
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		m := regexp.MustCompile(`\A` + strings.Join(parts, `([^/]*)`) + `\z`).FindStringSubmatch(file)
+		if m == nil {
+			continue
+		}
+		vars := make(map[string]string, len(exprs))
+		for i, expr := range exprs {
+			vars[expressionOf(expr)] = m[i+1]
+		}
+		return vars
+	}
+	return nil
+}
+
+// expressionOf returns the expression of the given interpolation, i.e. the text between '%{' and '}' without
+// surrounding whitespace
+func expressionOf(interpolation string) string {
+	return strings.TrimSpace(interpolation[2 : len(interpolation)-1])
+}
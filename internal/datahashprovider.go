@@ -13,8 +13,9 @@ import (
 
 type DataHashProvider struct {
 	hierarchyEntry hieraapi.Entry
-	providerFunc   hieraapi.DataHash
+	providerFunc   hieraapi.DataHashWithPositions
 	hashes         map[string]px.OrderedMap
+	positions      map[string]hieraapi.PositionsFunc
 	hashesLock     sync.RWMutex
 }
 
@@ -48,19 +49,22 @@ func (dh *DataHashProvider) invokeWithLocation(invocation hieraapi.Invocation, l
 }
 
 func (dh *DataHashProvider) lookupKey(invocation hieraapi.Invocation, location hieraapi.Location, root string) px.Value {
-	if value := dh.dataValue(invocation, location, root); value != nil {
+	if value, pos := dh.dataValue(invocation, location, root); value != nil {
 		invocation.ReportFound(root, value)
+		if pos != nil {
+			reportPositions(invocation, root, pos)
+		}
 		return value
 	}
 	invocation.ReportNotFound(root)
 	return nil
 }
 
-func (dh *DataHashProvider) dataValue(ic hieraapi.Invocation, location hieraapi.Location, root string) px.Value {
-	hash := dh.dataHash(ic, location)
+func (dh *DataHashProvider) dataValue(ic hieraapi.Invocation, location hieraapi.Location, root string) (px.Value, hieraapi.PositionsFunc) {
+	hash, positions := dh.dataHash(ic, location)
 	value, found := hash.Get4(root)
 	if !found {
-		return nil
+		return nil, nil
 	}
 
 	pfx := func() string {
@@ -68,31 +72,36 @@ func (dh *DataHashProvider) dataValue(ic hieraapi.Invocation, location hieraapi.
 		if location != nil {
 			msg = fmt.Sprintf(`%s, when using location '%s'`, msg, location)
 		}
+		if pos := positions.Get([]interface{}{root}); pos != nil {
+			msg = fmt.Sprintf(`%s, defined at line %d`, msg, pos.Line())
+		}
 		return msg
 	}
 
 	value = px.AssertInstance(pfx, types.DefaultRichDataType(), value)
-	return Interpolate(ic, value, true)
+	return withEntryInterpolationMode(ic, dh.hierarchyEntry, root, func() px.Value {
+		return Interpolate(ic, value, true)
+	}), positions
 }
 
-func (dh *DataHashProvider) providerFunction(ic hieraapi.Invocation) (pf hieraapi.DataHash) {
+func (dh *DataHashProvider) providerFunction(ic hieraapi.Invocation) (pf hieraapi.DataHashWithPositions) {
 	if dh.providerFunc == nil {
 		dh.providerFunc = dh.loadFunction(ic)
 	}
 	return dh.providerFunc
 }
 
-func (dh *DataHashProvider) loadFunction(ic hieraapi.Invocation) hieraapi.DataHash {
+func (dh *DataHashProvider) loadFunction(ic hieraapi.Invocation) hieraapi.DataHashWithPositions {
 	n := dh.hierarchyEntry.Function().Name()
 	switch n {
 	case `yaml_data`:
-		return provider.YamlData
+		return provider.YamlDataWithPositions
 	case `json_data`:
-		return provider.JSONData
+		return provider.JSONDataWithPositions
 	}
 
 	if fn, ok := loadPluginFunction(ic, n, dh.hierarchyEntry); ok {
-		return func(pc hieraapi.ServerContext) (value px.OrderedMap) {
+		return func(pc hieraapi.ServerContext) (value px.OrderedMap, _ hieraapi.PositionsFunc) {
 			value = px.EmptyMap
 			defer catchNotFound()
 			v := fn.Call(ic, nil, []px.Value{pc.(*serverCtx)}...)
@@ -104,12 +113,12 @@ func (dh *DataHashProvider) loadFunction(ic hieraapi.Invocation) hieraapi.DataHa
 	}
 
 	ic.ReportText(func() string { return fmt.Sprintf(`unresolved function '%s'`, n) })
-	return func(pc hieraapi.ServerContext) px.OrderedMap {
-		return px.EmptyMap
+	return func(pc hieraapi.ServerContext) (px.OrderedMap, hieraapi.PositionsFunc) {
+		return px.EmptyMap, nil
 	}
 }
 
-func (dh *DataHashProvider) dataHash(ic hieraapi.Invocation, location hieraapi.Location) (hash px.OrderedMap) {
+func (dh *DataHashProvider) dataHash(ic hieraapi.Invocation, location hieraapi.Location) (hash px.OrderedMap, positions hieraapi.PositionsFunc) {
 	key := ``
 	opts := dh.hierarchyEntry.OptionsMap()
 	if location != nil {
@@ -120,6 +129,7 @@ func (dh *DataHashProvider) dataHash(ic hieraapi.Invocation, location hieraapi.L
 	var ok bool
 	dh.hashesLock.RLock()
 	hash, ok = dh.hashes[key]
+	positions = dh.positions[key]
 	dh.hashesLock.RUnlock()
 	if ok {
 		return
@@ -129,20 +139,79 @@ func (dh *DataHashProvider) dataHash(ic hieraapi.Invocation, location hieraapi.L
 	defer dh.hashesLock.Unlock()
 
 	if hash, ok = dh.hashes[key]; ok {
-		return hash
+		return hash, dh.positions[key]
 	}
-	hash = dh.providerFunction(ic)(newServerContext(ic, &sync.Map{}, opts))
+	hash, positions = dh.readData(ic, opts)
 	dh.hashes[key] = hash
+	if positions != nil {
+		dh.positions[key] = positions
+	}
 	return
 }
 
+// sharedData is the data read from a file by a data provider, kept in the shared cache when the option
+// hieraapi.HieraShareData is set
+type sharedData struct {
+	hash      px.OrderedMap
+	positions hieraapi.PositionsFunc
+}
+
+// readData calls the provider function using the given options. The data read from files by the yaml_data and
+// json_data functions is shared between invocations when the option hieraapi.HieraShareData is set.
+func (dh *DataHashProvider) readData(ic hieraapi.Invocation, opts map[string]px.Value) (px.OrderedMap, hieraapi.PositionsFunc) {
+	read := func() (px.OrderedMap, hieraapi.PositionsFunc) {
+		return dh.providerFunction(ic)(newServerContext(ic, &sync.Map{}, opts))
+	}
+
+	n := dh.hierarchyEntry.Function().Name()
+	iv, ok := ic.(*invocation)
+	if !(ok && (n == `yaml_data` || n == `json_data`) && globalShareData(ic)) {
+		return read()
+	}
+	path, ok := opts[`path`]
+	if !ok {
+		return read()
+	}
+	ck := hieraDataPrefix + n + `:` + path.String()
+	if sd, ok := iv.sharedCache().Load(ck); ok {
+		return sd.(*sharedData).hash, sd.(*sharedData).positions
+	}
+	hash, positions := read()
+	iv.sharedCache().Store(ck, &sharedData{hash, positions})
+	return hash, positions
+}
+
+// ListKeys calls the given function with each key of the hashes that this provider produces for the
+// locations of its hierarchy entry
+func (dh *DataHashProvider) ListKeys(ic hieraapi.Invocation, f func(key string, source hieraapi.Source)) {
+	eachExistingLocation(dh.hierarchyEntry, func(location hieraapi.Location) {
+		hash, positions := dh.dataHash(ic, location)
+		src := sourceOf(dh, dh.hierarchyEntry, location)
+		hash.EachKey(func(k px.Value) {
+			s := src
+			if pos := positions.Get([]interface{}{k.String()}); pos != nil {
+				s.Line = pos.Line()
+			}
+			f(k.String(), s)
+		})
+	})
+}
+
+// HierarchyEntry returns the hierarchy entry that this provider was created from
+func (dh *DataHashProvider) HierarchyEntry() hieraapi.Entry {
+	return dh.hierarchyEntry
+}
+
 func (dh *DataHashProvider) FullName() string {
 	return fmt.Sprintf(`data_hash function '%s'`, dh.hierarchyEntry.Function().Name())
 }
 
 func newDataHashProvider(he hieraapi.Entry) hieraapi.DataProvider {
 	ls := he.Locations()
-	return &DataHashProvider{hierarchyEntry: he, hashes: make(map[string]px.OrderedMap, len(ls))}
+	return &DataHashProvider{
+		hierarchyEntry: he,
+		hashes:         make(map[string]px.OrderedMap, len(ls)),
+		positions:      make(map[string]hieraapi.PositionsFunc, len(ls))}
 }
 
 func optionsWithLocation(options map[string]px.Value, loc string) map[string]px.Value {
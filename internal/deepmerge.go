@@ -13,53 +13,115 @@ import (
 //
 // When both values are hashes, DeepMerge is called recursively entries with identical keys.
 // When both values are arrays, the merge creates a union of the unique elements from the two arrays.
-// No recursive merge takes place for the array elements.
+// No recursive merge takes place for the array elements unless the option "merge_hash_arrays_by"
+// names a key. Hash elements that have equal values for that key are then merged recursively.
 func DeepMerge(a, b px.Value, options map[string]px.Value) (px.Value, bool) {
+	v, _, mh := deepMergeWithSources(a, b, nil, nil, options)
+	return v, mh
+}
+
+// deepMergeWithSources merges the values 'a' and 'b' in the same way as DeepMerge and merges their sources 'sa'
+// and 'sb' along with them. The sources of the result are nil when both 'sa' and 'sb' are nil.
+func deepMergeWithSources(a, b px.Value, sa, sb *sources, options map[string]px.Value) (px.Value, *sources, bool) {
 	switch a := a.(type) {
 	case *types.Hash:
 		if hb, ok := b.(*types.Hash); ok {
 			es := make([]*types.HashEntry, 0, a.Len()+hb.Len())
+			ss := newSources(sa, sb)
 			mergeHappened := false
 			a.Each(func(ev px.Value) {
 				e := ev.(*types.HashEntry)
+				ck := childKey(e.Key())
 				if bv, ok := hb.Get(e.Key()); ok {
-					if m, mh := DeepMerge(e.Value(), bv, options); mh {
+					if m, ms, mh := deepMergeWithSources(e.Value(), bv, sa.child(ck), sb.child(ck), options); mh {
 						es = append(es, types.WrapHashEntry(e.Key(), m))
+						ss.setChild(ck, ms)
 						mergeHappened = true
 						return
 					}
 				}
 				es = append(es, e)
+				ss.setChild(ck, sa.child(ck))
 			})
 			hb.Each(func(ev px.Value) {
 				e := ev.(*types.HashEntry)
 				if !a.IncludesKey(e.Key()) {
 					mergeHappened = true
 					es = append(es, e)
+					ck := childKey(e.Key())
+					ss.setChild(ck, sb.child(ck))
 				}
 			})
 			if mergeHappened {
-				return types.WrapHash(es), true
+				return types.WrapHash(es), ss, true
 			}
 		}
 
 	case *types.Array:
 		if ab, ok := b.(*types.Array); ok && ab.Len() > 0 {
 			if a.Len() == 0 {
-				return ab, true
+				return ab, sb, true
 			}
 			es := a.AppendTo(make([]px.Value, 0, a.Len()+ab.Len()))
+			var ess []*sources
+			if sa != nil || sb != nil {
+				ess = make([]*sources, len(es), cap(es))
+				for i := range es {
+					ess[i] = sa.child(i)
+				}
+			}
 			mergeHappened := false
-			ab.Each(func(e px.Value) {
+			mergeBy := options[`merge_hash_arrays_by`]
+			ab.EachWithIndex(func(e px.Value, i int) {
+				if mergeBy != nil {
+					if ix := indexByKey(es, mergeBy, e); ix >= 0 {
+						var xs *sources
+						if ess != nil {
+							xs = ess[ix]
+						}
+						if m, ms, mh := deepMergeWithSources(es[ix], e, xs, sb.child(i), options); mh {
+							es[ix] = m
+							if ess != nil {
+								ess[ix] = ms
+							}
+							mergeHappened = true
+						}
+						return
+					}
+				}
 				if !a.Any(func(v px.Value) bool { return v.Equals(e, nil) }) {
 					es = append(es, e)
+					if ess != nil {
+						ess = append(ess, sb.child(i))
+					}
 					mergeHappened = true
 				}
 			})
 			if mergeHappened {
-				return types.WrapValues(es), true
+				return types.WrapValues(es), elementSources(ess), true
+			}
+		}
+	}
+	return a, sa, false
+}
+
+// indexByKey returns the index of the hash in es that has the same value for the given key as the
+// given element, or -1 if the element isn't a hash with that key or if no such hash is found.
+func indexByKey(es []px.Value, key, e px.Value) int {
+	eh, ok := e.(*types.Hash)
+	if !ok {
+		return -1
+	}
+	ev, ok := eh.Get(key)
+	if !ok {
+		return -1
+	}
+	for i, x := range es {
+		if xh, ok := x.(*types.Hash); ok {
+			if xv, ok := xh.Get(key); ok && xv.Equals(ev, nil) {
+				return i
 			}
 		}
 	}
-	return a, false
+	return -1
 }
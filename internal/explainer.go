@@ -658,7 +658,9 @@ func (en *explainLookup) AppendTo(w *utils.Indenter) {
 	w.Append(`Searching for "`)
 	w.Append(en.key())
 	w.AppendRune('"')
-	en.dumpBranches(w.Indent())
+	bw := w.Indent()
+	en.dumpTexts(bw)
+	en.dumpBranches(bw)
 }
 
 func (en *explainLookup) String() string {
@@ -17,6 +17,19 @@ func init() {
 		version => '5.0.0',
 		types => {
 			Options => Hash[Pattern[/\A[A-Za-z](:?[0-9A-Za-z_-]*[0-9A-Za-z])?\z/], Data],
+			PluginDigests => Array[Pattern[/\A[0-9A-Fa-f]{64}\z/], 1],
+			PluginPublicKeys => Array[String[1], 1],
+			PluginProcess => Struct[{
+				Optional[env] => Hash[String[1], String],
+				Optional[passthrough_env] => Variant[Boolean, Array[String[1]]],
+				Optional[workdir] => String[1],
+				Optional[user] => Variant[Integer[0], String[1]],
+				Optional[limits] => Struct[{
+					Optional[cpu_seconds] => Integer[1],
+					Optional[memory_bytes] => Integer[1],
+					Optional[open_files] => Integer[1]
+				}]
+			}],
 			Defaults => Struct[{
 				Optional[options] => Options,
 				Optional[data_dig] => String[1],
@@ -24,6 +37,10 @@ func init() {
 				Optional[lookup_key] => String[1],
 				Optional[datadir] => String[1],
 				Optional[plugindir] => String[1],
+				Optional[plugin_digests] => PluginDigests,
+				Optional[plugin_public_keys] => PluginPublicKeys,
+				Optional[plugin_process] => PluginProcess,
+				Optional[strict_interpolation] => Boolean,
 			}],
 			Entry => Struct[{
 				name => String[1],
@@ -34,6 +51,11 @@ func init() {
 				Optional[datadir] => String[1],
 				Optional[plugindir] => String[1],
 				Optional[pluginfile] => String[1],
+				Optional[plugin_address] => Pattern[/\A(?:unix|tcp):\/\/./],
+				Optional[plugin_digests] => PluginDigests,
+				Optional[plugin_public_keys] => PluginPublicKeys,
+				Optional[plugin_process] => PluginProcess,
+				Optional[strict_interpolation] => Boolean,
 				Optional[path] => String[1],
 				Optional[paths] => Array[String[1], 1],
 				Optional[glob] => String[1],
@@ -64,6 +86,15 @@ func Lookup(ic hieraapi.Invocation, name string, dflt px.Value, options map[stri
 	return Lookup2(ic, []string{name}, types.DefaultAnyType(), dflt, px.EmptyMap, px.EmptyMap, options, nil)
 }
 
+// TryLookup performs a lookup of the given name in the same way as Lookup but returns nil rather than raising an
+// error when no value is found
+func TryLookup(ic hieraapi.Invocation, name string, options map[string]px.Value) px.Value {
+	if options == nil {
+		options = NoOptions
+	}
+	return ic.(*invocation).lookup(newKey(name), options)
+}
+
 // Lookup2 performs a lookup using the given parameters.
 //
 // ic - The lookup invocation
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
@@ -64,36 +65,272 @@ func doInterpolate(ic hieraapi.Invocation, value px.Value, allowMethods bool) (p
 	return value, false
 }
 
-const scopeMethod = 1
-const aliasMethod = 2
-const lookupMethod = 3
-const literalMethod = 4
+// iplCall is a method call in an interpolation expression. Each argument is either a string or a nested call.
+type iplCall struct {
+	src  string
+	name string
+	args []interface{}
+}
+
+var methodStart = regexp.MustCompile(`^\w+\(`)
+
+// parseMethodCall parses the given expression into a method call. The returned call is nil when the expression
+// isn't a method call, in which case the expression is the name of a variable in scope. An expression that doesn't
+// end with ')', such as "foo(", is the name of a variable just like before method calls could be nested.
+func parseMethodCall(expr string) *iplCall {
+	if !(methodStart.MatchString(expr) && strings.HasSuffix(expr, `)`)) {
+		return nil
+	}
+	p := &iplParser{expr: expr}
+	c := p.parseCall()
+	p.skipWhitespace()
+	if p.pos < len(expr) {
+		p.fail(`unexpected '` + expr[p.pos:] + `'`)
+	}
+	return c
+}
+
+type iplParser struct {
+	expr string
+	pos  int
+
+	// keys are the start and end positions of the quoted keys of the parsed alias, hiera, and lookup calls
+	keys [][2]int
+}
+
+// lookupMethods are the methods that take the key to look up as their argument
+var lookupMethods = map[string]bool{`alias`: true, `hiera`: true, `lookup`: true}
+
+func (p *iplParser) fail(detail string) {
+	panic(px.Error(hieraapi.InterpolationSyntaxError, issue.H{`expression`: p.expr, `detail`: detail}))
+}
+
+func (p *iplParser) skipWhitespace() {
+	for p.pos < len(p.expr) && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *iplParser) parseCall() *iplCall {
+	start := p.pos
+	m := methodStart.FindString(p.expr[p.pos:])
+	if m == `` {
+		p.fail(`expected a method name`)
+	}
+	p.pos += len(m)
+	c := &iplCall{name: m[:len(m)-1]}
+	p.skipWhitespace()
+	if p.pos < len(p.expr) && p.expr[p.pos] == ')' {
+		p.pos++
+		c.src = p.expr[start:p.pos]
+		return c
+	}
+	for {
+		p.skipWhitespace()
+		argStart := p.pos
+		a := p.parseArg()
+		if _, ok := a.(string); ok && len(c.args) == 0 && lookupMethods[c.name] {
+			p.keys = append(p.keys, [2]int{argStart + 1, p.pos - 1})
+		}
+		c.args = append(c.args, a)
+		p.skipWhitespace()
+		if p.pos >= len(p.expr) {
+			p.fail(`missing ')'`)
+		}
+		switch p.expr[p.pos] {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			c.src = p.expr[start:p.pos]
+			return c
+		default:
+			p.fail(`expected ',' or ')'`)
+		}
+	}
+}
+
+func (p *iplParser) parseArg() interface{} {
+	if p.pos >= len(p.expr) {
+		p.fail(`missing argument`)
+	}
+	q := p.expr[p.pos]
+	if q == '"' || q == '\'' {
+		end := strings.IndexByte(p.expr[p.pos+1:], q)
+		if end < 0 {
+			p.fail(`unterminated quote`)
+		}
+		arg := p.expr[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return arg
+	}
+	return p.parseCall()
+}
 
-var methodMatch = regexp.MustCompile(`^(\w+)\((?:["]([^"]+)["]|[']([^']+)['])\)$`)
+// splitDefault splits an expression such as "var | 'default'" into the expression and the parsed default. The
+// default is nil when the expression has no default.
+func splitDefault(expr string) (string, interface{}) {
+	i := defaultIndex(expr)
+	if i < 0 {
+		return expr, nil
+	}
+	p := &iplParser{expr: strings.TrimSpace(expr[i+1:])}
+	dflt := p.parseArg()
+	p.skipWhitespace()
+	if p.pos < len(p.expr) {
+		p.fail(`unexpected '` + p.expr[p.pos:] + `'`)
+	}
+	return strings.TrimSpace(expr[:i]), dflt
+}
 
-func getMethodAndData(expr string, allowMethods bool) (int, string) {
-	if groups := methodMatch.FindStringSubmatch(expr); groups != nil {
+// defaultIndex returns the position of the '|' that precedes the default of the given expression or -1 when the
+// expression has no default
+func defaultIndex(expr string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '|' && depth == 0:
+			return i
+		}
+	}
+	return -1
+}
+
+// RenameLookupKeys returns the given string with each key that an alias, hiera, or lookup call in an interpolation
+// expression looks up replaced with the result of calling rename with that key. Calls that are nested in other
+// calls or that provide the default of an expression are included. Only the keys are replaced so the rest of the
+// text is kept as is. Expressions that can't be parsed are left as they are and the messages of their syntax errors
+// are returned.
+func RenameLookupKeys(str string, rename func(key string) string) (string, []string) {
+	var errs []string
+	str = iplPattern.ReplaceAllStringFunc(str, func(match string) string {
+		var keys [][2]int
+		if msg := catch(func() { keys = lookupKeys(match[2 : len(match)-1]) }); msg != `` {
+			errs = append(errs, msg)
+			return match
+		}
+		for i := len(keys) - 1; i >= 0; i-- {
+			start, end := keys[i][0]+2, keys[i][1]+2
+			match = match[:start] + rename(match[start:end]) + match[end:]
+		}
+		return match
+	})
+	return str, errs
+}
+
+// lookupKeys parses the given interpolation expression in the same way as interpolateString and returns the
+// positions of the keys of its alias, hiera, and lookup calls
+func lookupKeys(expr string) [][2]int {
+	end := len(expr)
+	if i := defaultIndex(expr); i >= 0 {
+		end = i
+	}
+	p := &iplParser{expr: expr[:end]}
+	if parseMethodCall(strings.TrimSpace(p.expr)) != nil {
+		p.skipWhitespace()
+		p.parseCall()
+		p.skipWhitespace()
+	}
+	if end < len(expr) {
+		p.expr = expr
+		p.pos = end + 1
+		p.skipWhitespace()
+		p.parseArg()
+		p.skipWhitespace()
+		if p.pos < len(p.expr) {
+			p.fail(`unexpected '` + p.expr[p.pos:] + `'`)
+		}
+	}
+	return p.keys
+}
+
+// evalArg evaluates an argument or a default which is either a string or a method call. The result is nil when
+// a method call cannot be resolved.
+func evalArg(ic hieraapi.Invocation, a interface{}, allowMethods bool) px.Value {
+	if c, ok := a.(*iplCall); ok {
+		if c.name == `alias` {
+			panic(px.Error(hieraapi.InterpolationAliasNotEntireString, issue.NoArgs))
+		}
+		return c.call(ic, allowMethods)
+	}
+	return types.WrapString(a.(string))
+}
+
+// unresolved is called when the given expression cannot be resolved. It raises an error when strict interpolation
+// is in effect.
+func unresolved(ic hieraapi.Invocation, expr, str string) {
+	if iv, ok := ic.(*invocation); ok && iv.strict {
+		subject := iv.subject
+		if subject == `` {
+			subject = fmt.Sprintf(`'%s'`, str)
+		}
+		panic(px.Error(hieraapi.UnresolvedInterpolation, issue.H{`expression`: expr, `subject`: subject}))
+	}
+}
+
+// call evaluates the method call. The result is nil when the call cannot be resolved, e.g. when a variable in scope
+// or a looked up key is not found, or when one of its arguments cannot be resolved. A call other than alias that
+// results in undef is also unresolved so that the default of the expression applies to it.
+func (c *iplCall) call(ic hieraapi.Invocation, allowMethods bool) px.Value {
+	args := make([]px.Value, len(c.args))
+	for i, a := range c.args {
+		if args[i] = evalArg(ic, a, allowMethods); args[i] == nil {
+			unresolved(ic, c.args[i].(*iplCall).src, c.src)
+			return nil
+		}
+	}
+	v := c.invoke(ic, args, allowMethods)
+	if v == px.Undef && c.name != `alias` {
+		return nil
+	}
+	return v
+}
+
+// invoke calls the method with the given evaluated arguments
+func (c *iplCall) invoke(ic hieraapi.Invocation, args []px.Value, allowMethods bool) px.Value {
+
+	switch c.name {
+	case `alias`, `hiera`, `literal`, `lookup`, `scope`:
 		if !allowMethods {
 			panic(px.Error(hieraapi.InterpolationMethodSyntaxNotAllowed, issue.NoArgs))
 		}
-		data := groups[2]
-		if data == `` {
-			data = groups[3]
+		if len(args) != 1 {
+			panic(px.Error(hieraapi.WrongInterpolationArgCount, issue.H{`name`: c.name, `expected`: 1, `actual`: len(args)}))
 		}
-		switch groups[1] {
-		case `alias`:
-			return aliasMethod, data
-		case `hiera`, `lookup`:
-			return lookupMethod, data
+		switch c.name {
 		case `literal`:
-			return literalMethod, data
+			return args[0]
 		case `scope`:
-			return scopeMethod, data
+			return resolveInScope(ic, args[0].String(), allowMethods)
 		default:
-			panic(px.Error(hieraapi.UnknownInterpolationMethod, issue.H{`name`: groups[1]}))
+			return ic.(*invocation).lookup(newKey(args[0].String()), NoOptions)
 		}
 	}
-	return scopeMethod, expr
+
+	f, contexts, ok := hieraapi.GetInterpolationFunction(c.name)
+	if !ok {
+		panic(px.Error(hieraapi.UnknownInterpolationMethod, issue.H{`name`: c.name}))
+	}
+	context := hieraapi.InterpolationInConfig
+	if allowMethods {
+		context = hieraapi.InterpolationInData
+	}
+	if contexts&context == 0 {
+		panic(px.Error(hieraapi.InterpolationMethodSyntaxNotAllowed, issue.NoArgs))
+	}
+	return f(ic, args)
 }
 
 func interpolateString(ic hieraapi.Invocation, str string, allowMethods bool) (px.Value, bool) {
@@ -108,34 +345,32 @@ func interpolateString(ic hieraapi.Invocation, str string, allowMethods bool) (p
 			if emptyInterpolations[expr] {
 				return ``
 			}
-			var methodKey int
-			methodKey, expr = getMethodAndData(expr, allowMethods)
-			if methodKey == aliasMethod && match != str {
-				panic(px.Error(hieraapi.InterpolationAliasNotEntireString, issue.NoArgs))
+			expr, dflt := splitDefault(expr)
+			var val px.Value
+			c := parseMethodCall(expr)
+			if c != nil {
+				if c.name == `alias` && match != str {
+					panic(px.Error(hieraapi.InterpolationAliasNotEntireString, issue.NoArgs))
+				}
+				val = c.call(ic, allowMethods)
+			} else {
+				val = resolveInScope(ic, expr, allowMethods)
 			}
-
-			switch methodKey {
-			case literalMethod:
-				return expr
-			case scopeMethod:
-				key := newKey(expr)
-				if val, ok := ic.Scope().Get(types.WrapString(key.Root())); ok {
-					val, _ = doInterpolate(ic, val, allowMethods)
-					val = key.Dig(ic, val)
-					if val == nil {
-						return ``
-					}
-					return val.String()
+			if val == nil && dflt != nil {
+				val = evalArg(ic, dflt, allowMethods)
+			}
+			if val == nil {
+				unresolved(ic, expr, str)
+				if c != nil && c.name == `alias` {
+					result = px.Undef
 				}
 				return ``
-			default:
-				val := Lookup(ic, expr, px.Undef, nil)
-				if methodKey == aliasMethod {
-					result = val
-					return ``
-				}
-				return val.String()
 			}
+			if c != nil && c.name == `alias` {
+				result = val
+				return ``
+			}
+			return val.String()
 		})
 		if result == nil {
 			result = types.WrapString(str)
@@ -0,0 +1,41 @@
+package internal_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lyraproj/hiera/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenameLookupKeys(t *testing.T) {
+	rename := func(k string) string {
+		if k == `a` || strings.HasPrefix(k, `a.`) {
+			return `b` + k[1:]
+		}
+		return k
+	}
+	for _, tc := range []struct {
+		value    string
+		expected string
+	}{
+		{`%{lookup('a')}`, `%{lookup('b')}`},
+		{`%{ hiera("a.x") } and %{alias('ab')}`, `%{ hiera("b.x") } and %{alias('ab')}`},
+		{`%{lookup('a') | 'x'}`, `%{lookup('b') | 'x'}`},
+		{`%{lookup('c') | lookup('a.0')}`, `%{lookup('c') | lookup('b.0')}`},
+		{`%{join(lookup('a'), ', ')}`, `%{join(lookup('b'), ', ')}`},
+		{`%{lookup(lookup('a'))}`, `%{lookup(lookup('b'))}`},
+		{`%{facts.a | lookup('a')}`, `%{facts.a | lookup('b')}`},
+		{`%{scope('a')} %{literal('a')}`, `%{scope('a')} %{literal('a')}`},
+	} {
+		actual, errs := internal.RenameLookupKeys(tc.value, rename)
+		require.Empty(t, errs)
+		require.Equal(t, tc.expected, actual)
+	}
+}
+
+func TestRenameLookupKeys_syntaxError(t *testing.T) {
+	actual, errs := internal.RenameLookupKeys(`%{lookup('a' 'x')} %{lookup('a')}`, func(string) string { return `b` })
+	require.Equal(t, `%{lookup('a' 'x')} %{lookup('b')}`, actual)
+	require.Equal(t, []string{`Syntax error in interpolation expression 'lookup('a' 'x')': expected ',' or ')'`}, errs)
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+)
+
+func init() {
+	hieraapi.RegisterInterpolationFunction(`env`, hieraapi.InterpolationInData|hieraapi.InterpolationInConfig, iplEnv)
+	hieraapi.RegisterInterpolationFunction(`base64_decode`, hieraapi.InterpolationInData, iplBase64Decode)
+	hieraapi.RegisterInterpolationFunction(`base64_encode`, hieraapi.InterpolationInData, iplBase64Encode)
+	hieraapi.RegisterInterpolationFunction(`file`, hieraapi.InterpolationInData, iplFile)
+	hieraapi.RegisterInterpolationFunction(`join`, hieraapi.InterpolationInData, iplJoin)
+}
+
+func assertArgCount(name string, args []px.Value, min, max int) {
+	if n := len(args); n < min || n > max {
+		var expected string
+		switch {
+		case min != max:
+			expected = fmt.Sprintf(`%d to %d arguments`, min, max)
+		case min == 1:
+			expected = `1 argument`
+		default:
+			expected = fmt.Sprintf(`%d arguments`, min)
+		}
+		panic(px.Error(hieraapi.WrongInterpolationArgCount, issue.H{`name`: name, `expected`: expected, `actual`: n}))
+	}
+}
+
+// iplEnv returns the value of an environment variable, or the optional default value when the variable isn't set.
+// The result is nil when the variable isn't set and there is no default. The function must be enabled using the
+// option HieraEnvInterpolation.
+func iplEnv(ic hieraapi.Invocation, args []px.Value) px.Value {
+	assertArgCount(`env`, args, 1, 2)
+	if !globalEnvInterpolation(ic) {
+		panic(px.Error(hieraapi.InterpolationFunctionDisabled, issue.H{`name`: `env`, `option`: hieraapi.HieraEnvInterpolation}))
+	}
+	if v, ok := os.LookupEnv(args[0].String()); ok {
+		return types.WrapString(v)
+	}
+	if len(args) == 2 {
+		return args[1]
+	}
+	return nil
+}
+
+// iplBase64Decode decodes a base64 encoded string
+func iplBase64Decode(_ hieraapi.Invocation, args []px.Value) px.Value {
+	assertArgCount(`base64_decode`, args, 1, 1)
+	bs, err := base64.StdEncoding.DecodeString(args[0].String())
+	if err != nil {
+		panic(err)
+	}
+	return types.WrapString(string(bs))
+}
+
+// iplBase64Encode encodes a string using base64
+func iplBase64Encode(_ hieraapi.Invocation, args []px.Value) px.Value {
+	assertArgCount(`base64_encode`, args, 1, 1)
+	return types.WrapString(base64.StdEncoding.EncodeToString([]byte(args[0].String())))
+}
+
+// iplFile returns the contents of a file. A relative path is relative to the directory of the hiera configuration.
+// The file must be in one of the directories given by the option HieraFileInterpolationDirs.
+func iplFile(ic hieraapi.Invocation, args []px.Value) px.Value {
+	assertArgCount(`file`, args, 1, 1)
+	dirs := fileInterpolationDirs(ic)
+	if dirs == nil {
+		panic(px.Error(hieraapi.InterpolationFunctionDisabled, issue.H{`name`: `file`, `option`: hieraapi.HieraFileInterpolationDirs}))
+	}
+	base := filepath.Dir(globalOptions(ic)[hieraapi.HieraConfig].String())
+	path := args[0].String()
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+
+	// Symbolic links are resolved so that a link in an allowed directory cannot lead elsewhere. The paths are made
+	// absolute first since the configuration may be given relative to the current directory.
+	path, err := filepath.Abs(path)
+	if err != nil {
+		panic(err)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		panic(err)
+	}
+	if !inAnyDir(base, dirs, resolved) {
+		panic(px.Error(hieraapi.InterpolationFileNotAllowed, issue.H{`path`: args[0].String()}))
+	}
+	bs, err := ioutil.ReadFile(resolved)
+	if err != nil {
+		panic(err)
+	}
+	return types.WrapString(string(bs))
+}
+
+// fileInterpolationDirs returns the directories given by the option HieraFileInterpolationDirs or nil when the
+// option isn't set
+func fileInterpolationDirs(ic hieraapi.Invocation) []string {
+	switch v := globalOptions(ic)[hieraapi.HieraFileInterpolationDirs].(type) {
+	case px.StringValue:
+		return []string{v.String()}
+	case *types.Array:
+		dirs := make([]string, 0, v.Len())
+		v.Each(func(e px.Value) { dirs = append(dirs, e.String()) })
+		return dirs
+	}
+	return nil
+}
+
+// inAnyDir returns true if the given path is in one of the given directories or in a subdirectory of one of them.
+// A relative directory is relative to base.
+func inAnyDir(base string, dirs []string, path string) bool {
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(base, dir)
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(resolved, path)
+		if err == nil && rel != `..` && !strings.HasPrefix(rel, `..`+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// iplJoin joins the elements of an array using an optional separator
+func iplJoin(_ hieraapi.Invocation, args []px.Value) px.Value {
+	assertArgCount(`join`, args, 1, 2)
+	sep := ``
+	if len(args) == 2 {
+		sep = args[1].String()
+	}
+	a, ok := args[0].(*types.Array)
+	if !ok {
+		return types.WrapString(args[0].String())
+	}
+	ss := make([]string, a.Len())
+	a.EachWithIndex(func(e px.Value, i int) {
+		ss[i] = e.String()
+	})
+	return types.WrapString(strings.Join(ss, sep))
+}
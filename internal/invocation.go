@@ -1,8 +1,10 @@
 package internal
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/lyraproj/hiera/explain"
@@ -24,6 +26,7 @@ const hieraTopProviderCacheKey = `Hiera::TopProvider::Cache`
 
 const hieraConfigsPrefix = `HieraConfig:`
 const hieraLockPrefix = `HieraLock:`
+const hieraDataPrefix = `HieraData:`
 
 const hieraPluginRegistry = `Hiera::Plugins`
 
@@ -35,6 +38,16 @@ type invocation struct {
 	redacted   bool
 	explainer  explain.Explainer
 	config     hieraapi.ResolvedConfig
+	provenance *provenance
+
+	// strict is true when interpolation expressions that cannot be resolved are errors. The subject
+	// describes what is being interpolated.
+	strict  bool
+	subject string
+
+	// strictEntry is set to true when a hierarchy entry with strict interpolation provides data for the current
+	// lookup. The data is then interpolated strictly once the lookup is done.
+	strictEntry *bool
 }
 
 // KillPlugins will ensure that all plugins started by this executable are gracefully terminated if possible or
@@ -126,6 +139,8 @@ func NewInvocation(c px.Context, scope px.Keyed, explainer explain.Explainer) hi
 		configPath: options[hieraapi.HieraConfig].String(),
 		explainer:  explainer}
 
+	ic.strict = globalStrictInterpolation(c)
+
 	return ic
 }
 
@@ -159,6 +174,29 @@ func globalOptions(c px.Context) map[string]px.Value {
 	panic(px.Error(hieraapi.NotInitialized, issue.NoArgs))
 }
 
+// globalStrictInterpolation returns the value of the global option HieraStrictInterpolation
+func globalStrictInterpolation(c px.Context) bool {
+	if sv, ok := globalOptions(c)[hieraapi.HieraStrictInterpolation].(px.Boolean); ok {
+		return sv.Bool()
+	}
+	return false
+}
+
+// globalEnvInterpolation returns the value of the global option HieraEnvInterpolation
+func globalEnvInterpolation(c px.Context) bool {
+	if sv, ok := globalOptions(c)[hieraapi.HieraEnvInterpolation].(px.Boolean); ok {
+		return sv.Bool()
+	}
+	return false
+}
+
+func globalShareData(c px.Context) bool {
+	if sv, ok := globalOptions(c)[hieraapi.HieraShareData].(px.Boolean); ok {
+		return sv.Bool()
+	}
+	return false
+}
+
 func (ic *invocation) sharedCache() *sync.Map {
 	if v, ok := ic.Get(hieraCacheKey); ok {
 		var sh *sync.Map
@@ -236,15 +274,75 @@ func (ic *invocation) lookup(key hieraapi.Key, options map[string]px.Value) px.V
 		}
 		options = no
 	}
-	v := ic.topProvider()(newServerContext(ic, ic.topProviderCache(), options), rootKey)
+	strictEntry := false
+	savedStrictEntry := ic.strictEntry
+	ic.strictEntry = &strictEntry
+	defer func() {
+		ic.strictEntry = savedStrictEntry
+	}()
+
+	var v px.Value
+	if ic.provenance != nil {
+		v = ic.provenance.startLookup(key, func() px.Value {
+			return ic.topProvider()(newServerContext(ic, ic.topProviderCache(), options), quotedRoot(rootKey))
+		})
+	} else {
+		v = ic.topProvider()(newServerContext(ic, ic.topProviderCache(), options), quotedRoot(rootKey))
+	}
 	if v != nil {
-		dc := ic.ForData()
-		v = Interpolate(dc, v, true)
+		dc := ic.ForData().(*invocation)
+		v = dc.withInterpolationMode(globalStrictInterpolation(dc) || strictEntry, fmt.Sprintf(`key '%s'`, key.Source()), func() px.Value {
+			return Interpolate(dc, v, true)
+		})
 		v = key.Dig(dc, v)
 	}
 	return v
 }
 
+// quotedRoot returns the given root key in a form that is parsed back into that root. The top provider receives the
+// root as a string that it parses again, so a root that contains dots, brackets, or quotes must be quoted to not be
+// taken for a dotted or indexed key.
+func quotedRoot(root string) string {
+	switch {
+	case !strings.ContainsAny(root, `.[]'"`):
+		return root
+	case !strings.Contains(root, `"`):
+		return `"` + root + `"`
+	case !strings.Contains(root, `'`):
+		return `'` + root + `'`
+	}
+	return root
+}
+
+// withInterpolationMode calls the given actor with strict interpolation turned on or off. The subject describes
+// what is being interpolated, e.g. "key 'x'", and is used in the error that is raised in strict mode when an
+// expression cannot be resolved.
+func (ic *invocation) withInterpolationMode(strict bool, subject string, actor px.Producer) px.Value {
+	savedStrict, savedSubject := ic.strict, ic.subject
+	ic.strict, ic.subject = strict, subject
+	defer func() {
+		ic.strict, ic.subject = savedStrict, savedSubject
+	}()
+	return actor()
+}
+
+// requireEntryInterpolationMode makes the interpolation of the data of the current lookup strict when the given
+// hierarchy entry, which provides data that is interpolated when the lookup is done, has strict interpolation
+func requireEntryInterpolationMode(ic hieraapi.Invocation, he hieraapi.Entry) {
+	if iv, ok := ic.(*invocation); ok && iv.strictEntry != nil && he.StrictInterpolation() {
+		*iv.strictEntry = true
+	}
+}
+
+// withEntryInterpolationMode calls the given actor with strict interpolation turned on or off in accordance with
+// the given hierarchy entry. It is used when the data of the entry for the given key is interpolated.
+func withEntryInterpolationMode(ic hieraapi.Invocation, he hieraapi.Entry, key string, actor px.Producer) px.Value {
+	if iv, ok := ic.(*invocation); ok {
+		return iv.withInterpolationMode(he.StrictInterpolation(), fmt.Sprintf(`key '%s'`, key), actor)
+	}
+	return actor()
+}
+
 func (ic *invocation) WithKey(key hieraapi.Key, actor px.Producer) px.Value {
 	if utils.ContainsString(ic.nameStack, key.Source()) {
 		panic(px.Error(hieraapi.EndlessRecursion, issue.H{`name_stack`: ic.nameStack}))
@@ -280,6 +378,9 @@ func (ic *invocation) Scope() px.Keyed {
 }
 
 func (ic *invocation) WithDataProvider(p hieraapi.DataProvider, actor px.Producer) px.Value {
+	if ic.provenance != nil {
+		defer ic.provenance.withProvider(p)()
+	}
 	if ic.explainer == nil {
 		return actor()
 	}
@@ -307,6 +408,9 @@ func (ic *invocation) WithInvalidKey(key interface{}, actor px.Producer) px.Valu
 }
 
 func (ic *invocation) WithLocation(loc hieraapi.Location, actor px.Producer) px.Value {
+	if ic.provenance != nil {
+		defer ic.provenance.withLocation(loc)()
+	}
 	if ic.explainer == nil {
 		return actor()
 	}
@@ -385,11 +489,33 @@ func (ic *invocation) ReportLocationNotFound() {
 }
 
 func (ic *invocation) ReportFound(key interface{}, value px.Value) {
+	if ic.provenance != nil {
+		ic.provenance.reportFound(key, value)
+	}
 	if ic.explainer != nil {
 		ic.explainer.AcceptFound(key, value)
 	}
 }
 
+// reportPositions reports the positions of the value that was found for the given root key by a data provider
+// that keeps track of where its values are defined. The positions are parsed lazily, i.e. only when an explanation
+// or the sources of the value need them.
+func reportPositions(ic hieraapi.Invocation, root string, positions hieraapi.PositionsFunc) {
+	iv, ok := ic.(*invocation)
+	if !ok {
+		return
+	}
+	if iv.provenance != nil {
+		iv.provenance.reportPositions(root, positions)
+	}
+	if iv.explainer == nil {
+		return
+	}
+	if pos := positions.Get([]interface{}{root}); pos != nil {
+		iv.ReportText(func() string { return fmt.Sprintf(`Defined at %s:%d`, pos.File(), pos.Line()) })
+	}
+}
+
 func (ic *invocation) ReportMergeResult(value px.Value) {
 	if ic.explainer != nil {
 		ic.explainer.AcceptMergeResult(value)
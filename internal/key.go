@@ -2,8 +2,11 @@ package internal
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"strconv"
+	"strings"
 
 	"github.com/lyraproj/pcore/utils"
 
@@ -18,6 +21,66 @@ type key struct {
 	parts  []interface{}
 }
 
+// keyPattern is a key segment that can match several entries of a hash or elements of an array
+type keyPattern interface {
+	fmt.Stringer
+
+	matches(v px.Value) bool
+}
+
+// keyWildcard is the segment "*" which matches all entries of a hash or elements of an array
+type keyWildcard struct{}
+
+func (keyWildcard) matches(_ px.Value) bool {
+	return true
+}
+
+func (keyWildcard) String() string {
+	return `*`
+}
+
+// MarshalJSON encodes the wildcard as {"pattern":"*"} so that it cannot be confused with a segment that is the
+// string "*"
+func (w keyWildcard) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{`pattern`: w.String()})
+}
+
+// keyFilter is a segment such as "[?role=admin]" which matches the hashes that have an entry with the given field.
+// The value of that entry must be equal to, or for the operator "!=" not equal to, the given value. Only the
+// existence of the field is tested when the filter has no operator.
+type keyFilter struct {
+	src   string
+	field string
+	op    string
+	value string
+}
+
+func (f *keyFilter) matches(v px.Value) bool {
+	h, ok := v.(*types.Hash)
+	if !ok {
+		return false
+	}
+	fv, ok := h.Get4(f.field)
+	switch f.op {
+	case `=`:
+		return ok && fv.String() == f.value
+	case `!=`:
+		return !ok || fv.String() != f.value
+	default:
+		return ok
+	}
+}
+
+func (f *keyFilter) String() string {
+	return f.src
+}
+
+// MarshalJSON encodes the filter as {"pattern":"[?role=admin]"} so that it cannot be confused with a segment that
+// is a string
+func (f *keyFilter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{`pattern`: f.src})
+}
+
 func init() {
 	keyMetaType = px.NewObjectType(`Hiera::Key`, `{
 		attributes => {
@@ -26,16 +89,8 @@ func init() {
     }
 	}`,
 		func(c px.Context, args []px.Value) px.Value {
-			parts := args[1].(px.List)
-			is := make([]interface{}, parts.Len())
-			parts.EachWithIndex(func(v px.Value, i int) {
-				if s, ok := v.(px.StringValue); ok {
-					is[i] = s.String()
-				} else {
-					is[i] = v.(px.Integer).Int()
-				}
-			})
-			return &key{args[0].String(), is}
+			// Wildcards and filters are represented as strings in the parts so the source is parsed again
+			return newKey(args[0].String())
 		})
 }
 
@@ -53,7 +108,7 @@ func (k *key) Bury(value px.Value) px.Value {
 		if ix, ok := p.(int); ok {
 			kx = types.WrapInteger(int64(ix))
 		} else {
-			kx = types.WrapString(p.(string))
+			kx = types.WrapString(fmt.Sprint(p))
 		}
 		value = types.WrapHash([]*types.HashEntry{types.WrapHashEntry(kx, value)})
 	}
@@ -61,45 +116,116 @@ func (k *key) Bury(value px.Value) px.Value {
 }
 
 func (k *key) Dig(ic hieraapi.Invocation, v px.Value) px.Value {
-	t := len(k.parts)
-	if t == 1 {
+	if len(k.parts) == 1 {
 		return v
 	}
-
 	return ic.WithSubLookup(k, func() px.Value {
-		for i := 1; i < t; i++ {
-			p := k.parts[i]
-			v = ic.WithSegment(p, func() px.Value {
-				switch vc := v.(type) {
-				case *types.Array:
-					if ix, ok := p.(int); ok {
-						if ix >= 0 && ix < vc.Len() {
-							v = vc.At(ix)
-							ic.ReportFound(p, v)
-							return v
-						}
-					}
-				case *types.Hash:
-					var kx px.Value
-					if ix, ok := p.(int); ok {
-						kx = types.WrapInteger(int64(ix))
-					} else {
-						kx = types.WrapString(p.(string))
-					}
-					if v, ok := vc.Get(kx); ok {
-						ic.ReportFound(p, v)
-						return v
+		return digParts(ic, v, k.parts[1:])
+	})
+}
+
+// digParts digs into the given value using the given key parts and returns nil when the value isn't found. When
+// the parts contain a wildcard or a filter, the result is an array of all values that matched. The invocation
+// is used for reporting each segment to the explainer and may be nil.
+func digParts(ic hieraapi.Invocation, v px.Value, parts []interface{}) px.Value {
+	multi := false
+	vs := []px.Value{v}
+	for _, p := range parts {
+		var next []px.Value
+		if _, ok := p.(keyPattern); ok {
+			multi = true
+			for _, v := range vs {
+				eachMatch(v, p, func(seg interface{}, mv px.Value) {
+					if ic != nil {
+						ic.WithSegment(seg, func() px.Value {
+							ic.ReportFound(seg, mv)
+							return nil
+						})
 					}
+					next = append(next, mv)
+				})
+			}
+			if len(next) == 0 && ic != nil {
+				seg := fmt.Sprint(p)
+				ic.WithSegment(seg, func() px.Value {
+					ic.ReportNotFound(seg)
+					return nil
+				})
+			}
+		} else {
+			report := func() px.Value {
+				for _, v := range vs {
+					eachMatch(v, p, func(_ interface{}, mv px.Value) {
+						if ic != nil {
+							ic.ReportFound(p, mv)
+						}
+						next = append(next, mv)
+					})
+				}
+				if len(next) == 0 && ic != nil {
+					ic.ReportNotFound(p)
 				}
-				ic.ReportNotFound(p)
 				return nil
+			}
+			if ic == nil {
+				report()
+			} else {
+				ic.WithSegment(p, report)
+			}
+		}
+		vs = next
+		if len(vs) == 0 {
+			return nil
+		}
+	}
+	if multi {
+		return types.WrapValues(vs)
+	}
+	return vs[0]
+}
+
+// eachMatch calls the given function with each segment and value in the given value that matches the given
+// key part. A negative index counts from the end of an array.
+func eachMatch(v px.Value, p interface{}, f func(seg interface{}, mv px.Value)) {
+	switch vc := v.(type) {
+	case *types.Array:
+		switch p := p.(type) {
+		case int:
+			if p < 0 {
+				p += vc.Len()
+			}
+			if p >= 0 && p < vc.Len() {
+				f(p, vc.At(p))
+			}
+		case keyPattern:
+			vc.EachWithIndex(func(e px.Value, i int) {
+				if p.matches(e) {
+					f(i, e)
+				}
 			})
-			if v == nil {
-				break
+		}
+	case *types.Hash:
+		switch p := p.(type) {
+		case int:
+			if e, ok := vc.Get(types.WrapInteger(int64(p))); ok {
+				f(p, e)
 			}
+		case string:
+			if e, ok := vc.Get(types.WrapString(p)); ok {
+				f(p, e)
+			}
+		case keyPattern:
+			vc.EachPair(func(k, e px.Value) {
+				if p.matches(e) {
+					if i, ok := k.(px.Integer); ok {
+						f(int(i.Int()), e)
+					} else {
+						f(k.String(), e)
+					}
+				}
+			})
 		}
-		return v
-	})
+	}
 }
 
 func (k *key) Equals(value interface{}, guard px.Guard) bool {
@@ -114,7 +240,15 @@ func (k *key) Get(key string) (value px.Value, ok bool) {
 	case `source`:
 		return types.WrapString(k.source), true
 	case `parts`:
-		return px.Wrap(nil, k.parts), true
+		ps := make([]px.Value, len(k.parts))
+		for i, p := range k.parts {
+			if ix, ok := p.(int); ok {
+				ps[i] = types.WrapInteger(int64(ix))
+			} else {
+				ps[i] = types.WrapString(fmt.Sprint(p))
+			}
+		}
+		return types.WrapValues(ps), true
 	}
 	return nil, false
 }
@@ -161,6 +295,9 @@ func parseUnquoted(b *bytes.Buffer, key, part string, parts []interface{}) []int
 		if part == `` {
 			panic(px.Error(hieraapi.EmptyKeySegment, issue.H{`key`: key}))
 		}
+		if part == `*` && ix > 0 {
+			return keyWildcard{}
+		}
 		return part
 	}
 
@@ -171,6 +308,27 @@ func parseUnquoted(b *bytes.Buffer, key, part string, parts []interface{}) []int
 		case '.':
 			parts = append(parts, mungedPart(len(parts), b.String()))
 			b.Reset()
+		case '[':
+			// A bracket that doesn't form a valid segment, or that starts the key, is part of the name
+			if len(parts) > 0 || b.Len() > 0 {
+				if seg, end, ok := parseBracket(part[i:]); ok {
+					if b.Len() > 0 {
+						parts = append(parts, mungedPart(len(parts), b.String()))
+						b.Reset()
+					}
+					parts = append(parts, seg)
+					rest := part[i+end:]
+					switch {
+					case rest == ``:
+						return parts
+					case rest[0] == '.':
+						return parseUnquoted(b, key, rest[1:], parts)
+					default:
+						return parseUnquoted(b, key, rest, parts)
+					}
+				}
+			}
+			_, _ = b.WriteRune(c)
 		default:
 			_, _ = b.WriteRune(c)
 		}
@@ -178,13 +336,86 @@ func parseUnquoted(b *bytes.Buffer, key, part string, parts []interface{}) []int
 	return append(parts, mungedPart(len(parts), b.String()))
 }
 
+// parseBracket parses a segment such as "[2]", "[-1]", "[*]", or "[?role=admin]" at the start of the given part and
+// returns the segment and its length. The returned boolean is false when the part doesn't start with such a segment
+// or when the segment isn't followed by the end of the key, a '.', or another bracket.
+func parseBracket(part string) (interface{}, int, bool) {
+	end := -1
+	var q byte
+	for i := 1; i < len(part) && end < 0; i++ {
+		c := part[i]
+		switch {
+		case q != 0:
+			if c == q {
+				q = 0
+			}
+		case c == '\'' || c == '"':
+			q = c
+		case c == ']':
+			end = i
+		}
+	}
+	if end < 0 || !(end+1 == len(part) || part[end+1] == '.' || part[end+1] == '[') {
+		return nil, 0, false
+	}
+
+	src := part[:end+1]
+	expr := strings.TrimSpace(src[1:end])
+	if i, err := strconv.ParseInt(expr, 10, 32); err == nil {
+		return int(i), end + 1, true
+	}
+	if expr == `*` {
+		return keyWildcard{}, end + 1, true
+	}
+	if strings.HasPrefix(expr, `?`) {
+		if f := parseFilter(src, strings.TrimSpace(expr[1:])); f != nil {
+			return f, end + 1, true
+		}
+	}
+	return nil, 0, false
+}
+
+// parseFilter parses the expression of a filter such as "role=admin", "role!=admin", or "role". The result is nil
+// when the expression has no field.
+func parseFilter(src, expr string) *keyFilter {
+	f := &keyFilter{src: src, field: expr}
+	if i := strings.Index(expr, `=`); i >= 0 {
+		f.op = `=`
+		f.field = expr[:i]
+		if i > 0 && expr[i-1] == '!' {
+			f.op = `!=`
+			f.field = expr[:i-1]
+		}
+		f.field = strings.TrimSpace(f.field)
+		f.value = strings.TrimSpace(expr[i+1:])
+		if n := len(f.value); n >= 2 && (f.value[0] == '\'' || f.value[0] == '"') && f.value[n-1] == f.value[0] {
+			f.value = f.value[1 : n-1]
+		}
+	}
+	if f.field == `` {
+		return nil
+	}
+	return f
+}
+
 func parseQuoted(b *bytes.Buffer, q rune, key, part string, parts []interface{}) []interface{} {
 	for i, c := range part {
 		if c == q {
-			if i == len(part)-1 {
+			// A quoted segment is a string even when it looks like an index or a wildcard
+			rest := part[i+1:]
+			if rest == `` {
 				return append(parts, b.String())
 			}
-			return parseUnquoted(b, key, part[i+1:], parts)
+			if rest[0] == '.' {
+				parts = append(parts, b.String())
+				b.Reset()
+				return parseUnquoted(b, key, rest[1:], parts)
+			}
+			if _, _, ok := parseBracket(rest); ok {
+				parts = append(parts, b.String())
+				b.Reset()
+			}
+			return parseUnquoted(b, key, rest, parts)
 		}
 		_, _ = b.WriteRune(c)
 	}
@@ -2,6 +2,7 @@ package internal_test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -113,6 +114,54 @@ func ExampleNewKey_firstSegmentIndex() {
 	// Output: lookup() key '1.a' first segment cannot be an index
 }
 
+func ExampleNewKey_wildcard() {
+	key := hieraapi.NewKey(`services.*.port`)
+	fmt.Printf(`%d, %s`, len(key.Parts()), key.Parts()[1])
+	// Output: 3, *
+}
+
+func ExampleNewKey_filter() {
+	key := hieraapi.NewKey(`users[?role=admin].name`)
+	fmt.Printf(`%d, %s, %s`, len(key.Parts()), key.Parts()[1], key.Parts()[2])
+	// Output: 3, [?role=admin], name
+}
+
+func ExampleNewKey_bracketIndex() {
+	key := hieraapi.NewKey(`a[-1][2]`)
+	fmt.Println(key.Parts()[1:]...)
+	// Output: -1 2
+}
+
+func ExampleNewKey_firstSegmentWildcard() {
+	key := hieraapi.NewKey(`*.a`)
+	fmt.Printf(`%d, %q`, len(key.Parts()), key.Parts()[0])
+	// Output: 2, "*"
+}
+
+func ExampleNewKey_quotedWildcard() {
+	key := hieraapi.NewKey(`services.'*'.port`)
+	fmt.Printf(`%d, %q`, len(key.Parts()), key.Parts()[1])
+	// Output: 3, "*"
+}
+
+func ExampleNewKey_literalBracket() {
+	for _, k := range []string{`a[?role=admin`, `a[role]`, `a[1]b`, `[1]`, `a.[?]`} {
+		fmt.Printf("%q\n", hieraapi.NewKey(k).Parts())
+	}
+	// Output:
+	// ["a[?role=admin"]
+	// ["a[role]"]
+	// ["a[1]b"]
+	// ["[1]"]
+	// ["a" "[?]"]
+}
+
+func ExampleNewKey_partsJSON() {
+	bs, _ := json.Marshal(hieraapi.NewKey(`a.*.'*'[?role=admin][1]`).Parts())
+	fmt.Println(string(bs))
+	// Output: ["a",{"pattern":"*"},"*",{"pattern":"[?role=admin]"},1]
+}
+
 func printErr(e error) {
 	s := e.Error()
 	if ix := strings.Index(s, ` (file: `); ix > 0 {
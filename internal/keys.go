@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+)
+
+// RedactedSensitive is the string that replaces a Sensitive value when all values are looked up
+const RedactedSensitive = `Sensitive [value redacted]`
+
+// Keys returns the root keys that start with the given prefix and that are defined by the data providers of the
+// hierarchy and the default hierarchy of the configuration that is resolved for the given invocation. Each key is
+// returned together with the sources that define it, in hierarchy order. Only providers that are a
+// hieraapi.KeyLister can contribute keys.
+func Keys(ic hieraapi.Invocation, prefix string) []hieraapi.KeySources {
+	cfg := ic.Config()
+	found := make(map[string]*hieraapi.KeySources)
+	list := func(providers []hieraapi.DataProvider) {
+		for _, dp := range providers {
+			kl, ok := dp.(hieraapi.KeyLister)
+			if !ok {
+				continue
+			}
+			kl.ListKeys(ic, func(key string, source hieraapi.Source) {
+				if key == `lookup_options` || !strings.HasPrefix(key, prefix) {
+					return
+				}
+				ks, ok := found[key]
+				if !ok {
+					ks = &hieraapi.KeySources{Key: key}
+					found[key] = ks
+				}
+				ks.Sources = append(ks.Sources, source)
+			})
+		}
+	}
+	list(cfg.Hierarchy())
+	list(cfg.DefaultHierarchy())
+
+	result := make([]hieraapi.KeySources, 0, len(found))
+	for _, ks := range found {
+		result = append(result, *ks)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// LookupAll looks up each of the given root keys and returns a hash with the keys that were found and their values.
+// The lookup_options of each key are applied just as in any other lookup. Sensitive values are replaced by the
+// string RedactedSensitive unless revealSensitive is true, in which case they are unwrapped.
+func LookupAll(ic hieraapi.Invocation, keys []string, revealSensitive bool) px.OrderedMap {
+	entries := make([]*types.HashEntry, 0, len(keys))
+	for _, k := range keys {
+		// The key is a root key so it must not be parsed as a dotted key
+		if v := ic.(*invocation).lookup(&key{source: k, parts: []interface{}{k}}, NoOptions); v != nil {
+			entries = append(entries, types.WrapHashEntry2(k, redactSensitive(v, revealSensitive)))
+		}
+	}
+	return types.WrapHash(entries)
+}
+
+// LookupAllWithSources looks up each of the given root keys in the same way as LookupAll but doesn't alter
+// Sensitive values. The sources of the leaves of each found value are returned together with the hash.
+func LookupAllWithSources(ic hieraapi.Invocation, keys []string) (px.OrderedMap, map[string][]hieraapi.LeafSource) {
+	entries := make([]*types.HashEntry, 0, len(keys))
+	sources := make(map[string][]hieraapi.LeafSource, len(keys))
+	for _, k := range keys {
+		rk := &key{source: k, parts: []interface{}{k}}
+		v, leaves := WithSources(ic, func() px.Value {
+			return ic.(*invocation).lookup(rk, NoOptions)
+		})
+		if v != nil {
+			entries = append(entries, types.WrapHashEntry2(k, v))
+			sources[k] = leaves
+		}
+	}
+	return types.WrapHash(entries), sources
+}
+
+func redactSensitive(v px.Value, reveal bool) px.Value {
+	switch vc := v.(type) {
+	case *types.Sensitive:
+		if reveal {
+			return redactSensitive(vc.Unwrap(), reveal)
+		}
+		return types.WrapString(RedactedSensitive)
+	case *types.Array:
+		es := make([]px.Value, vc.Len())
+		vc.EachWithIndex(func(e px.Value, i int) {
+			es[i] = redactSensitive(e, reveal)
+		})
+		return types.WrapValues(es)
+	case *types.Hash:
+		es := make([]*types.HashEntry, 0, vc.Len())
+		vc.EachPair(func(k, e px.Value) {
+			es = append(es, types.WrapHashEntry(k, redactSensitive(e, reveal)))
+		})
+		return types.WrapHash(es)
+	}
+	return v
+}
+
+// eachExistingLocation calls the given function with each location of the given entry that exists, or with nil
+// when the entry has no locations
+func eachExistingLocation(he hieraapi.Entry, f func(location hieraapi.Location)) {
+	locations := he.Locations()
+	if len(locations) == 0 {
+		f(nil)
+		return
+	}
+	for _, location := range locations {
+		if location.Exists() {
+			f(location)
+		}
+	}
+}
+
+// sourceOf returns the source for a value found by the given provider using the given location
+func sourceOf(dp hieraapi.DataProvider, he hieraapi.Entry, location hieraapi.Location) hieraapi.Source {
+	src := hieraapi.Source{Entry: he.Name(), Provider: dp.FullName()}
+	if location != nil {
+		src.Location = location.Resolved()
+	}
+	return src
+}
@@ -0,0 +1,417 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/hiera/provider"
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+)
+
+// keySets maps data files to sets of their keys
+type keySets map[*dataFile]map[string]bool
+
+func (ks keySets) add(df *dataFile, key string) {
+	s, ok := ks[df]
+	if !ok {
+		s = make(map[string]bool)
+		ks[df] = s
+	}
+	s[key] = true
+}
+
+type linter struct {
+	ics        []hieraapi.Invocation
+	cfg        *hieraCfg
+	files      map[string]*dataFile
+	all        []*dataFile
+	keys       map[string]bool
+	wins       keySets
+	candidates keySets
+	redundant  keySets
+	notEqual   keySets
+	equalTo    map[*dataFile]map[string]*dataFile
+	aliases    map[string]string
+	deprecated map[string]string
+	findings   []*hieraapi.LintFinding
+	reported   map[string]bool
+}
+
+// Lint finds data smells in the data files that the yaml_data and json_data functions read for the configuration
+// of the given invocations. Each invocation provides one scope of the scope set. The following smells are found:
+//
+// - a key that is defined with the same value as at the closest higher level of the hierarchy that defines it,
+// for all scopes in the scope set that reach the file
+//
+// - a definition of a key that never wins under the first merge strategy for any scope in the scope set. Keys
+// that have another merge strategy in their lookup_options are exempt.
+//
+// - a lookup_options entry for a key that isn't defined and isn't an alias
+//
+// - a definition of a key that is deprecated or that is an alias of another key according to its lookup_options
+//
+// - an interpolation that refers to an unknown key or, when checkScope is true, to a variable that isn't found
+// in any of the scopes. Expressions that have a default are exempt.
+//
+// The findings for all files that match the path templates of the hierarchy are returned, ordered by file and line.
+func Lint(ics []hieraapi.Invocation, checkScope bool) []*hieraapi.LintFinding {
+	l := &linter{
+		ics:        ics,
+		cfg:        ics[0].Config().Config().(*hieraCfg),
+		files:      make(map[string]*dataFile),
+		keys:       make(map[string]bool),
+		wins:       make(keySets),
+		candidates: make(keySets),
+		redundant:  make(keySets),
+		notEqual:   make(keySets),
+		equalTo:    make(map[*dataFile]map[string]*dataFile),
+		aliases:    make(map[string]string),
+		deprecated: make(map[string]string),
+		reported:   make(map[string]bool)}
+
+	read := func(file, fn string) {
+		if df := l.read(file, fn); df != nil {
+			l.all = append(l.all, df)
+		}
+	}
+	eachTemplateMatch(l.cfg, l.cfg.hierarchy, read)
+	eachTemplateMatch(l.cfg, l.cfg.defaultHierarchy, read)
+
+	for _, df := range l.all {
+		df.hash.EachKey(func(k px.Value) { l.keys[k.String()] = true })
+	}
+	for _, ic := range ics {
+		for _, ks := range Keys(ic, ``) {
+			l.keys[ks.Key] = true
+		}
+	}
+
+	exempt := l.lintLookupOptions()
+	for _, ic := range ics {
+		l.lintDefinitions(ic)
+	}
+	l.lintRedundant()
+	l.lintShadowed(exempt)
+	l.lintDeprecated()
+	l.lintInterpolations(checkScope)
+
+	sort.SliceStable(l.findings, byFileAndLine(len(l.findings), func(i int) issue.Location { return l.findings[i].Location }))
+	return l.findings
+}
+
+// read returns the parsed data file or nil when it cannot be read. Problems with files are reported by Validate.
+func (l *linter) read(file, fn string) *dataFile {
+	if df, ok := l.files[file]; ok {
+		return df
+	}
+	var df *dataFile
+	if catch(func() { df = readDataFile(l.ics[0], file, fn) }) != `` {
+		df = nil
+	}
+	l.files[file] = df
+	return df
+}
+
+// add adds a finding unless a finding with the same kind, location, and message has been added already
+func (l *linter) add(kind hieraapi.LintKind, location issue.Location, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	id := fmt.Sprintf("%s\x00%s\x00%s", kind, issue.LocationString(location), msg)
+	if l.reported[id] {
+		return
+	}
+	l.reported[id] = true
+	l.findings = append(l.findings, &hieraapi.LintFinding{Problem: hieraapi.Problem{Location: location, Message: msg}, Kind: kind})
+}
+
+// locationOf returns the location of the value at the given path in the file
+func (df *dataFile) locationOf(vp ...interface{}) issue.Location {
+	return locationOf(df.positions, vp, issue.NewLocation(df.path, 0, 0))
+}
+
+// definitionOf returns the file and line where the given key is defined
+func (df *dataFile) definitionOf(key string) string {
+	if l := df.locationOf(key); l.Line() > 0 {
+		return fmt.Sprintf(`%s:%d`, df.path, l.Line())
+	}
+	return df.path
+}
+
+// lintLookupOptions reports lookup_options entries for keys that aren't defined, records the aliased and the
+// deprecated keys, and returns the keys that have a merge strategy other than first
+func (l *linter) lintLookupOptions() map[string]bool {
+	exempt := make(map[string]bool)
+	for _, df := range l.all {
+		lo, ok := df.hash.Get4(`lookup_options`)
+		if !ok {
+			continue
+		}
+		lh, ok := lo.(px.OrderedMap)
+		if !ok {
+			continue
+		}
+		lh.EachPair(func(k, ov px.Value) {
+			key := k.String()
+			oh, ok := ov.(px.OrderedMap)
+			if ok {
+				if a, ok := oh.Get4(`alias_of`); ok {
+					if _, ok := l.aliases[key]; !ok {
+						l.aliases[key] = a.String()
+					}
+				}
+				if d, ok := oh.Get4(`deprecated`); ok {
+					if _, ok := l.deprecated[key]; !ok {
+						l.deprecated[key] = d.String()
+					}
+				}
+			}
+			if !(l.keys[key] || oh != nil && oh.IncludesKey(types.WrapString(`alias_of`))) {
+				l.add(hieraapi.LintUnusedLookupOptions, df.locationOf(`lookup_options`, key), `lookup_options for '%s' refers to a key that is not defined`, key)
+			}
+			if ok {
+				if m, ok := oh.Get4(`merge`); ok {
+					if mh, ok := m.(px.OrderedMap); ok {
+						m = mh.Get5(`strategy`, types.WrapString(`first`))
+					}
+					if m.String() != `first` {
+						exempt[key] = true
+					}
+				}
+			}
+		})
+	}
+	return exempt
+}
+
+// reached returns the data files that the hierarchy of the given invocation reads, in hierarchy order
+func (l *linter) reached(ic hieraapi.Invocation) []*dataFile {
+	files := make([]*dataFile, 0)
+	for _, dp := range ic.Config().Hierarchy() {
+		dh, ok := dp.(*DataHashProvider)
+		if !(ok && isFileDataFunction(dh.hierarchyEntry.Function())) {
+			continue
+		}
+		for _, loc := range dh.hierarchyEntry.Locations() {
+			if loc.Kind() == hieraapi.LcPath && loc.Exists() {
+				if df := l.read(loc.Resolved(), dh.hierarchyEntry.Function().Name()); df != nil {
+					files = append(files, df)
+				}
+			}
+		}
+	}
+	return files
+}
+
+// lintDefinitions records the definitions of the files reached by the given invocation that win under the first
+// merge strategy, and whether each definition has the same value as the closest higher level that defines it
+func (l *linter) lintDefinitions(ic hieraapi.Invocation) {
+	higher := make(map[string]*dataFile)
+	for _, df := range l.reached(ic) {
+		df.hash.EachPair(func(k, v px.Value) {
+			key := k.String()
+			if key == `lookup_options` {
+				return
+			}
+			l.candidates.add(df, key)
+			hf, ok := higher[key]
+			if !ok {
+				l.wins.add(df, key)
+				l.notEqual.add(df, key)
+			} else if hv, _ := hf.hash.Get4(key); hv.Equals(v, nil) {
+				eq, ok := l.equalTo[df]
+				if !ok {
+					eq = make(map[string]*dataFile)
+					l.equalTo[df] = eq
+				}
+				if _, ok := eq[key]; !ok {
+					eq[key] = hf
+				}
+			} else {
+				l.notEqual.add(df, key)
+			}
+			higher[key] = df
+		})
+	}
+}
+
+// lintRedundant reports the definitions that have the same value as the closest higher level that defines them
+// for every scope that reaches their file. Deleting such a definition doesn't change the data of any scope.
+func (l *linter) lintRedundant() {
+	for _, df := range l.all {
+		eq := l.equalTo[df]
+		keys := make([]string, 0, len(eq))
+		for key := range eq {
+			if !l.notEqual[df][key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			l.redundant.add(df, key)
+			l.add(hieraapi.LintRedundant, df.locationOf(key), `'%s' has the same value as in %s`, key, eq[key].definitionOf(key))
+		}
+	}
+}
+
+// lintShadowed reports the definitions in reached files that never win under the first merge strategy. Keys
+// that are exempt and definitions that are reported as redundant are skipped.
+func (l *linter) lintShadowed(exempt map[string]bool) {
+	for _, df := range l.all {
+		keys := l.candidates[df]
+		names := make([]string, 0, len(keys))
+		for key := range keys {
+			if !(l.wins[df][key] || exempt[key] || l.redundant[df][key]) {
+				names = append(names, key)
+			}
+		}
+		sort.Strings(names)
+		for _, key := range names {
+			l.add(hieraapi.LintShadowed, df.locationOf(key), `'%s' never wins under the first merge strategy for any scope`, key)
+		}
+	}
+}
+
+// lintDeprecated reports the definitions of deprecated keys and of keys that are aliases of other keys. A key
+// that is both is reported once, with its deprecation message.
+func (l *linter) lintDeprecated() {
+	for _, df := range l.all {
+		df.hash.EachKey(func(k px.Value) {
+			key := k.String()
+			if msg, ok := l.deprecated[key]; ok {
+				l.add(hieraapi.LintDeprecated, df.locationOf(key), `'%s' is deprecated: %s`, key, msg)
+			} else if alias, ok := l.aliases[key]; ok {
+				l.add(hieraapi.LintDeprecated, df.locationOf(key), `'%s' is an alias of '%s' so this value is never used`, key, alias)
+			}
+		})
+	}
+}
+
+// lintInterpolations reports interpolations in the data files and in the locations of the hierarchy entries that
+// refer to unknown keys or, when checkScope is true, to scope variables that aren't found in any scope
+func (l *linter) lintInterpolations(checkScope bool) {
+	check := func(location issue.Location, str string) {
+		eachInterpolationRef(str, func(expr, name string) {
+			if checkScope && !l.inScope(name) {
+				l.add(hieraapi.LintUnknownInterpolation, location, `interpolation '%s' refers to an unknown scope variable '%s'`, expr, name)
+			}
+		}, func(expr, name string) {
+			if _, ok := l.aliases[name]; !(ok || l.keys[name]) {
+				l.add(hieraapi.LintUnknownInterpolation, location, `interpolation '%s' refers to an unknown key '%s'`, expr, name)
+			}
+		})
+	}
+
+	for _, df := range l.all {
+		df.hash.EachPair(func(k, v px.Value) {
+			if k.String() != `lookup_options` {
+				eachString(v, []interface{}{k.String()}, func(vp []interface{}, s string) {
+					check(df.locationOf(vp...), s)
+				})
+			}
+		})
+	}
+
+	if l.cfg.path == `` {
+		return
+	}
+	bin, ok := types.BinaryFromFile2(l.cfg.path)
+	if !ok {
+		return
+	}
+	positions, root := provider.ParsePositions(l.cfg.path, bin.Bytes())
+	checkEntries := func(name string, entries []hieraapi.Entry) {
+		for i, he := range entries {
+			location := locationOf(positions, []interface{}{name, i}, root)
+			e := he.(*entry)
+			check(location, e.dataDir)
+			for _, loc := range e.locations {
+				switch loc := loc.(type) {
+				case *path:
+					check(location, loc.original)
+				case *glob:
+					check(location, loc.pattern)
+				}
+			}
+		}
+	}
+	checkEntries(`hierarchy`, l.cfg.hierarchy)
+	checkEntries(`default_hierarchy`, l.cfg.defaultHierarchy)
+}
+
+// inScope returns true if the root of the given variable name is found in any of the scopes
+func (l *linter) inScope(name string) bool {
+	for _, ic := range l.ics {
+		if _, ok := ic.Scope().Get(types.WrapString(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// eachString calls the given function with each string in the given value and the path to that string
+func eachString(v px.Value, vp []interface{}, f func(vp []interface{}, s string)) {
+	switch v := v.(type) {
+	case px.StringValue:
+		f(vp, v.String())
+	case *types.Hash:
+		v.EachPair(func(k, e px.Value) { eachString(e, append(vp[:len(vp):len(vp)], k.String()), f) })
+	case *types.Array:
+		v.EachWithIndex(func(e px.Value, i int) { eachString(e, append(vp[:len(vp):len(vp)], i), f) })
+	}
+}
+
+// eachInterpolationRef calls scopeVar with each scope variable and key with each key that the interpolation
+// expressions of the given string refer to. The root of the variable or key is passed together with the
+// expression. Expressions that have a default or that cannot be parsed are skipped.
+func eachInterpolationRef(str string, scopeVar, key func(expr, name string)) {
+	root := func(name string) (r string) {
+		if catch(func() { r = newKey(name).Root() }) != `` {
+			r = ``
+		}
+		return
+	}
+	for _, match := range iplPattern.FindAllString(str, -1) {
+		expr := strings.TrimSpace(match[2 : len(match)-1])
+		if emptyInterpolations[expr] {
+			continue
+		}
+		var c *iplCall
+		var dflt interface{}
+		if catch(func() {
+			expr, dflt = splitDefault(expr)
+			c = parseMethodCall(expr)
+		}) != `` || dflt != nil {
+			continue
+		}
+		if c == nil {
+			if r := root(expr); r != `` {
+				scopeVar(match, r)
+			}
+			continue
+		}
+		var walk func(c *iplCall)
+		walk = func(c *iplCall) {
+			for _, a := range c.args {
+				switch a := a.(type) {
+				case *iplCall:
+					walk(a)
+				case string:
+					r := root(a)
+					if r == `` {
+						continue
+					}
+					switch c.name {
+					case `alias`, `hiera`, `lookup`:
+						key(match, r)
+					case `scope`:
+						scopeVar(match, r)
+					}
+				}
+			}
+		}
+		walk(c)
+	}
+}
@@ -56,6 +56,7 @@ func (dh *LookupKeyProvider) lookupKey(ic hieraapi.Invocation, location hieraapi
 	cache, _ := dh.hashes.LoadOrStore(key, &sync.Map{})
 	value := dh.providerFunction(ic)(newServerContext(ic, cache.(*sync.Map), opts), root)
 	if value != nil {
+		requireEntryInterpolationMode(ic, dh.hierarchyEntry)
 		ic.ReportFound(root, value)
 	} else {
 		ic.ReportNotFound(root)
@@ -88,6 +89,42 @@ func (dh *LookupKeyProvider) loadFunction(ic hieraapi.Invocation) (pf hieraapi.L
 	return func(pc hieraapi.ServerContext, key string) px.Value { return nil }
 }
 
+// ListKeys calls the given function with each key that the lookup_key function of this provider lists for the
+// locations of its hierarchy entry. Only plugin functions that advertise that they can list their keys do so.
+func (dh *LookupKeyProvider) ListKeys(ic hieraapi.Invocation, f func(key string, source hieraapi.Source)) {
+	switch dh.hierarchyEntry.Function().Name() {
+	case `environment`, `scope`:
+		return
+	}
+
+	// Ensure that the plugin is loaded before looking for its key lister
+	dh.providerFunction(ic)
+	lf, ok := loadKeyListerFunction(ic, dh.hierarchyEntry.Function().Name())
+	if !ok {
+		return
+	}
+	eachExistingLocation(dh.hierarchyEntry, func(location hieraapi.Location) {
+		key := ``
+		opts := dh.hierarchyEntry.OptionsMap()
+		if location != nil {
+			key = location.Resolved()
+			opts = optionsWithLocation(opts, key)
+		}
+		cache, _ := dh.hashes.LoadOrStore(key, &sync.Map{})
+		if keys, ok := lf.Call(ic, nil, newServerContext(ic, cache.(*sync.Map), opts)).(*types.Array); ok {
+			src := sourceOf(dh, dh.hierarchyEntry, location)
+			keys.Each(func(k px.Value) {
+				f(k.String(), src)
+			})
+		}
+	})
+}
+
+// HierarchyEntry returns the hierarchy entry that this provider was created from
+func (dh *LookupKeyProvider) HierarchyEntry() hieraapi.Entry {
+	return dh.hierarchyEntry
+}
+
 func (dh *LookupKeyProvider) FullName() string {
 	return fmt.Sprintf(`lookup_key function '%s'`, dh.hierarchyEntry.Function().Name())
 }
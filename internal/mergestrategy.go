@@ -13,6 +13,7 @@ import (
 
 func init() {
 	hieraapi.GetMergeStrategy = getMergeStrategy
+	hieraapi.NewMergeStrategy = newFuncMerge
 }
 
 func getMergeStrategy(n hieraapi.MergeStrategyName, opts map[string]px.Value) hieraapi.MergeStrategy {
@@ -26,18 +27,27 @@ func getMergeStrategy(n hieraapi.MergeStrategyName, opts map[string]px.Value) hi
 	case `deep`:
 		return &deepMerge{opts}
 	default:
+		if f, ok := hieraapi.RegisteredMergeStrategy(n); ok {
+			ms := f(opts)
+			if _, ok := ms.(merger); !ok {
+				ms = &untrackedMerge{ms}
+			}
+			return ms
+		}
 		panic(px.Error(hieraapi.UnknownMergeStrategy, issue.H{`name`: n}))
 	}
 }
 
+// merger is implemented by the strategies that merge the sources of the values along with the values. The
+// sources are nil unless they are tracked.
 type merger interface {
 	hieraapi.MergeStrategy
 
-	merge(a, b px.Value) px.Value
+	merge(a, b px.Value, sa, sb *sources) (px.Value, *sources)
 
-	mergeSingle(v reflect.Value, vf func(l interface{}) px.Value) px.Value
+	mergeSingle(v px.Value, s *sources) (px.Value, *sources)
 
-	convertValue(v px.Value) px.Value
+	convertValue(v px.Value, s *sources) (px.Value, *sources)
 }
 
 type deepMerge struct{ opts map[string]px.Value }
@@ -48,6 +58,23 @@ type firstFound struct{}
 
 type unique struct{}
 
+type funcMerge struct {
+	name  hieraapi.MergeStrategyName
+	label string
+	mf    hieraapi.MergeFunction
+	opts  map[string]px.Value
+}
+
+// untrackedMerge is a registered strategy that doesn't merge the sources of the values. The sources of the
+// values that it produces are unknown.
+type untrackedMerge struct {
+	hieraapi.MergeStrategy
+}
+
+func newFuncMerge(name hieraapi.MergeStrategyName, label string, mf hieraapi.MergeFunction, opts map[string]px.Value) hieraapi.MergeStrategy {
+	return &funcMerge{name: name, label: label, mf: mf, opts: opts}
+}
+
 func doLookup(s merger, vs interface{}, ic hieraapi.Invocation, vf func(l interface{}) px.Value) px.Value {
 	vsr := reflect.ValueOf(vs)
 	if vsr.Kind() != reflect.Slice {
@@ -58,23 +85,32 @@ func doLookup(s merger, vs interface{}, ic hieraapi.Invocation, vf func(l interf
 	case 0:
 		return nil
 	case 1:
-		return s.mergeSingle(vsr.Index(0), vf)
+		p := trackingSources(ic)
+		v, src := p.lookup(func() px.Value { return variantLookup(vsr.Index(0), vf) })
+		if v != nil {
+			v, src = s.mergeSingle(v, src)
+		}
+		p.found(src)
+		return v
 	default:
 		return ic.WithMerge(s, func() px.Value {
+			p := trackingSources(ic)
 			var memo px.Value
+			var ms *sources
 			for idx := 0; idx < top; idx++ {
-				v := variantLookup(vsr.Index(idx), vf)
+				v, src := p.lookup(func() px.Value { return variantLookup(vsr.Index(idx), vf) })
 				if v != nil {
 					if memo == nil {
-						memo = s.convertValue(v)
+						memo, ms = s.convertValue(v, src)
 					} else {
-						memo = s.merge(memo, v)
+						memo, ms = s.merge(memo, v, ms, src)
 					}
 				}
 			}
 			if memo != nil {
 				ic.ReportMergeResult(memo)
 			}
+			p.found(ms)
 			return memo
 		})
 	}
@@ -109,8 +145,10 @@ func (d *firstFound) Lookup(vs interface{}, ic hieraapi.Invocation, f func(locat
 	default:
 		var v px.Value
 		return ic.WithMerge(d, func() px.Value {
+			p := trackingSources(ic)
+			var src *sources
 			for idx := 0; idx < top; idx++ {
-				v = variantLookup(vsr.Index(idx), f)
+				v, src = p.lookup(func() px.Value { return variantLookup(vsr.Index(idx), f) })
 				if v != nil {
 					break
 				}
@@ -118,6 +156,7 @@ func (d *firstFound) Lookup(vs interface{}, ic hieraapi.Invocation, f func(locat
 			if v != nil {
 				ic.ReportMergeResult(v)
 			}
+			p.found(src)
 			return v
 		})
 	}
@@ -127,16 +166,16 @@ func (d *firstFound) Options() px.OrderedMap {
 	return px.EmptyMap
 }
 
-func (d *firstFound) mergeSingle(v reflect.Value, vf func(l interface{}) px.Value) px.Value {
-	return variantLookup(v, vf)
+func (d *firstFound) mergeSingle(v px.Value, s *sources) (px.Value, *sources) {
+	return v, s
 }
 
-func (d *firstFound) convertValue(v px.Value) px.Value {
-	return v
+func (d *firstFound) convertValue(v px.Value, s *sources) (px.Value, *sources) {
+	return v, s
 }
 
-func (d *firstFound) merge(a, b px.Value) px.Value {
-	return a
+func (d *firstFound) merge(a, b px.Value, sa, sb *sources) (px.Value, *sources) {
+	return a, sa
 }
 
 func (d *unique) Name() hieraapi.MergeStrategyName {
@@ -155,23 +194,88 @@ func (d *unique) Options() px.OrderedMap {
 	return px.EmptyMap
 }
 
-func (d *unique) mergeSingle(rv reflect.Value, vf func(l interface{}) px.Value) px.Value {
-	v := variantLookup(rv, vf)
+func (d *unique) mergeSingle(v px.Value, s *sources) (px.Value, *sources) {
 	if av, ok := v.(*types.Array); ok {
-		return av.Flatten().Unique()
+		es, ss := flatten(av, s, nil, nil)
+		return uniqueValues(es, ss)
 	}
-	return v
+	return v, s
+}
+
+func (d *unique) convertValue(v px.Value, s *sources) (px.Value, *sources) {
+	if av, ok := v.(*types.Array); ok {
+		es, ss := flatten(av, s, nil, nil)
+		return types.WrapValues(es), elementSources(ss)
+	}
+	return types.WrapValues([]px.Value{v}), elementSources([]*sources{s})
 }
 
-func (d *unique) convertValue(v px.Value) px.Value {
+func (d *unique) merge(a, b px.Value, sa, sb *sources) (px.Value, *sources) {
+	es, ss := flattenValue(a, sa, nil, nil)
+	es, ss = flattenValue(b, sb, es, ss)
+	return uniqueValues(es, ss)
+}
+
+// flattenValue appends the elements of the given value to the given slices in the same way as flatten unless
+// the value isn't an array, in which case the value itself is appended
+func flattenValue(v px.Value, s *sources, es []px.Value, ss []*sources) ([]px.Value, []*sources) {
 	if av, ok := v.(*types.Array); ok {
-		return av.Flatten()
+		return flatten(av, s, es, ss)
 	}
-	return types.WrapValues([]px.Value{v})
+	return append(es, v), append(ss, s)
+}
+
+// flatten appends the elements of the given array to the given slices in the same way as the Flatten method of
+// the array flattens them, together with the sources of each element
+func flatten(av *types.Array, s *sources, es []px.Value, ss []*sources) ([]px.Value, []*sources) {
+	av.EachWithIndex(func(e px.Value, i int) {
+		es0 := s.child(i)
+		switch e := e.(type) {
+		case *types.Array:
+			es, ss = flatten(e, es0, es, ss)
+		case *types.HashEntry:
+			pair := &sources{}
+			pair.setChild(0, es0)
+			pair.setChild(1, es0)
+			es, ss = flatten(types.WrapValues([]px.Value{e.Key(), e.Value()}), pair, es, ss)
+		default:
+			es = append(es, e)
+			ss = append(ss, es0)
+		}
+	})
+	return es, ss
+}
+
+// uniqueValues returns an array with the unique values of the given elements together with their sources. The
+// first occurrence of a value is kept.
+func uniqueValues(es []px.Value, ss []*sources) (px.Value, *sources) {
+	ues := make([]px.Value, 0, len(es))
+	uss := make([]*sources, 0, len(ss))
+	exists := make(map[px.HashKey]bool, len(es))
+	for i, e := range es {
+		k := px.ToKey(e)
+		if !exists[k] {
+			exists[k] = true
+			ues = append(ues, e)
+			uss = append(uss, ss[i])
+		}
+	}
+	return types.WrapValues(ues), elementSources(uss)
 }
 
-func (d *unique) merge(a, b px.Value) px.Value {
-	return d.convertValue(a).(px.List).AddAll(d.convertValue(b).(px.List)).Unique()
+// elementSources returns the sources of an array with elements that have the given sources, or nil if none of
+// the sources are known
+func elementSources(ss []*sources) *sources {
+	var s *sources
+	for i, es := range ss {
+		if es != nil {
+			if s == nil {
+				s = &sources{}
+			}
+			s.setChild(i, es)
+		}
+	}
+	return s
 }
 
 func (d *deepMerge) Name() hieraapi.MergeStrategyName {
@@ -193,17 +297,17 @@ func (d *deepMerge) Options() px.OrderedMap {
 	return px.EmptyMap
 }
 
-func (d *deepMerge) mergeSingle(v reflect.Value, vf func(l interface{}) px.Value) px.Value {
-	return variantLookup(v, vf)
+func (d *deepMerge) mergeSingle(v px.Value, s *sources) (px.Value, *sources) {
+	return v, s
 }
 
-func (d *deepMerge) convertValue(v px.Value) px.Value {
-	return v
+func (d *deepMerge) convertValue(v px.Value, s *sources) (px.Value, *sources) {
+	return v, s
 }
 
-func (d *deepMerge) merge(a, b px.Value) px.Value {
-	v, _ := DeepMerge(a, b, d.opts)
-	return v
+func (d *deepMerge) merge(a, b px.Value, sa, sb *sources) (px.Value, *sources) {
+	v, s, _ := deepMergeWithSources(a, b, sa, sb, d.opts)
+	return v, s
 }
 
 func (d *hashMerge) Name() hieraapi.MergeStrategyName {
@@ -222,20 +326,72 @@ func (d *hashMerge) Options() px.OrderedMap {
 	return px.EmptyMap
 }
 
-func (d *hashMerge) mergeSingle(v reflect.Value, vf func(l interface{}) px.Value) px.Value {
-	return variantLookup(v, vf)
+func (d *hashMerge) mergeSingle(v px.Value, s *sources) (px.Value, *sources) {
+	return v, s
 }
 
-func (d *hashMerge) convertValue(v px.Value) px.Value {
-	return v
+func (d *hashMerge) convertValue(v px.Value, s *sources) (px.Value, *sources) {
+	return v, s
 }
 
-func (d *hashMerge) merge(a, b px.Value) px.Value {
+func (d *hashMerge) merge(a, b px.Value, sa, sb *sources) (px.Value, *sources) {
 	if ah, ok := a.(*types.Hash); ok {
 		var bh *types.Hash
 		if bh, ok = b.(*types.Hash); ok {
-			return bh.Merge(ah)
+			mh := bh.Merge(ah)
+			s := newSources(sa, sb)
+			if s != nil {
+				mh.EachKey(func(k px.Value) {
+					ck := childKey(k)
+					if ah.IncludesKey(k) {
+						s.setChild(ck, sa.child(ck))
+					} else {
+						s.setChild(ck, sb.child(ck))
+					}
+				})
+			}
+			return mh, s
 		}
 	}
-	return a
+	return a, sa
+}
+
+func (d *funcMerge) Name() hieraapi.MergeStrategyName {
+	return d.name
+}
+
+func (d *funcMerge) Label() string {
+	return d.label
+}
+
+func (d *funcMerge) Lookup(vs interface{}, ic hieraapi.Invocation, f func(location interface{}) px.Value) px.Value {
+	return doLookup(d, vs, ic, f)
+}
+
+func (d *funcMerge) Options() px.OrderedMap {
+	if len(d.opts) > 0 {
+		return types.WrapStringToValueMap(d.opts)
+	}
+	return px.EmptyMap
+}
+
+func (d *funcMerge) mergeSingle(v px.Value, s *sources) (px.Value, *sources) {
+	return v, s
+}
+
+func (d *funcMerge) convertValue(v px.Value, s *sources) (px.Value, *sources) {
+	return v, s
+}
+
+// merge returns the value produced by the merge function. The sources of that value are unknown since the
+// function can produce any value.
+func (d *funcMerge) merge(a, b px.Value, sa, sb *sources) (px.Value, *sources) {
+	return d.mf(a, b), nil
+}
+
+// Lookup performs the lookup of the wrapped strategy. The sources of the produced value are unknown.
+func (d *untrackedMerge) Lookup(vs interface{}, ic hieraapi.Invocation, f func(location interface{}) px.Value) px.Value {
+	v := d.MergeStrategy.Lookup(vs, ic, f)
+	trackingSources(ic).found(nil)
+	return v
 }
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/serialization"
+	"github.com/lyraproj/pcore/types"
+)
+
+// CallbackParam is the name of the parameter that is passed to a plugin in each call. Its value is the base URL
+// of the callback channel that the plugin can use to perform nested lookups for as long as the call is active.
+const CallbackParam = `callback`
+
+// ExplainHeader is the name of the response header that a plugin can use to contribute messages to the lookup
+// explanation. The header may be repeated once for each message.
+const ExplainHeader = `X-Hiera-Explain`
+
+// pluginCallbacks is a HTTP server on the loopback interface that serves callbacks from plugins. Each active
+// plugin call opens a session that is identified by a random token. The token is the first element of the
+// path of each callback request, e.g. "/<token>/lookup/<key>" or "/<token>/interpolate?value=<string>".
+type pluginCallbacks struct {
+	lock     sync.Mutex
+	server   *http.Server
+	address  string
+	sessions map[string]*callbackSession
+}
+
+// callbackSession delivers callback requests to the Go routine that performs the plugin call. Lookups are thus
+// performed by the same Go routine that performed the original lookup.
+type callbackSession struct {
+	token    string
+	url      string
+	requests chan *callbackRequest
+	done     chan bool
+}
+
+type callbackRequest struct {
+	method string
+	arg    string
+	reply  chan *callbackResponse
+}
+
+type callbackResponse struct {
+	status int
+	body   []byte
+}
+
+// open starts the server unless it is already started and returns a new session.
+func (cb *pluginCallbacks) open() *callbackSession {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.server == nil {
+		listener, err := net.Listen(`tcp`, `127.0.0.1:0`)
+		if err != nil {
+			panic(fmt.Errorf(`unable to create plugin callback listener: %s`, err.Error()))
+		}
+		cb.address = listener.Addr().String()
+		cb.sessions = make(map[string]*callbackSession)
+		server := &http.Server{Handler: http.HandlerFunc(cb.serveHTTP)}
+		cb.server = server
+		go func() {
+			_ = server.Serve(listener)
+		}()
+	}
+
+	tb := make([]byte, 16)
+	if _, err := rand.Read(tb); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(tb)
+	s := &callbackSession{
+		token:    token,
+		url:      `http://` + cb.address + `/` + token,
+		requests: make(chan *callbackRequest),
+		done:     make(chan bool)}
+	cb.sessions[token] = s
+	return s
+}
+
+// close ends the given session. Callback requests using its token are rejected from now on.
+func (cb *pluginCallbacks) close(s *callbackSession) {
+	cb.lock.Lock()
+	delete(cb.sessions, s.token)
+	cb.lock.Unlock()
+	close(s.done)
+}
+
+// stop stops the server
+func (cb *pluginCallbacks) stop() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.server != nil {
+		_ = cb.server.Close()
+		cb.server = nil
+	}
+}
+
+func (cb *pluginCallbacks) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, `/`), `/`, 3)
+	var rq *callbackRequest
+	switch {
+	case len(parts) == 3 && parts[1] == `lookup` && parts[2] != ``:
+		rq = &callbackRequest{method: parts[1], arg: parts[2]}
+	case len(parts) == 2 && parts[1] == `interpolate`:
+		rq = &callbackRequest{method: parts[1], arg: r.URL.Query().Get(`value`)}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	rq.reply = make(chan *callbackResponse, 1)
+
+	cb.lock.Lock()
+	s, ok := cb.sessions[parts[0]]
+	cb.lock.Unlock()
+	if !ok {
+		http.Error(w, `invalid or expired callback token`, http.StatusForbidden)
+		return
+	}
+
+	select {
+	case s.requests <- rq:
+	case <-s.done:
+		http.Error(w, `invalid or expired callback token`, http.StatusForbidden)
+		return
+	}
+	resp := <-rq.reply
+	if resp.status != http.StatusOK {
+		http.Error(w, string(resp.body), resp.status)
+		return
+	}
+	w.Header().Set(`Content-Type`, `application/json`)
+	_, _ = w.Write(resp.body)
+}
+
+// serveCallback performs a nested lookup or an interpolation on behalf of a plugin. A looked up key is pushed
+// onto the name stack of the invocation so that a plugin that ends up looking up the key that it is currently
+// resolving is detected.
+func serveCallback(ic *invocation, rq *callbackRequest) (resp *callbackResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			resp = &callbackResponse{status: http.StatusInternalServerError, body: []byte(fmt.Sprint(r))}
+		}
+	}()
+
+	var v px.Value
+	if rq.method == `interpolate` {
+		v = Interpolate(ic, types.WrapString(rq.arg), true)
+	} else {
+		k := newKey(rq.arg)
+		v = ic.WithKey(k, func() px.Value {
+			return ic.WithSubLookup(k, func() px.Value {
+				return ic.lookup(k, NoOptions)
+			})
+		})
+	}
+	if v == nil {
+		return &callbackResponse{status: http.StatusNotFound, body: []byte(`not found`)}
+	}
+	bld := bytes.Buffer{}
+	serialization.DataToJson(v, &bld)
+	return &callbackResponse{status: http.StatusOK, body: bld.Bytes()}
+}
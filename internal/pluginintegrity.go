@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	log "github.com/sirupsen/logrus"
+)
+
+// signatureExtension is the extension of the file that holds the base64 encoded ed25519 signature of a
+// plugin executable. The signature file must be present in the same directory as the executable.
+const signatureExtension = `.sig`
+
+// pluginIntegrity holds the allowlist of digests and the public keys that a plugin executable is verified
+// against before it is started.
+type pluginIntegrity struct {
+	digests    []string
+	publicKeys []string
+}
+
+func newPluginIntegrity(he hieraapi.Entry) *pluginIntegrity {
+	if len(he.PluginDigests()) == 0 && len(he.PluginPublicKeys()) == 0 {
+		return nil
+	}
+	return &pluginIntegrity{digests: he.PluginDigests(), publicKeys: he.PluginPublicKeys()}
+}
+
+// verify panics with a PluginIntegrityViolation unless the executable at the given path has one of the allowed
+// digests or a signature that can be verified using one of the public keys. The digest argument must be the
+// hex encoded SHA-256 digest of the executable.
+func (pi *pluginIntegrity) verify(path, digest string) {
+	if pi == nil {
+		return
+	}
+	pi.check(path, digest, func() ([]byte, error) { return ioutil.ReadFile(path) })
+}
+
+// verifiedCopy reads the executable at the given path once, verifies its content, and writes it to a new private
+// directory so that the file that is started is the file that was verified, even if the original is replaced in
+// the meantime. The path of the copy and the hex encoded SHA-256 digest of the content are returned. The caller
+// is responsible for removing the directory of the copy.
+func (pi *pluginIntegrity) verifiedCopy(path string) (string, string) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		pi.refuse(path, err.Error())
+	}
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	pi.check(path, digest, func() ([]byte, error) { return content, nil })
+
+	dir, err := ioutil.TempDir(``, `hiera-plugin`)
+	if err != nil {
+		panic(fmt.Errorf(`unable to copy plugin %s: %s`, path, err.Error()))
+	}
+	// Only the owner can write but the plugin may run as another user
+	cp := filepath.Join(dir, filepath.Base(path))
+	if err = os.Chmod(dir, 0711); err == nil {
+		err = ioutil.WriteFile(cp, content, 0755)
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		panic(fmt.Errorf(`unable to copy plugin %s: %s`, path, err.Error()))
+	}
+	return cp, digest
+}
+
+// check panics with a PluginIntegrityViolation unless the given digest is allowed or the content returned by the
+// given function has a signature that can be verified using one of the public keys.
+func (pi *pluginIntegrity) check(path, digest string, content func() ([]byte, error)) {
+	if digest == `` {
+		pi.refuse(path, `unable to compute digest`)
+	}
+	for _, d := range pi.digests {
+		if strings.EqualFold(d, digest) {
+			return
+		}
+	}
+	if len(pi.publicKeys) > 0 {
+		if reason := pi.verifySignature(path, content); reason != `` {
+			pi.refuse(path, reason)
+		}
+		return
+	}
+	pi.refuse(path, fmt.Sprintf(`digest %s is not in the list of allowed digests`, digest))
+}
+
+// verifySignature returns an empty string if the signature of the given executable can be verified using one
+// of the public keys, or the reason why it could not be verified. Public keys that are invalid are skipped.
+func (pi *pluginIntegrity) verifySignature(path string, content func() ([]byte, error)) string {
+	bs, err := content()
+	if err != nil {
+		return err.Error()
+	}
+	sigData, err := ioutil.ReadFile(path + signatureExtension)
+	if err != nil {
+		return fmt.Sprintf(`unable to read signature: %s`, err.Error())
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sigData)))
+	if err != nil {
+		return fmt.Sprintf(`invalid signature: %s`, err.Error())
+	}
+	invalid := make([]string, 0)
+	for _, pk := range pi.publicKeys {
+		key, err := base64.StdEncoding.DecodeString(pk)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			log.Warnf(`ignoring invalid ed25519 public key '%s' when verifying plugin %s`, pk, path)
+			invalid = append(invalid, pk)
+			continue
+		}
+		if ed25519.Verify(key, bs, sig) {
+			return ``
+		}
+	}
+	if len(invalid) == len(pi.publicKeys) {
+		return `none of the public keys is a valid ed25519 public key`
+	}
+	return `signature could not be verified using any of the public keys`
+}
+
+func (pi *pluginIntegrity) refuse(path, reason string) {
+	panic(px.Error(hieraapi.PluginIntegrityViolation, issue.H{`path`: path, `reason`: reason}))
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginIntegrity_verifiedCopy(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `hiera`)
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, `plugin`)
+	content := []byte("#!/bin/sh\necho hello\n")
+	require.NoError(t, ioutil.WriteFile(path, content, 0755))
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, content))
+	require.NoError(t, ioutil.WriteFile(path+signatureExtension, []byte(sig), 0644))
+
+	// The invalid key is skipped and the valid key verifies the signature
+	pi := &pluginIntegrity{publicKeys: []string{`not a key`, base64.StdEncoding.EncodeToString(pub)}}
+	cp, digest := pi.verifiedCopy(path)
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(cp))
+	}()
+	require.NotEqual(t, path, cp)
+	require.Equal(t, fileDigest(path), digest)
+
+	// Replacing the original doesn't affect the verified copy
+	require.NoError(t, ioutil.WriteFile(path, []byte("#!/bin/sh\necho evil\n"), 0755))
+	bs, err := ioutil.ReadFile(cp)
+	require.NoError(t, err)
+	require.Equal(t, content, bs)
+
+	pi = &pluginIntegrity{publicKeys: []string{`not a key`}}
+	err = func() (err error) {
+		defer func() {
+			err = recover().(error)
+		}()
+		pi.verifiedCopy(path)
+		return nil
+	}()
+	require.Contains(t, err.Error(), `none of the public keys is a valid ed25519 public key`)
+}
@@ -3,6 +3,8 @@ package internal
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -37,18 +39,73 @@ type pluginLoader struct {
 // a plugin corresponds to a loaded process
 type plugin struct {
 	lock      sync.Mutex
-	wGroup    sync.WaitGroup
-	process   *os.Process
 	path      string
-	addr      string
-	network   string
 	functions map[string]interface{}
+
+	// managed is true when Hiera starts the plugin process and hence is responsible for its life-cycle
+	managed   bool
+	env       []string
+	transport string
+	callbacks *pluginCallbacks
+	procCfg   *hieraapi.PluginProcess
+	integrity *pluginIntegrity
+
+	// useCallbacks is true when the plugin has declared in its meta-info that it uses the callback channel
+	useCallbacks bool
+
+	// inst is the current instance of the plugin. It is nil when a managed plugin has been stopped because it
+	// was idle. The instance and the fields below are guarded by lock.
+	inst        *pluginInstance
+	idleTimeout time.Duration
+	idleTimer   stopper
+	lastUsed    time.Time
+
+	// digest of the plugin executable at the time when the current instance was started
+	digest string
+}
+
+// a pluginInstance is a running process of a managed plugin or the connection to an externally managed plugin
+// server. Each call holds a reference to the instance that it uses, so that an instance that is replaced because
+// the plugin executable changed is stopped once the calls that use it have completed.
+type pluginInstance struct {
+	process  *os.Process
+	wGroup   sync.WaitGroup
+	stopOnce sync.Once
+	addr     string
+	network  string
+	stdio    *stdioClient
+	wasm     *wasmModule
+
+	// privateDir is the directory of the private copy of a verified plugin executable when the copy must be
+	// removed after the process has terminated
+	privateDir string
+
+	// refs is the number of calls in flight and retired is true when the instance is no longer the current
+	// instance of its plugin. Both are guarded by the lock of the plugin.
+	refs    int
+	retired bool
+
+	// file info of the plugin executable at the time when the instance was started
+	modTime time.Time
+	size    int64
+}
+
+// stopper is implemented by *time.Timer
+type stopper interface {
+	Stop() bool
 }
 
+// afterFunc and timeNow are used when scheduling and performing the idle checks of plugins
+var (
+	afterFunc = func(d time.Duration, f func()) stopper { return time.AfterFunc(d, f) }
+	timeNow   = time.Now
+)
+
 // a pluginRegistry keeps track of loaded plugins
 type pluginRegistry struct {
-	lock    sync.Mutex
-	plugins map[string]*plugin
+	lock      sync.Mutex
+	plugins   map[string]*plugin
+	callbacks pluginCallbacks
 }
 
 // NewPluginLoader returns a loader that is capable of discovered plugins that matches the given hierarchy entry. If
@@ -67,6 +124,7 @@ func (r *pluginRegistry) stopAll() {
 		p.kill()
 	}
 	r.plugins = nil
+	r.callbacks.stop()
 }
 
 var DefaultUnixSocketDir = "/tmp"
@@ -82,6 +140,23 @@ func getUnixSocketDir(c px.Context) string {
 	return v
 }
 
+// DefaultPluginIdleTimeout is the time that a plugin process may remain unused before it is stopped. Zero
+// means that plugin processes are kept alive until the plugin registry is stopped.
+var DefaultPluginIdleTimeout = time.Duration(0)
+
+// getPluginIdleTimeout resolves value of pluginIdleTimeout
+func getPluginIdleTimeout(c px.Context) time.Duration {
+	v := extractOptFromContext(c, "pluginIdleTimeout")
+	if v == "" {
+		return DefaultPluginIdleTimeout
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		panic(fmt.Errorf(`invalid pluginIdleTimeout %s: %s`, v, err.Error()))
+	}
+	return d
+}
+
 var DefaultPluginTransport = "unix"
 
 // getPluginTransport resolves value of pluginTransport
@@ -91,7 +166,8 @@ func getPluginTransport(c px.Context) string {
 	switch v {
 	case
 		"unix",
-		"tcp":
+		"tcp",
+		StdioTransport:
 		return v
 	}
 
@@ -99,24 +175,86 @@ func getPluginTransport(c px.Context) string {
 }
 
 // startPlugin will start the plugin loaded from the given path and register the functions that it makes available
-// with the given loader.
-func (r *pluginRegistry) startPlugin(c px.Context, path string, loader px.DefiningLoader) {
+// with the given loader. The plugin executable is verified and its process is configured in accordance with the
+// given hierarchy entry.
+func (r *pluginRegistry) startPlugin(c px.Context, path string, he hieraapi.Entry, loader px.DefiningLoader) {
+	integrity := newPluginIntegrity(he)
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
-	var ok bool
-	var p *plugin
-	if r.plugins != nil {
-		p, ok = r.plugins[path]
-		if ok {
-			return
-		}
+	if r.verifyRunning(path, he, integrity) {
+		return
+	}
+
+	p := &plugin{
+		path:        path,
+		managed:     true,
+		integrity:   integrity,
+		procCfg:     he.PluginProcess(),
+		idleTimeout: getPluginIdleTimeout(c),
+		callbacks:   &r.callbacks}
+	env := []string{`HIERA_MAGIC_COOKIE=` + strconv.Itoa(hiera.MagicCookie)}
+	env = append(env, `HIERA_PLUGIN_SOCKET_DIR=`+getUnixSocketDir(c))
+	p.transport = getPluginTransport(c)
+	env = append(env, `HIERA_PLUGIN_TRANSPORT=`+p.transport)
+	p.env = pluginEnv(p.procCfg, env)
+	p.inst = p.start()
+
+	if r.plugins == nil {
+		r.plugins = make(map[string]*plugin)
+	}
+	r.plugins[path] = p
+
+	p.registerFunctions(c, loader)
+}
+
+// verifyRunning returns false when no plugin with the given path is registered. Otherwise, it verifies that the
+// registered plugin, which may have been loaded on behalf of another hierarchy entry, can serve the given entry and
+// returns true. The plugin must satisfy the integrity requirements of the entry, and a plugin process must have
+// been started with the process configuration of the entry since a running process cannot honor another one. The
+// registry must be locked.
+func (r *pluginRegistry) verifyRunning(path string, he hieraapi.Entry, integrity *pluginIntegrity) bool {
+	p, ok := r.plugins[path]
+	if !ok {
+		return false
+	}
+	integrity.verify(path, p.currentDigest())
+	if p.managed && !samePluginProcess(p.procCfg, he.PluginProcess()) {
+		panic(fmt.Errorf(`unable to start plugin %s for hierarchy entry '%s': the plugin is already running with a different plugin_process configuration`, path, he.Name()))
+	}
+	return true
+}
+
+// start will start a new instance of the plugin, await its meta-info, and initialize the plugin from it. The file
+// info of the plugin executable is recorded so that changes to it can be detected. The plugin must either be
+// locked or not yet be known to other Go routines.
+func (p *plugin) start() *pluginInstance {
+	path := p.path
+	fi, err := os.Stat(path)
+	if err != nil {
+		panic(fmt.Errorf(`unable to start plugin %s: %s`, path, err.Error()))
 	}
 
-	cmd := exec.Command(path)
-	cmd.Env = []string{`HIERA_MAGIC_COOKIE=` + strconv.Itoa(hiera.MagicCookie)}
-	cmd.Env = append(cmd.Env, `HIERA_PLUGIN_SOCKET_DIR=`+getUnixSocketDir(c))
-	cmd.Env = append(cmd.Env, `HIERA_PLUGIN_TRANSPORT=`+getPluginTransport(c))
+	// A plugin that must be verified is started from a private copy of the verified content
+	execPath := path
+	var digest, privateDir string
+	if p.integrity != nil {
+		execPath, digest = p.integrity.verifiedCopy(path)
+		privateDir = filepath.Dir(execPath)
+		defer func() {
+			if privateDir != `` {
+				_ = os.RemoveAll(privateDir)
+			}
+		}()
+	} else {
+		digest = fileDigest(path)
+	}
+
+	cmd := exec.Command(execPath)
+	cmd.Env = p.env
+	if err = configureCommand(cmd, p.procCfg); err != nil {
+		panic(err)
+	}
 
 	createPipe := func(name string, fn func() (io.ReadCloser, error)) io.ReadCloser {
 		pipe, err := fn()
@@ -127,8 +265,14 @@ func (r *pluginRegistry) startPlugin(c px.Context, path string, loader px.Defini
 	}
 
 	cmdErr := createPipe(`stderr`, cmd.StderrPipe)
-	cmdOut := createPipe(`stderr`, cmd.StdoutPipe)
-	err := cmd.Start()
+	cmdOut := createPipe(`stdout`, cmd.StdoutPipe)
+	var cmdIn io.WriteCloser
+	if p.transport == StdioTransport {
+		if cmdIn, err = cmd.StdinPipe(); err != nil {
+			panic(fmt.Errorf(`unable to create stdin pipe to plugin %s: %s`, path, err.Error()))
+		}
+	}
+	err = cmd.Start()
 	if err != nil {
 		panic(fmt.Errorf(`unable to start plugin %s: %s`, path, err.Error()))
 	}
@@ -144,13 +288,22 @@ func (r *pluginRegistry) startPlugin(c px.Context, path string, loader px.Defini
 		}
 	}()
 
-	p = &plugin{path: path, process: cmd.Process}
+	if err = limitProcess(cmd.Process, p.procCfg); err != nil {
+		panic(fmt.Errorf(`unable to start plugin %s: %s`, path, err.Error()))
+	}
+
+	inst := &pluginInstance{process: cmd.Process, modTime: fi.ModTime(), size: fi.Size()}
+	if runtime.GOOS == `windows` {
+		// The copy cannot be removed while it is executing
+		inst.privateDir = privateDir
+		privateDir = ``
+	}
 
 	// start a go routine that propagates everything written on the plugin's stderr to
 	// the StandardLogger of this process.
-	p.wGroup.Add(1)
+	inst.wGroup.Add(1)
 	go func() {
-		defer p.wGroup.Done()
+		defer inst.wGroup.Done()
 		out := log.StandardLogger().Out
 		reader := bufio.NewReaderSize(cmdErr, 0x10000)
 		for {
@@ -170,11 +323,11 @@ func (r *pluginRegistry) startPlugin(c px.Context, path string, loader px.Defini
 
 	// Start a go routine that awaits the initial meta-info from the plugin.
 	metaCh := make(chan interface{})
-	p.wGroup.Add(1)
+	dc := json.NewDecoder(cmdOut)
+	inst.wGroup.Add(1)
 	go func() {
-		defer p.wGroup.Done()
+		defer inst.wGroup.Done()
 		var meta map[string]interface{}
-		dc := json.NewDecoder(cmdOut)
 		err := dc.Decode(&meta)
 		if err != nil {
 			metaCh <- err
@@ -196,82 +349,367 @@ func (r *pluginRegistry) startPlugin(c px.Context, path string, loader px.Defini
 		meta = mv.(map[string]interface{})
 	}
 
-	// Ignore other stuff that is written on plugin's stdout
-	p.wGroup.Add(1)
-	go func() {
-		defer p.wGroup.Done()
-		toss := make([]byte, 0x1000)
-		for {
-			_, err := cmdOut.Read(toss)
-			if err == io.EOF {
-				return
+	if p.transport == StdioTransport {
+		// Responses to requests are read from the plugin's stdout
+		inst.stdio = newStdioClient(path, cmdIn)
+		inst.wGroup.Add(1)
+		go func() {
+			defer inst.wGroup.Done()
+			inst.stdio.readResponses(dc)
+		}()
+	} else {
+		// Ignore other stuff that is written on plugin's stdout
+		inst.wGroup.Add(1)
+		go func() {
+			defer inst.wGroup.Done()
+			toss := make([]byte, 0x1000)
+			for {
+				_, err := cmdOut.Read(toss)
+				if err == io.EOF {
+					return
+				}
 			}
+		}()
+	}
+	p.initialize(inst, meta)
+	p.digest = digest
+	return inst
+}
+
+// connectPlugin will connect to an externally managed plugin server at the given address, perform the meta-info
+// handshake, and register the functions that the server makes available with the given loader. The address must
+// be an URL using the scheme "unix" or "tcp", e.g. "unix:///run/plugin.sock" or "tcp://localhost:8100".
+func (r *pluginRegistry) connectPlugin(c px.Context, address string, loader px.DefiningLoader) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.plugins != nil {
+		if _, ok := r.plugins[address]; ok {
+			return
 		}
-	}()
+	}
+
+	au, err := url.Parse(address)
+	if err != nil {
+		panic(fmt.Errorf(`invalid plugin address %s: %s`, address, err.Error()))
+	}
+	p := &plugin{path: address, callbacks: &r.callbacks}
+	inst := &pluginInstance{network: au.Scheme}
+	switch au.Scheme {
+	case `unix`:
+		inst.addr = au.Path
+	case `tcp`:
+		inst.addr = au.Host
+	default:
+		panic(fmt.Errorf(`invalid plugin address %s: scheme must be unix or tcp`, address))
+	}
+	p.initializeFunctions(inst.fetchMeta(address))
+	if p.useCallbacks && inst.network == `tcp` && !isLoopback(inst.addr) {
+		panic(fmt.Errorf(`plugin %s declares that it uses callbacks but callbacks are only served on the loopback interface`, address))
+	}
+	p.inst = inst
+
 	if r.plugins == nil {
 		r.plugins = make(map[string]*plugin)
 	}
-	p.initialize(meta)
-	r.plugins[path] = p
-
+	r.plugins[address] = p
 	p.registerFunctions(c, loader)
 }
 
-func (p *plugin) kill() {
-	p.lock.Lock()
-	process := p.process
-	if process == nil {
-		return
+// isLoopback returns true if the host of the given "host:port" address is a loopback address or "localhost"
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == `localhost` {
+		return true
 	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
 
+// fetchMeta performs the meta-info handshake with the plugin server at the given address over HTTP.
+func (inst *pluginInstance) fetchMeta(address string) map[string]interface{} {
+	us := inst.pluginURL(`meta`).String()
+	resp, err := inst.httpClient().Get(us)
+	if err != nil {
+		panic(fmt.Errorf(`unable to connect to plugin %s: %s`, address, err.Error()))
+	}
 	defer func() {
-		p.wGroup.Wait()
-		p.process = nil
-		p.lock.Unlock()
+		_ = resp.Body.Close()
 	}()
+	if resp.StatusCode != http.StatusOK {
+		panic(fmt.Errorf(`unable to read meta data of plugin %s: %s %s`, address, us, resp.Status))
+	}
+	var meta map[string]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		panic(fmt.Errorf(`error reading meta data of plugin %s: %s`, address, err.Error()))
+	}
+	return meta
+}
 
-	// SIGINT on windows will fail
-	graceful := true
-	if err := process.Signal(syscall.SIGINT); err != nil {
-		graceful = false
+// kill stops the current instance of the plugin regardless of calls in flight
+func (p *plugin) kill() {
+	p.lock.Lock()
+	if p.idleTimer != nil {
+		p.idleTimer.Stop()
+		p.idleTimer = nil
+	}
+	inst := p.inst
+	p.inst = nil
+	if inst != nil {
+		inst.retired = true
 	}
+	p.lock.Unlock()
+	if inst != nil {
+		inst.stop()
+	}
+}
 
-	if graceful {
-		done := make(chan bool)
-		go func() {
-			_, _ = process.Wait()
-			done <- true
+// stop stops the process of this instance or closes its WebAssembly module. The stop is performed at most once. An
+// instance that has neither is an externally managed plugin server.
+func (inst *pluginInstance) stop() {
+	inst.stopOnce.Do(func() {
+		if inst.wasm != nil {
+			inst.wasm.close()
+			return
+		}
+		process := inst.process
+		if process == nil {
+			return
+		}
+		defer func() {
+			inst.wGroup.Wait()
+			if inst.privateDir != `` {
+				_ = os.RemoveAll(inst.privateDir)
+			}
 		}()
-		select {
-		case <-done:
-		case <-time.After(time.Second * 3):
+
+		if inst.stdio != nil {
+			// A plugin using the stdio transport can terminate when it detects that its stdin is closed
+			inst.stdio.close()
+		}
+
+		// SIGINT on windows will fail
+		graceful := true
+		if err := process.Signal(syscall.SIGINT); err != nil {
+			graceful = false
+		}
+
+		if graceful {
+			done := make(chan bool)
+			go func() {
+				_, _ = process.Wait()
+				done <- true
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second * 3):
+				_ = process.Kill()
+			}
+		} else {
+			// Windows. Just kill it!
 			_ = process.Kill()
 		}
-	} else {
-		// Windows. Just kill it!
-		_ = process.Kill()
+	})
+}
+
+// acquire must be called before each call to the plugin and each call to acquire must be followed by a call to
+// release with the returned instance once the call has completed. The plugin is not locked during the call. The
+// acquire will restart a managed plugin that has been stopped because it was idle. When the executable of a
+// managed plugin has changed on disk, a new instance is started and used by all subsequent calls. The old instance
+// is stopped once all calls that use it have completed.
+func (p *plugin) acquire() *pluginInstance {
+	var old *pluginInstance
+	defer func() {
+		// Runs after the plugin has been unlocked
+		if old != nil {
+			old.stop()
+		}
+	}()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.managed {
+		if inst := p.inst; inst != nil && p.binaryChanged(inst) {
+			log.Infof(`plugin %s has changed on disk, restarting`, p.path)
+			inst.retired = true
+			if inst.refs == 0 {
+				old = inst
+			}
+			p.inst = nil
+		}
+		if p.inst == nil {
+			p.inst = p.start()
+		}
+	}
+	inst := p.inst
+	if inst == nil {
+		panic(fmt.Errorf(`plugin %s has been stopped`, p.path))
 	}
+	inst.refs++
+	return inst
 }
 
-// initialize the plugin with the given meta-data
-func (p *plugin) initialize(meta map[string]interface{}) {
-	v, ok := meta[`version`].(float64)
-	if !(ok && int(v) == hiera.ProtoVersion) {
-		panic(fmt.Errorf(`plugin %s uses unsupported protocol %v`, p.path, v))
+// release must be called with the instance returned by acquire once the call has completed. A retired instance
+// is stopped when its last call completes.
+func (p *plugin) release(inst *pluginInstance) {
+	p.lock.Lock()
+	inst.refs--
+	stop := inst.retired && inst.refs == 0
+	if !inst.retired && p.idleTimeout > 0 {
+		p.lastUsed = timeNow()
+		if p.idleTimer == nil {
+			p.idleTimer = afterFunc(p.idleTimeout, p.stopIfIdle)
+		}
+	}
+	p.lock.Unlock()
+
+	if stop {
+		inst.stop()
+	}
+}
+
+// stopIfIdle stops the current instance of the plugin if it has not been used during the idle timeout. If it
+// has been used, or if calls are in flight, a new check is scheduled.
+func (p *plugin) stopIfIdle() {
+	p.lock.Lock()
+	inst := p.inst
+	if inst == nil {
+		p.idleTimer = nil
+		p.lock.Unlock()
+		return
+	}
+	idle := timeNow().Sub(p.lastUsed)
+	if inst.refs > 0 || idle < p.idleTimeout {
+		next := p.idleTimeout
+		if inst.refs == 0 {
+			next -= idle
+		}
+		p.idleTimer = afterFunc(next, p.stopIfIdle)
+		p.lock.Unlock()
+		return
+	}
+	p.idleTimer = nil
+	p.inst = nil
+	inst.retired = true
+	p.lock.Unlock()
+
+	log.Debugf(`plugin %s has been idle for %s, stopping`, p.path, idle)
+	inst.stop()
+}
+
+// currentDigest returns the digest of the plugin executable at the time when the current instance was started
+func (p *plugin) currentDigest() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.digest
+}
+
+// binaryChanged returns true if the plugin executable has changed since the given instance was started. The size
+// and modification time are checked first and the digest of the file is only computed when one of them differs.
+// The plugin must be locked.
+func (p *plugin) binaryChanged(inst *pluginInstance) bool {
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		// Executable is gone. Keep using the running process
+		return false
 	}
-	p.addr, ok = meta[`address`].(string)
+	if fi.Size() == inst.size && fi.ModTime().Equal(inst.modTime) {
+		return false
+	}
+	if fileDigest(p.path) == p.digest {
+		// Touched but not changed.
+		inst.modTime = fi.ModTime()
+		return false
+	}
+	return true
+}
+
+// fileDigest returns the hex encoded SHA-256 digest of the file at the given path or an empty string if
+// the file cannot be read.
+func fileDigest(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ``
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return ``
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// initialize the plugin and the given instance with the given meta-data
+func (p *plugin) initialize(inst *pluginInstance, meta map[string]interface{}) {
+	var ok bool
+	if inst.stdio != nil {
+		inst.network = StdioTransport
+		p.initializeFunctions(meta)
+		return
+	}
+	inst.addr, ok = meta[`address`].(string)
 	if !ok {
 		panic(fmt.Errorf(`plugin %s did not provide a valid address`, p.path))
 	}
-	p.network, ok = meta[`network`].(string)
+	inst.network, ok = meta[`network`].(string)
 	if !ok {
 		log.Printf(`plugin %s did not provide a valid network, assuming tcp`, p.path)
-		p.network = `tcp`
+		inst.network = `tcp`
+	}
+	p.initializeFunctions(meta)
+}
+
+// initializeFunctions validates the protocol version found in the given meta-data and initializes the
+// functions that the plugin makes available. The functions of a plugin are registered only once, so a restarted
+// plugin must provide the same functions and make the same use of callbacks as before.
+func (p *plugin) initializeFunctions(meta map[string]interface{}) {
+	v, ok := meta[`version`].(float64)
+	if !(ok && int(v) == hiera.ProtoVersion) {
+		panic(fmt.Errorf(`plugin %s uses unsupported protocol %v`, p.path, v))
 	}
-	p.functions, ok = meta[`functions`].(map[string]interface{})
+	functions, ok := meta[`functions`].(map[string]interface{})
 	if !ok {
 		panic(fmt.Errorf(`plugin %s did not provide a valid functions map`, p.path))
 	}
+	useCallbacks, _ := meta[`callbacks`].(bool)
+	if p.functions != nil {
+		if !sameFunctions(p.functions, functions) || useCallbacks != p.useCallbacks {
+			panic(fmt.Errorf(`plugin %s provides other functions than before it was restarted. Hiera must be restarted to use them`, p.path))
+		}
+		return
+	}
+	p.functions = functions
+	p.useCallbacks = useCallbacks
+}
+
+// sameFunctions returns true if the two functions maps of plugin meta-info contain the same names of each type
+func sameFunctions(a, b map[string]interface{}) bool {
+	names := func(fm map[string]interface{}) map[string]bool {
+		ns := make(map[string]bool)
+		for k, v := range fm {
+			vs, _ := v.([]interface{})
+			for _, n := range vs {
+				ns[k+`/`+fmt.Sprint(n)] = true
+			}
+		}
+		return ns
+	}
+	an := names(a)
+	bn := names(b)
+	if len(an) != len(bn) {
+		return false
+	}
+	for n := range an {
+		if !bn[n] {
+			return false
+		}
+	}
+	return true
 }
 
 type luDispatch func(string) px.DispatchCreator
@@ -281,22 +719,50 @@ func (p *plugin) registerFunctions(c px.Context, loader px.DefiningLoader) {
 	for k, v := range p.functions {
 		names := v.([]interface{})
 		var df luDispatch
+		suffix := ``
 		switch k {
 		case `data_dig`:
 			df = p.dataDigDispatch
 		case `data_hash`:
 			df = p.dataHashDispatch
+		case `list_keys`:
+			df = p.listKeysDispatch
+			suffix = listKeysSuffix
 		default:
 			df = p.lookupKeyDispatch
 		}
 		for _, x := range names {
 			n := x.(string)
-			f := px.BuildFunction(n, nil, []px.DispatchCreator{df(n)})
-			loader.SetEntry(px.NewTypedName(px.NsFunction, n), px.NewLoaderEntry(f.Resolve(c), nil))
+			f := px.BuildFunction(n+suffix, nil, []px.DispatchCreator{df(n)})
+			loader.SetEntry(px.NewTypedName(px.NsFunction, n+suffix), px.NewLoaderEntry(f.Resolve(c), nil))
 		}
 	}
 }
 
+// listKeysSuffix is appended to the name of a plugin function to form the name of the function that lists its keys
+const listKeysSuffix = `__list_keys`
+
+// listKeysDispatch creates the dispatch of a function that lists the keys of the plugin function with the given
+// name. Such functions are advertised in the "list_keys" entry of the "functions" in the meta-info of the plugin.
+func (p *plugin) listKeysDispatch(name string) px.DispatchCreator {
+	return func(d px.Dispatch) {
+		d.Param(`Hiera::Context`)
+		d.Function(func(c px.Context, args []px.Value) px.Value {
+			sc := args[0].(hieraapi.ServerContext)
+			return p.callPlugin(sc, `list_keys`, name, makeOptions(sc))
+		})
+	}
+}
+
+// loadKeyListerFunction returns the function that lists the keys of the plugin function with the given name. The
+// plugin must already be loaded.
+func loadKeyListerFunction(c px.Context, n string) (px.Function, bool) {
+	if f, ok := px.Load(c, px.NewTypedName(px.NsFunction, n+listKeysSuffix)); ok {
+		return f.(px.Function), true
+	}
+	return nil, false
+}
+
 func (p *plugin) dataDigDispatch(name string) px.DispatchCreator {
 	return func(d px.Dispatch) {
 		d.Param(`Hiera::Context`)
@@ -309,7 +775,7 @@ func (p *plugin) dataDigDispatch(name string) px.DispatchCreator {
 				panic(err)
 			}
 			params.Add(`key`, string(jp))
-			return p.callPlugin(`data_dig`, name, params)
+			return p.callPlugin(args[0].(hieraapi.ServerContext), `data_dig`, name, params)
 		})
 	}
 }
@@ -318,7 +784,8 @@ func (p *plugin) dataHashDispatch(name string) px.DispatchCreator {
 	return func(d px.Dispatch) {
 		d.Param(`Hiera::Context`)
 		d.Function(func(c px.Context, args []px.Value) px.Value {
-			return p.callPlugin(`data_hash`, name, makeOptions(args[0].(hieraapi.ServerContext)))
+			sc := args[0].(hieraapi.ServerContext)
+			return p.callPlugin(sc, `data_hash`, name, makeOptions(sc))
 		})
 	}
 }
@@ -328,9 +795,10 @@ func (p *plugin) lookupKeyDispatch(name string) px.DispatchCreator {
 		d.Param(`Hiera::Context`)
 		d.Param(`String`)
 		d.Function(func(c px.Context, args []px.Value) px.Value {
-			params := makeOptions(args[0].(hieraapi.ServerContext))
+			sc := args[0].(hieraapi.ServerContext)
+			params := makeOptions(sc)
 			params.Add(`key`, args[1].String())
-			return p.callPlugin(`lookup_key`, name, params)
+			return p.callPlugin(sc, `lookup_key`, name, params)
 		})
 	}
 }
@@ -349,64 +817,131 @@ func makeOptions(sc hieraapi.ServerContext) url.Values {
 	return params
 }
 
-func (p *plugin) callPlugin(luType, name string, params url.Values) px.Value {
+// httpClient returns a client that dials the address and network of this instance
+func (inst *pluginInstance) httpClient() *http.Client {
+	return &http.Client{
+		Timeout: time.Duration(time.Second * 5),
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial(inst.network, inst.addr)
+			},
+		},
+	}
+}
+
+// pluginURL returns the URL for the given path on the plugin server
+func (inst *pluginInstance) pluginURL(path string) *url.URL {
 	var ad *url.URL
 	var err error
 
-	if p.network == "unix" {
-		ad, err = url.Parse(fmt.Sprintf(`http://%s/%s/%s`, p.network, luType, name))
+	if inst.network == "unix" {
+		ad, err = url.Parse(fmt.Sprintf(`http://%s/%s`, inst.network, path))
 	} else {
-		ad, err = url.Parse(fmt.Sprintf(`http://%s/%s/%s`, p.addr, luType, name))
+		ad, err = url.Parse(fmt.Sprintf(`http://%s/%s`, inst.addr, path))
 	}
 	if err != nil {
 		panic(err)
 	}
-	if len(params) > 0 {
-		ad.RawQuery = params.Encode()
+	return ad
+}
+
+// callPlugin calls the function with the given type and name in the plugin. Callback requests from the plugin
+// are served by the calling Go routine while the call is in progress, and explain messages in the response are
+// forwarded to the explainer of the given context.
+func (p *plugin) callPlugin(sc hieraapi.ServerContext, luType, name string, params url.Values) px.Value {
+	inst := p.acquire()
+	defer p.release(inst)
+
+	// A session is only opened for plugins that have declared that they use callbacks. The nil requests channel
+	// of an absent session blocks forever.
+	var requests chan *callbackRequest
+	if p.useCallbacks {
+		session := p.callbacks.open()
+		defer p.callbacks.close(session)
+		params.Set(CallbackParam, session.url)
+		requests = session.requests
 	}
-	us := ad.String()
-	client := http.Client{
-		Timeout: time.Duration(time.Second * 5),
-		Transport: &http.Transport{
-			Dial: func(_, _ string) (net.Conn, error) {
-				return net.Dial(p.network, p.addr)
-			},
-		},
+
+	done := make(chan *pluginResponse, 1)
+	go func() {
+		switch {
+		case inst.wasm != nil:
+			done <- p.sendWasm(inst, luType, name, params)
+		case inst.stdio != nil:
+			done <- p.sendStdio(inst, luType, name, params)
+		default:
+			done <- inst.sendHTTP(luType, name, params)
+		}
+	}()
+
+	var resp *pluginResponse
+	for resp == nil {
+		select {
+		case rq := <-requests:
+			rq.reply <- serveCallback(sc.Invocation().(*invocation), rq)
+		case resp = <-done:
+		}
 	}
-	resp, err := client.Get(us)
-	if err != nil {
-		log.Error(err.Error())
+
+	if resp.err != nil {
+		log.Error(resp.err.Error())
 		return nil
 	}
-
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-	switch resp.StatusCode {
+	for _, m := range resp.explain {
+		msg := m
+		sc.Explain(func() string { return msg })
+	}
+	switch resp.status {
 	case http.StatusOK:
 		vc := px.NewCollector()
-		serialization.JsonToData(us, resp.Body, vc)
+		serialization.JsonToData(resp.source, bytes.NewReader(resp.body), vc)
 		return vc.Value()
 	case http.StatusNotFound:
 		return nil
 	default:
-		var bts []byte
-		if bts, err = ioutil.ReadAll(resp.Body); err == nil {
-			err = fmt.Errorf(`%s %s: %s`, us, resp.Status, string(bts))
-		} else {
-			err = fmt.Errorf(`%s %s`, us, resp.Status)
+		if len(resp.body) > 0 {
+			panic(fmt.Errorf(`%s %d %s: %s`, resp.source, resp.status, http.StatusText(resp.status), string(resp.body)))
 		}
-		panic(err)
+		panic(fmt.Errorf(`%s %d %s`, resp.source, resp.status, http.StatusText(resp.status)))
 	}
 }
 
+// pluginResponse is the transport independent response from a plugin call
+type pluginResponse struct {
+	source  string
+	status  int
+	body    []byte
+	explain []string
+	err     error
+}
+
+// sendHTTP performs a call to a plugin instance that uses a unix or tcp socket
+func (inst *pluginInstance) sendHTTP(luType, name string, params url.Values) *pluginResponse {
+	ad := inst.pluginURL(luType + `/` + name)
+	if len(params) > 0 {
+		ad.RawQuery = params.Encode()
+	}
+	us := ad.String()
+	client := inst.httpClient()
+	resp, err := client.Get(us)
+	if err != nil {
+		return &pluginResponse{source: us, err: err}
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	bts, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &pluginResponse{source: us, err: err}
+	}
+	return &pluginResponse{source: us, status: resp.StatusCode, body: bts, explain: resp.Header[ExplainHeader]}
+}
+
 func (l *pluginLoader) LoadEntry(c px.Context, name px.TypedName) px.LoaderEntry {
 	entry := l.DefiningLoader.LoadEntry(c, name)
-	if entry != nil {
-		return entry
-	}
 	if name.Namespace() != px.NsFunction {
-		return nil
+		return entry
 	}
 
 	// Get the plugin registry for this session
@@ -414,13 +949,24 @@ func (l *pluginLoader) LoadEntry(c px.Context, name px.TypedName) px.LoaderEntry
 	if pr, ok := c.Get(hieraPluginRegistry); ok {
 		allPlugins = pr.(*pluginRegistry)
 	} else {
-		return nil
+		return entry
+	}
+
+	pl := l.DefiningLoader.(px.ParentedLoader).Parent()
+	if addr := l.he.PluginAddress(); addr != `` {
+		if entry != nil {
+			return entry
+		}
+		allPlugins.connectPlugin(c, addr, pl.(px.DefiningLoader))
+		return pl.LoadEntry(c, name)
 	}
 
 	file := l.he.PluginFile()
 	if file == `` {
 		file = name.Name()
-		if runtime.GOOS == `windows` {
+		if fi, err := os.Stat(filepath.Join(l.he.PluginDir(), file+WasmExtension)); err == nil && !fi.IsDir() {
+			file += WasmExtension
+		} else if runtime.GOOS == `windows` {
 			file += `.exe`
 		}
 	}
@@ -436,8 +982,18 @@ func (l *pluginLoader) LoadEntry(c px.Context, name px.TypedName) px.LoaderEntry
 		}
 		path = abs
 	}
-	pl := l.DefiningLoader.(px.ParentedLoader).Parent()
-	allPlugins.startPlugin(c, path, pl.(px.DefiningLoader))
+	if entry != nil {
+		// The function was loaded on behalf of another hierarchy entry, possibly from the same plugin
+		allPlugins.lock.Lock()
+		defer allPlugins.lock.Unlock()
+		allPlugins.verifyRunning(path, l.he, newPluginIntegrity(l.he))
+		return entry
+	}
+	if strings.HasSuffix(path, WasmExtension) {
+		allPlugins.loadWasmPlugin(c, path, l.he, pl.(px.DefiningLoader))
+	} else {
+		allPlugins.startPlugin(c, path, l.he, pl.(px.DefiningLoader))
+	}
 	return pl.LoadEntry(c, name)
 }
 
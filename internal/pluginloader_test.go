@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/hierasdk/hiera"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTimer records the function of a scheduled idle check so that a test can run it
+type fakeTimer struct {
+	f func()
+}
+
+func (ft *fakeTimer) Stop() bool {
+	return true
+}
+
+// withFakeClock replaces the clock and the timers used by idle checks during the call to the given function.
+// The function is passed a func that advances the clock and runs the last scheduled check.
+func withFakeClock(t *testing.T, f func(advance func(time.Duration))) {
+	now := time.Now()
+	var timer *fakeTimer
+	savedAfterFunc, savedTimeNow := afterFunc, timeNow
+	defer func() {
+		afterFunc, timeNow = savedAfterFunc, savedTimeNow
+	}()
+	afterFunc = func(_ time.Duration, f func()) stopper {
+		timer = &fakeTimer{f}
+		return timer
+	}
+	timeNow = func() time.Time {
+		return now
+	}
+	f(func(d time.Duration) {
+		now = now.Add(d)
+		require.NotNil(t, timer, `no idle check has been scheduled`)
+		ft := timer
+		timer = nil
+		ft.f()
+	})
+}
+
+// buildTestPlugin builds the test plugin of the lookup package into the given directory
+func buildTestPlugin(t *testing.T, dir string) string {
+	t.Helper()
+	pe := filepath.Join(dir, `hieratestplugin`)
+	if runtime.GOOS == `windows` {
+		pe += `.exe`
+	}
+	cmd := exec.Command(`go`, `build`, `-o`, pe, filepath.Join(`..`, `lookup`, `testdata`, `hieratestplugin`, `hieratestplugin.go`))
+	cmd.Stderr = os.Stderr
+	require.NoError(t, cmd.Run())
+	return pe
+}
+
+func newTestPlugin(path string) *plugin {
+	p := &plugin{
+		path:        path,
+		managed:     true,
+		transport:   `unix`,
+		procCfg:     &hieraapi.PluginProcess{},
+		idleTimeout: time.Minute,
+		callbacks:   &pluginCallbacks{},
+		env: []string{
+			`HIERA_MAGIC_COOKIE=` + strconv.Itoa(hiera.MagicCookie),
+			`HIERA_PLUGIN_SOCKET_DIR=` + DefaultUnixSocketDir,
+			`HIERA_PLUGIN_TRANSPORT=unix`}}
+	p.inst = p.start()
+	return p
+}
+
+func TestPlugin_idleTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `hiera`)
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	p := newTestPlugin(buildTestPlugin(t, dir))
+	defer p.kill()
+
+	withFakeClock(t, func(advance func(time.Duration)) {
+		inst := p.acquire()
+		p.release(inst)
+
+		// Used 30 seconds ago. The check is rescheduled
+		advance(30 * time.Second)
+		require.Equal(t, inst, p.inst)
+
+		// A call in flight keeps the plugin alive
+		require.Equal(t, inst, p.acquire())
+		advance(2 * time.Minute)
+		require.Equal(t, inst, p.inst)
+		p.release(inst)
+
+		advance(2 * time.Minute)
+		require.Nil(t, p.inst)
+		require.True(t, inst.retired)
+
+		// The next call restarts the plugin
+		inst2 := p.acquire()
+		p.release(inst2)
+		require.NotEqual(t, inst.process.Pid, inst2.process.Pid)
+	})
+}
+
+func TestPlugin_binaryChanged(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `hiera`)
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := buildTestPlugin(t, dir)
+	p := newTestPlugin(path)
+	defer p.kill()
+
+	inst := p.acquire()
+
+	// Redeploy the executable. Trailing bytes change the digest but not the behavior of the executable
+	bs, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path+`.new`, append(bs, 0), 0755))
+	require.NoError(t, os.Rename(path+`.new`, path))
+
+	// A new instance is started while the call that uses the old instance is in flight
+	inst2 := p.acquire()
+	require.NotEqual(t, inst, inst2)
+	require.True(t, inst.retired)
+	require.NoError(t, inst.process.Signal(syscall.Signal(0)))
+	p.release(inst2)
+
+	// The old instance is stopped when its last call completes
+	p.release(inst)
+	require.Error(t, inst.process.Signal(syscall.Signal(0)))
+}
+
+func TestPlugin_binaryChangedFunctions(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `hiera`)
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := buildTestPlugin(t, dir)
+	p := newTestPlugin(path)
+	defer p.kill()
+
+	// The redeployed plugin registers one more function
+	p.env = append(p.env, `HIERA_TEST_EXTRA_FUNCTION=yes`)
+	bs, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path+`.new`, append(bs, 0), 0755))
+	require.NoError(t, os.Rename(path+`.new`, path))
+
+	err = func() (err error) {
+		defer func() {
+			err = recover().(error)
+		}()
+		p.acquire()
+		return nil
+	}()
+	require.EqualError(t, err, fmt.Sprintf(`plugin %s provides other functions than before it was restarted. Hiera must be restarted to use them`, path))
+	require.Nil(t, p.inst)
+}
+
+func TestIsLoopback(t *testing.T) {
+	require.True(t, isLoopback(`127.0.0.1:8100`))
+	require.True(t, isLoopback(`[::1]:8100`))
+	require.True(t, isLoopback(`localhost:8100`))
+	require.False(t, isLoopback(`10.0.0.1:8100`))
+	require.False(t, isLoopback(`plugins.example.com:8100`))
+}
+
+// blockingModule is a WebAssembly module whose calls block until released
+type blockingModule struct {
+	called  chan bool
+	release chan bool
+	closed  bool
+}
+
+func (m *blockingModule) Meta() ([]byte, error) {
+	return []byte(`{"version":1,"functions":{"lookup_key":["blocking"]}}`), nil
+}
+
+func (m *blockingModule) Call(_ []byte) ([]byte, error) {
+	m.called <- true
+	<-m.release
+	return []byte(`{"status":404}`), nil
+}
+
+func (m *blockingModule) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestPlugin_killWasmDuringCall(t *testing.T) {
+	m := &blockingModule{called: make(chan bool), release: make(chan bool)}
+	p := &plugin{path: `blocking.wasm`, inst: &pluginInstance{network: `wasm`, wasm: &wasmModule{module: m}}}
+
+	inst := p.acquire()
+	done := make(chan *pluginResponse)
+	go func() {
+		done <- p.sendWasm(inst, `lookup_key`, `blocking`, url.Values{})
+	}()
+	<-m.called
+
+	// The module is closed once the call in flight has completed
+	killed := make(chan bool)
+	go func() {
+		p.kill()
+		killed <- true
+	}()
+	m.release <- true
+	require.Equal(t, http.StatusNotFound, (<-done).status)
+	<-killed
+	p.release(inst)
+	require.True(t, m.closed)
+	require.Nil(t, p.inst)
+}
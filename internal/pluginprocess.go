@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/lyraproj/hiera/hieraapi"
+)
+
+// pluginEnv returns the environment for a plugin process. The given hieraEnv is always included and takes
+// precedence over variables that are passed through from the environment of Hiera or set explicitly in the
+// given process configuration.
+func pluginEnv(pp *hieraapi.PluginProcess, hieraEnv []string) []string {
+	if pp == nil {
+		return hieraEnv
+	}
+	var env []string
+	if pp.PassthroughAll {
+		env = os.Environ()
+	} else {
+		for _, n := range pp.Passthrough {
+			if v, ok := os.LookupEnv(n); ok {
+				env = append(env, n+`=`+v)
+			}
+		}
+	}
+	for k, v := range pp.Env {
+		env = append(env, k+`=`+v)
+	}
+	for _, hv := range hieraEnv {
+		n := hv[:strings.IndexByte(hv, '=')+1]
+		for i := 0; i < len(env); {
+			if strings.HasPrefix(env[i], n) {
+				env = append(env[:i], env[i+1:]...)
+			} else {
+				i++
+			}
+		}
+	}
+	return append(env, hieraEnv...)
+}
+
+// samePluginProcess returns true when the given process configurations are equal. A nil configuration is equal
+// to an empty one.
+func samePluginProcess(a, b *hieraapi.PluginProcess) bool {
+	if a == nil {
+		a = &hieraapi.PluginProcess{}
+	}
+	if b == nil {
+		b = &hieraapi.PluginProcess{}
+	}
+	if len(a.Env) != len(b.Env) || len(a.Passthrough) != len(b.Passthrough) {
+		return false
+	}
+	for k, v := range a.Env {
+		if bv, ok := b.Env[k]; !ok || bv != v {
+			return false
+		}
+	}
+	for i, n := range a.Passthrough {
+		if b.Passthrough[i] != n {
+			return false
+		}
+	}
+	return a.PassthroughAll == b.PassthroughAll && a.WorkDir == b.WorkDir && a.User == b.User &&
+		a.CPUSeconds == b.CPUSeconds && a.MemoryBytes == b.MemoryBytes && a.OpenFiles == b.OpenFiles
+}
+
+// configureCommand applies the working directory and the platform specific settings of the given process
+// configuration to the given command.
+func configureCommand(cmd *exec.Cmd, pp *hieraapi.PluginProcess) error {
+	if pp == nil {
+		return nil
+	}
+	if pp.WorkDir != `` {
+		cmd.Dir = pp.WorkDir
+	}
+	return configurePlatform(cmd, pp)
+}
+
+// limitProcess applies the resource limits of the given process configuration to the started plugin process.
+func limitProcess(process *os.Process, pp *hieraapi.PluginProcess) error {
+	if pp == nil || pp.CPUSeconds == 0 && pp.MemoryBytes == 0 && pp.OpenFiles == 0 {
+		return nil
+	}
+	return limitPlatform(process, pp)
+}
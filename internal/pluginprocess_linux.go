@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"golang.org/x/sys/unix"
+)
+
+// configurePlatform makes the command run as the configured user. Hiera must have the capability to change user
+// for the plugin to start.
+func configurePlatform(cmd *exec.Cmd, pp *hieraapi.PluginProcess) error {
+	if pp.User == `` {
+		return nil
+	}
+	var u *user.User
+	var err error
+	if _, nerr := strconv.Atoi(pp.User); nerr == nil {
+		u, err = user.LookupId(pp.User)
+	} else {
+		u, err = user.Lookup(pp.User)
+	}
+	if err != nil {
+		return fmt.Errorf(`unable to run plugin %s as user %s: %s`, cmd.Path, pp.User, err.Error())
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf(`unable to run plugin %s as user %s: invalid uid %s`, cmd.Path, pp.User, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return fmt.Errorf(`unable to run plugin %s as user %s: invalid gid %s`, cmd.Path, pp.User, u.Gid)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}}
+	return nil
+}
+
+// limitPlatform applies the configured resource limits to the started plugin process using prlimit(2). Hiera
+// must have the capability to change the limits of the process when it runs as another user.
+//
+// Go cannot set resource limits between fork and exec, so the limits are applied once the process has started. The
+// plugin runs without them for that short window, which means that the limits bound the plugin as it serves
+// requests but not the first instructions of its startup.
+func limitPlatform(process *os.Process, pp *hieraapi.PluginProcess) error {
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{unix.RLIMIT_CPU, pp.CPUSeconds},
+		{unix.RLIMIT_AS, pp.MemoryBytes},
+		{unix.RLIMIT_NOFILE, pp.OpenFiles},
+	}
+	for _, l := range limits {
+		if l.value == 0 {
+			continue
+		}
+		if err := unix.Prlimit(process.Pid, l.resource, &unix.Rlimit{Cur: l.value, Max: l.value}, nil); err != nil {
+			return fmt.Errorf(`unable to apply resource limits: %s`, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/lyraproj/hiera/hieraapi"
+)
+
+func configurePlatform(cmd *exec.Cmd, pp *hieraapi.PluginProcess) error {
+	if pp.User != `` {
+		return fmt.Errorf(`unable to run plugin %s as user %s: not supported on this platform`, cmd.Path, pp.User)
+	}
+	if pp.CPUSeconds > 0 || pp.MemoryBytes > 0 || pp.OpenFiles > 0 {
+		return fmt.Errorf(`unable to apply resource limits to plugin %s: not supported on this platform`, cmd.Path)
+	}
+	return nil
+}
+
+func limitPlatform(_ *os.Process, _ *hieraapi.PluginProcess) error {
+	return nil
+}
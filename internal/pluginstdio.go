@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// StdioTransport is the plugin transport where requests are written as JSON objects, one per line, on the
+// plugin's stdin and responses are read from the plugin's stdout.
+const StdioTransport = `stdio`
+
+// stdioRequest is written by Hiera on the stdin of a plugin that uses the stdio transport. The method is
+// the same as the path used with the HTTP transport, e.g. "lookup_key/my_function", and the params are the
+// same as the query parameters.
+type stdioRequest struct {
+	ID     int64             `json:"id"`
+	Method string            `json:"method"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// stdioResponse is written by the plugin on its stdout in response to a stdioRequest with the same id. The
+// status has the same semantics as a HTTP status code, i.e. 200 means that the result is valid, 404 that
+// no value was found, and everything else that an error occurred.
+type stdioResponse struct {
+	ID      int64           `json:"id"`
+	Status  int             `json:"status"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Explain []string        `json:"explain,omitempty"`
+}
+
+// stdioClient multiplexes requests to a plugin over its stdin and stdout
+type stdioClient struct {
+	path    string
+	lock    sync.Mutex
+	nextID  int64
+	encoder *json.Encoder
+	stdin   io.Closer
+	pending map[int64]chan *stdioResponse
+	err     error
+}
+
+func newStdioClient(path string, stdin io.WriteCloser) *stdioClient {
+	return &stdioClient{path: path, encoder: json.NewEncoder(stdin), stdin: stdin, pending: make(map[int64]chan *stdioResponse)}
+}
+
+// readResponses reads responses using the given decoder and dispatches them to the pending calls until the
+// decoder returns an error. All pending calls will then fail.
+func (sc *stdioClient) readResponses(dc *json.Decoder) {
+	for {
+		resp := &stdioResponse{}
+		if err := dc.Decode(resp); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf(`plugin %s closed its stdout`, sc.path)
+			}
+			sc.lock.Lock()
+			sc.err = err
+			for id, ch := range sc.pending {
+				delete(sc.pending, id)
+				close(ch)
+			}
+			sc.lock.Unlock()
+			return
+		}
+		sc.lock.Lock()
+		ch, ok := sc.pending[resp.ID]
+		delete(sc.pending, resp.ID)
+		sc.lock.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// call sends a request for the given method and params and waits for the response.
+func (sc *stdioClient) call(method string, params url.Values) (*stdioResponse, error) {
+	ch := make(chan *stdioResponse, 1)
+	sc.lock.Lock()
+	if sc.err != nil {
+		sc.lock.Unlock()
+		return nil, sc.err
+	}
+	sc.nextID++
+	req := &stdioRequest{ID: sc.nextID, Method: method}
+	if len(params) > 0 {
+		req.Params = make(map[string]string, len(params))
+		for k := range params {
+			req.Params[k] = params.Get(k)
+		}
+	}
+	sc.pending[req.ID] = ch
+	err := sc.encoder.Encode(req)
+	if err != nil {
+		delete(sc.pending, req.ID)
+	}
+	sc.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			sc.lock.Lock()
+			err = sc.err
+			sc.lock.Unlock()
+			return nil, err
+		}
+		return resp, nil
+	case <-time.After(time.Second * 5):
+		sc.lock.Lock()
+		delete(sc.pending, req.ID)
+		sc.lock.Unlock()
+		return nil, fmt.Errorf(`timeout while waiting for plugin %s to respond to %s`, sc.path, method)
+	}
+}
+
+// close closes the stdin of the plugin.
+func (sc *stdioClient) close() {
+	_ = sc.stdin.Close()
+}
+
+// sendStdio performs a call to a plugin instance that uses the stdio transport
+func (p *plugin) sendStdio(inst *pluginInstance, luType, name string, params url.Values) *pluginResponse {
+	method := luType + `/` + name
+	source := p.path + ` ` + method
+	resp, err := inst.stdio.call(method, params)
+	if err != nil {
+		return &pluginResponse{source: source, err: err}
+	}
+	return resp.pluginResponse(source)
+}
+
+// pluginResponse converts this response into a transport independent response
+func (resp *stdioResponse) pluginResponse(source string) *pluginResponse {
+	pr := &pluginResponse{source: source, status: resp.Status, explain: resp.Explain}
+	if resp.Status == http.StatusOK {
+		pr.body = resp.Result
+	} else {
+		pr.body = []byte(resp.Error)
+	}
+	return pr
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/pcore/px"
+)
+
+// WasmExtension is the file extension of WebAssembly plugins
+const WasmExtension = `.wasm`
+
+// wasmModule serializes the calls to a WebAssembly module since a module instance is single threaded
+type wasmModule struct {
+	lock   sync.Mutex
+	module hieraapi.WasmModule
+}
+
+// getWasmLimits resolves the values of wasmMemoryLimit and wasmCallLimit
+func getWasmLimits(c px.Context) hieraapi.WasmLimits {
+	limit := func(key string) uint64 {
+		v := extractOptFromContext(c, key)
+		if v == `` {
+			return 0
+		}
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			panic(fmt.Errorf(`invalid %s %s: %s`, key, v, err.Error()))
+		}
+		return n
+	}
+	return hieraapi.WasmLimits{MemoryBytes: limit(`wasmMemoryLimit`), Calls: limit(`wasmCallLimit`)}
+}
+
+// loadWasmPlugin will load the WebAssembly module at the given path using the registered runtime, or the bundled
+// runtime if none has been registered, and register the functions that it makes available with the given loader.
+// The module is verified in the same way as a plugin executable. The file is read once so that the code that is
+// loaded is the code that was verified.
+func (r *pluginRegistry) loadWasmPlugin(c px.Context, path string, he hieraapi.Entry, loader px.DefiningLoader) {
+	integrity := newPluginIntegrity(he)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.verifyRunning(path, he, integrity) {
+		return
+	}
+
+	rt := hieraapi.GetWasmRuntime()
+	if rt == nil {
+		rt = bundledWasmRuntime
+	}
+
+	code, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(fmt.Errorf(`unable to load WebAssembly plugin %s: %s`, path, err.Error()))
+	}
+	sum := sha256.Sum256(code)
+	inst := &pluginInstance{network: `wasm`}
+	p := &plugin{path: path, inst: inst, integrity: integrity, callbacks: &r.callbacks}
+	p.digest = hex.EncodeToString(sum[:])
+	if integrity != nil {
+		integrity.check(path, p.digest, func() ([]byte, error) { return code, nil })
+	}
+
+	module, err := rt.Load(code, getWasmLimits(c))
+	if err != nil {
+		panic(fmt.Errorf(`unable to load WebAssembly plugin %s: %s`, path, err.Error()))
+	}
+	inst.wasm = &wasmModule{module: module}
+
+	bs, err := module.Meta()
+	if err == nil {
+		var meta map[string]interface{}
+		if err = json.Unmarshal(bs, &meta); err == nil {
+			p.initializeFunctions(meta)
+		}
+	}
+	if err != nil {
+		_ = module.Close()
+		panic(fmt.Errorf(`error reading meta data of WebAssembly plugin %s: %s`, path, err.Error()))
+	}
+
+	if r.plugins == nil {
+		r.plugins = make(map[string]*plugin)
+	}
+	r.plugins[path] = p
+	p.registerFunctions(c, loader)
+}
+
+// sendWasm performs a call to the WebAssembly module of the given instance
+func (p *plugin) sendWasm(inst *pluginInstance, luType, name string, params url.Values) *pluginResponse {
+	method := luType + `/` + name
+	source := p.path + ` ` + method
+	req := &stdioRequest{Method: method, Params: make(map[string]string, len(params))}
+	for k := range params {
+		req.Params[k] = params.Get(k)
+	}
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return &pluginResponse{source: source, err: err}
+	}
+
+	inst.wasm.lock.Lock()
+	bs, err = inst.wasm.module.Call(bs)
+	inst.wasm.lock.Unlock()
+	if err != nil {
+		// A call that the runtime aborted, e.g. because a limit was exceeded, is a failure of the plugin
+		return &pluginResponse{source: source, status: http.StatusInternalServerError, body: []byte(err.Error())}
+	}
+
+	resp := &stdioResponse{}
+	if err = json.Unmarshal(bs, resp); err != nil {
+		return &pluginResponse{source: source, err: err}
+	}
+	return resp.pluginResponse(source)
+}
+
+// close closes the module
+func (m *wasmModule) close() {
+	m.lock.Lock()
+	_ = m.module.Close()
+	m.lock.Unlock()
+}
@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+)
+
+// provenance records the sources of the values that the data providers find for the key of a lookup. The merge
+// strategies merge the sources of the values along with the values so that the source of each leaf of the final
+// value is known once the lookup has completed.
+type provenance struct {
+	depth      int
+	collecting bool
+	key        hieraapi.Key
+	provider   hieraapi.DataProvider
+	location   hieraapi.Location
+	lastFound  *contribution
+	last       *sources
+	value      px.Value
+}
+
+// contribution is a value found by a data provider. The path is the path to the value from the root of the data
+// hash that it was found in and the positions, when known, are the positions of the values in that data hash.
+type contribution struct {
+	source    *hieraapi.Source
+	path      []interface{}
+	positions hieraapi.PositionsFunc
+}
+
+// sub returns a contribution for the value at the given path element of this contribution
+func (c *contribution) sub(pe interface{}) *contribution {
+	return &contribution{c.source, appendPath(c.path, pe), c.positions}
+}
+
+// sources is a tree that mirrors the structure of a value. A node that stems from one contribution in its entirety
+// is represented by that contribution. The sources of a node that is the result of a merge are found in its
+// children, which are keyed by array index or by hash key. A nil tree means that the sources are unknown.
+type sources struct {
+	whole    *contribution
+	children map[interface{}]*sources
+}
+
+// child returns the sources of the element with the given array index or hash key
+func (s *sources) child(k interface{}) *sources {
+	if s == nil {
+		return nil
+	}
+	if s.whole != nil {
+		return &sources{whole: s.whole.sub(k)}
+	}
+	return s.children[k]
+}
+
+// setChild sets the sources of the element with the given array index or hash key. Nothing is recorded when
+// the tree or the child is nil.
+func (s *sources) setChild(k interface{}, c *sources) {
+	if s == nil || c == nil {
+		return
+	}
+	if s.children == nil {
+		s.children = make(map[interface{}]*sources)
+	}
+	s.children[k] = c
+}
+
+// newSources returns an empty node when at least one of the given trees is known, and nil otherwise
+func newSources(a, b *sources) *sources {
+	if a == nil && b == nil {
+		return nil
+	}
+	return &sources{}
+}
+
+// dig returns the sources of the value that the given key parts select in the given value. The selection is
+// made in the same way as digParts makes it.
+func (s *sources) dig(v px.Value, parts []interface{}) *sources {
+	multi := false
+	vs := []px.Value{v}
+	ss := []*sources{s}
+	for _, p := range parts {
+		if _, ok := p.(keyPattern); ok {
+			multi = true
+		}
+		var nv []px.Value
+		var ns []*sources
+		for i, v := range vs {
+			eachMatch(v, p, func(seg interface{}, mv px.Value) {
+				nv = append(nv, mv)
+				ns = append(ns, ss[i].child(seg))
+			})
+		}
+		vs, ss = nv, ns
+		if len(vs) == 0 {
+			return nil
+		}
+	}
+	if multi {
+		ms := &sources{}
+		for i, s := range ss {
+			ms.setChild(i, s)
+		}
+		return ms
+	}
+	return ss[0]
+}
+
+// leaves appends the source of each leaf of the given value that has a known source to the given slice
+func (s *sources) leaves(path []interface{}, v px.Value, leaves *[]hieraapi.LeafSource) {
+	if s == nil {
+		return
+	}
+	switch vc := v.(type) {
+	case *types.Hash:
+		if vc.Len() > 0 {
+			vc.EachPair(func(k, ev px.Value) {
+				s.child(childKey(k)).leaves(appendPath(path, k.String()), ev, leaves)
+			})
+			return
+		}
+	case *types.Array:
+		if vc.Len() > 0 {
+			vc.EachWithIndex(func(e px.Value, i int) {
+				s.child(i).leaves(appendPath(path, i), e, leaves)
+			})
+			return
+		}
+	}
+	if c := s.whole; c != nil {
+		src := c.source
+		if pos := c.positions.Get(c.path); pos != nil {
+			withLine := *src
+			withLine.Line = pos.Line()
+			src = &withLine
+		}
+		*leaves = append(*leaves, hieraapi.LeafSource{Path: path, Source: src})
+	}
+}
+
+// childKey returns the key of the sources of the hash entry with the given key. Integer keys are kept as int
+// so that they match the segments produced by eachMatch.
+func childKey(k px.Value) interface{} {
+	if i, ok := k.(px.Integer); ok {
+		return int(i.Int())
+	}
+	return k.String()
+}
+
+type hierarchyEntryProvider interface {
+	HierarchyEntry() hieraapi.Entry
+}
+
+// WithSources enables tracking of sources in the given invocation and then calls the given producer. The
+// produced value is returned together with the sources of all of its leaves.
+func WithSources(ic hieraapi.Invocation, producer px.Producer) (px.Value, []hieraapi.LeafSource) {
+	iv := ic.(*invocation)
+	p := &provenance{}
+	iv.provenance = p
+	defer func() {
+		iv.provenance = nil
+	}()
+
+	v := producer()
+	if v == nil || p.key == nil || p.value == nil {
+		return v, nil
+	}
+	var leaves []hieraapi.LeafSource
+	p.last.dig(p.value, p.key.Parts()[1:]).leaves(nil, v, &leaves)
+	return v, leaves
+}
+
+// trackingSources returns the provenance of the given invocation when it tracks the sources of the values that
+// are found for the key of the outermost lookup, and nil otherwise.
+func trackingSources(ic hieraapi.Invocation) *provenance {
+	if iv, ok := ic.(*invocation); ok {
+		if p := iv.provenance; p != nil && p.collecting && p.depth == 1 {
+			return p
+		}
+	}
+	return nil
+}
+
+// lookup calls the given producer and returns the produced value together with its sources. The sources are
+// nil when p is nil.
+func (p *provenance) lookup(producer px.Producer) (px.Value, *sources) {
+	if p == nil {
+		return producer(), nil
+	}
+	p.last = nil
+	v := producer()
+	return v, p.last
+}
+
+// found records the sources of a value that was produced by a merge. Nothing happens when p is nil.
+func (p *provenance) found(s *sources) {
+	if p != nil {
+		p.last = s
+	}
+}
+
+// startLookup is called when a lookup of the given key starts. Only the lookup at the outermost level is
+// tracked. Nested lookups, such as those performed by interpolation, are ignored.
+func (p *provenance) startLookup(key hieraapi.Key, actor px.Producer) px.Value {
+	p.depth++
+	defer func() {
+		p.depth--
+	}()
+	if p.depth > 1 {
+		return actor()
+	}
+	p.key = key
+	p.lastFound = nil
+	p.last = nil
+	p.collecting = true
+	defer func() {
+		p.collecting = false
+	}()
+	p.value = actor()
+	return p.value
+}
+
+// withProvider sets the current data provider and returns a function that restores the previous one
+func (p *provenance) withProvider(dp hieraapi.DataProvider) func() {
+	saved := p.provider
+	p.provider = dp
+	return func() { p.provider = saved }
+}
+
+// withLocation sets the current location and returns a function that restores the previous one
+func (p *provenance) withLocation(loc hieraapi.Location) func() {
+	saved := p.location
+	p.location = loc
+	return func() { p.location = saved }
+}
+
+// reportFound records a value that was found by the current data provider
+func (p *provenance) reportFound(key interface{}, value px.Value) {
+	if !(p.collecting && p.depth == 1 && p.provider != nil) {
+		return
+	}
+	var parts []interface{}
+	switch key {
+	case p.key.Root():
+		parts = p.key.Parts()[:1]
+	case p.key.Source():
+		// Found by a data_dig provider
+		parts = p.key.Parts()
+		for _, pt := range parts {
+			switch pt.(type) {
+			case string, int:
+			default:
+				return
+			}
+		}
+	default:
+		return
+	}
+
+	src := &hieraapi.Source{Provider: p.provider.FullName()}
+	if ep, ok := p.provider.(hierarchyEntryProvider); ok {
+		src.Entry = ep.HierarchyEntry().Name()
+	}
+	if p.location != nil {
+		src.Location = p.location.Resolved()
+	}
+	c := &contribution{source: src, path: parts}
+	s := &sources{whole: c}
+	for i := len(parts) - 1; i > 0; i-- {
+		s = &sources{children: map[interface{}]*sources{parts[i]: s}}
+	}
+	p.lastFound = c
+	p.last = s
+}
+
+// reportPositions adds the given positions to the value that was last found for the given root key
+func (p *provenance) reportPositions(root string, positions hieraapi.PositionsFunc) {
+	if !(p.collecting && p.depth == 1) || p.lastFound == nil || root != p.key.Root() {
+		return
+	}
+	p.lastFound.positions = positions
+}
+
+func appendPath(path []interface{}, p interface{}) []interface{} {
+	np := make([]interface{}, len(path), len(path)+1)
+	copy(np, path)
+	return append(np, p)
+}
@@ -0,0 +1,647 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar"
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/hiera/provider"
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/pcore/yaml"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// knownLookupOptions are the options that are recognized in the hash of a key in a lookup_options hash
+var knownLookupOptions = []string{`alias_of`, `convert_to`, `deprecated`, `description`, `merge`, `type`}
+
+var richDataKeyType = types.NewVariantType(types.DefaultStringType(), types.DefaultNumericType())
+
+var interpolationPattern = regexp.MustCompile(`%\{[^}]*\}`)
+
+var yamlErrorPattern = regexp.MustCompile(`\Ayaml: line (\d+): (.*)\z`)
+
+// dataFile is a data file that has been parsed by the validator
+type dataFile struct {
+	path      string
+	hash      px.OrderedMap
+	positions hieraapi.Positions
+}
+
+// conversion is a convert_to option of a key in a lookup_options hash
+type conversion struct {
+	typ  px.Type
+	args []px.Value
+}
+
+type validator struct {
+	ic          *invocation
+	problems    []*hieraapi.Problem
+	seen        map[string]bool
+	files       []*dataFile
+	conversions map[string]*conversion
+	types       map[string]px.Type
+	merges      map[string]string
+}
+
+// Validate validates the configuration of the given invocation and the data files that are read by the yaml_data
+// and json_data functions of its hierarchies and returns all problems found. The configuration is checked against
+// the Hiera::Config type and unknown keys are reported together with the known key that is the closest match.
+// Each data file is parsed and each value is checked against the RichData type and against the type and the
+// convert_to type that the lookup_options declare for its key.
+//
+// The data files are those that exist for the scope of the invocation unless allFiles is true, in which case they
+// are all files that match the path templates of the hierarchy entries when each interpolation expression is
+// replaced by a '*'.
+func Validate(ic hieraapi.Invocation, allFiles bool) []*hieraapi.Problem {
+	iv := ic.(*invocation)
+	v := &validator{ic: iv, seen: make(map[string]bool), conversions: make(map[string]*conversion), types: make(map[string]px.Type),
+		merges: make(map[string]string)}
+	if cfg := v.validateConfig(iv.configPath); cfg != nil {
+		v.validateHierarchy(cfg, cfg.hierarchy, allFiles)
+		v.validateHierarchy(cfg, cfg.defaultHierarchy, allFiles)
+		v.validateDeclarations()
+	}
+
+	sort.SliceStable(v.problems, byFileAndLine(len(v.problems), func(i int) issue.Location { return v.problems[i].Location }))
+	return v.problems
+}
+
+// byFileAndLine returns a less function that orders the locations returned by the given function by file, in the
+// order in which the files first appear, and by line
+func byFileAndLine(n int, at func(int) issue.Location) func(i, j int) bool {
+	files := make(map[string]int)
+	for i := 0; i < n; i++ {
+		if f := at(i).File(); files[f] == 0 {
+			files[f] = len(files) + 1
+		}
+	}
+	return func(i, j int) bool {
+		a, b := at(i), at(j)
+		if a.File() != b.File() {
+			return files[a.File()] < files[b.File()]
+		}
+		return a.Line() < b.Line()
+	}
+}
+
+func (v *validator) add(location issue.Location, format string, args ...interface{}) {
+	v.problems = append(v.problems, &hieraapi.Problem{Location: location, Message: fmt.Sprintf(format, args...)})
+}
+
+// try calls the given function and adds a problem for the given file if it panics with an error. It returns
+// false when a problem was added.
+func (v *validator) try(file string, f func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, isErr := r.(error)
+			if !isErr {
+				panic(r)
+			}
+			location := issue.NewLocation(file, 0, 0)
+			if ri, isReported := err.(issue.Reported); isReported {
+				if rl := ri.Location(); rl != nil && rl.File() == file {
+					location = rl
+				}
+				err = ri.WithLocation(nil)
+			}
+			v.add(location, `%s`, err.Error())
+		}
+	}()
+	f()
+	return true
+}
+
+// parse checks the YAML or JSON syntax of the given file and returns the positions of its values, or false
+// when the file cannot be read or parsed.
+func (v *validator) parse(file string) ([]byte, hieraapi.Positions, issue.Location, bool) {
+	bin, ok := types.BinaryFromFile2(file)
+	if !ok {
+		v.add(issue.NewLocation(file, 0, 0), `unable to read file`)
+		return nil, nil, nil, false
+	}
+	bs := bin.Bytes()
+	if err := yaml3.Unmarshal(bs, &yaml3.Node{}); err != nil {
+		location := issue.NewLocation(file, 0, 0)
+		msg := err.Error()
+		if m := yamlErrorPattern.FindStringSubmatch(msg); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			location = issue.NewLocation(file, line, 0)
+			msg = m[2]
+		}
+		v.add(location, `syntax error: %s`, msg)
+		return nil, nil, nil, false
+	}
+	positions, root := provider.ParsePositions(file, bs)
+	return bs, positions, root, true
+}
+
+// locationOf returns the location of the value at the given path or the given default location when the
+// location of that value is unknown
+func locationOf(positions hieraapi.Positions, vp []interface{}, dflt issue.Location) issue.Location {
+	for i := len(vp); i > 0; i-- {
+		if l := positions.Get(vp[:i]); l != nil {
+			return l
+		}
+	}
+	return dflt
+}
+
+// pathString returns the given path in dotted key notation
+func pathString(vp []interface{}) string {
+	ps := make([]string, len(vp))
+	for i, p := range vp {
+		ps[i] = fmt.Sprint(p)
+	}
+	return strings.Join(ps, `.`)
+}
+
+// unknownKey returns a message for a key that is not among the known keys, with a suggestion when one of the
+// known keys is a close match
+func unknownKey(key string, vp []interface{}, known []string) string {
+	msg := fmt.Sprintf(`unknown key '%s'`, key)
+	if len(vp) > 0 {
+		msg = fmt.Sprintf(`%s in %s`, msg, pathString(vp))
+	}
+	if s := suggest(key, known); s != `` {
+		msg = fmt.Sprintf(`%s, did you mean '%s'?`, msg, s)
+	}
+	return msg
+}
+
+// suggest returns the candidate that is closest to the given name or an empty string when no candidate is
+// close enough
+func suggest(name string, candidates []string) string {
+	best := ``
+	bestDistance := (len(name) + 2) / 3
+	for _, c := range candidates {
+		if d := levenshtein(name, c); d <= bestDistance && (best == `` || d < bestDistance) {
+			best = c
+			bestDistance = d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// validateConfig validates the configuration file at the given path and returns the configuration, or nil when
+// it has problems. The default configuration is returned when the file does not exist.
+func (v *validator) validateConfig(path string) *hieraCfg {
+	if _, ok := types.BinaryFromFile2(path); !ok {
+		return NewConfig(v.ic, path).(*hieraCfg)
+	}
+	bs, positions, root, ok := v.parse(path)
+	if !ok {
+		return nil
+	}
+	var cv px.Value
+	if !v.try(path, func() { cv = yaml.Unmarshal(v.ic, bs) }) {
+		return nil
+	}
+
+	// The data files are validated too when a configuration can be created despite its problems. A failure to
+	// create it is only reported when it has no other problems since that failure is a consequence of them.
+	n := len(v.problems)
+	v.checkType(positions, root, nil, v.ic.ParseType(`Hiera::Config`), cv)
+	var cfg *hieraCfg
+	if msg := catch(func() { cfg = createConfig(v.ic, path, cv.(*types.Hash)).(*hieraCfg) }); msg != `` {
+		if len(v.problems) == n {
+			v.add(issue.NewLocation(path, 0, 0), `%s`, msg)
+		}
+		return nil
+	}
+	return cfg
+}
+
+// checkType checks the value at the given path of a configuration against the given type. Struct types are
+// checked key by key so that all unknown and missing keys are reported.
+func (v *validator) checkType(positions hieraapi.Positions, root issue.Location, vp []interface{}, t px.Type, value px.Value) {
+	if a, ok := t.(*types.TypeAliasType); ok {
+		t = a.ResolvedType()
+	}
+	location := locationOf(positions, vp, root)
+	switch t := t.(type) {
+	case *types.StructType:
+		if h, ok := value.(*types.Hash); ok {
+			members := t.HashedMembers()
+			known := make([]string, 0, len(members))
+			for _, m := range t.Elements() {
+				known = append(known, m.Name())
+			}
+			h.EachPair(func(k, ev px.Value) {
+				ks := k.String()
+				kp := append(vp[:len(vp):len(vp)], ks)
+				if m, ok := members[ks]; ok {
+					v.checkType(positions, root, kp, m.Value(), ev)
+				} else {
+					v.add(locationOf(positions, kp, location), `%s`, unknownKey(ks, vp, known))
+				}
+			})
+			for _, m := range t.Elements() {
+				if !(m.Optional() || h.IncludesKey2(m.Name())) {
+					if len(vp) == 0 {
+						v.add(location, `missing required key '%s'`, m.Name())
+					} else {
+						v.add(location, `%s is missing required key '%s'`, pathString(vp), m.Name())
+					}
+				}
+			}
+			return
+		}
+	case *types.ArrayType:
+		if a, ok := value.(*types.Array); ok {
+			et := t.ElementType()
+			if ea, ok := et.(*types.TypeAliasType); ok {
+				et = ea.ResolvedType()
+			}
+			if _, ok := et.(*types.StructType); ok {
+				if at := types.NewArrayType(types.DefaultAnyType(), t.Size()); !px.IsInstance(at, a) {
+					v.add(location, `%s %s`, pathString(vp), hieraapi.DescribeMismatch(at, a))
+				}
+				a.EachWithIndex(func(e px.Value, i int) {
+					v.checkType(positions, root, append(vp[:len(vp):len(vp)], i), et, e)
+				})
+				return
+			}
+		}
+	}
+	if !px.IsInstance(t, value) {
+		if len(vp) == 0 {
+			v.add(location, `the configuration %s`, hieraapi.DescribeMismatch(t, value))
+		} else {
+			v.add(location, `%s %s`, pathString(vp), hieraapi.DescribeMismatch(t, value))
+		}
+	}
+}
+
+// validateHierarchy validates the data files of the given hierarchy entries
+func (v *validator) validateHierarchy(cfg *hieraCfg, entries []hieraapi.Entry, allFiles bool) {
+	if allFiles {
+		eachTemplateMatch(cfg, entries, v.validateDataFile)
+		return
+	}
+
+	var defaults hieraapi.Entry
+	if !v.try(cfg.path, func() { defaults = cfg.defaults.Resolve(v.ic, nil) }) {
+		return
+	}
+	for _, he := range entries {
+		var re hieraapi.Entry
+		if !v.try(cfg.path, func() { re = he.Resolve(v.ic, defaults) }) {
+			continue
+		}
+		if f := re.Function(); isFileDataFunction(f) {
+			for _, l := range re.Locations() {
+				if l.Kind() == hieraapi.LcPath && l.Exists() {
+					v.validateDataFile(l.Resolved(), f.Name())
+				}
+			}
+		}
+	}
+}
+
+// isFileDataFunction returns true if the given function is one of the data_hash functions that read files
+func isFileDataFunction(f hieraapi.Function) bool {
+	return f != nil && f.Kind() == hieraapi.KindDataHash && (f.Name() == `yaml_data` || f.Name() == `json_data`)
+}
+
+// eachTemplateMatch calls the given function with each file that matches the locations of the given entries that
+// use a data_hash function that reads files, together with the name of that function
+func eachTemplateMatch(cfg *hieraCfg, entries []hieraapi.Entry, f func(file, fn string)) {
+	for _, he := range entries {
+		e := he.(*entry)
+		if fn := functionOf(cfg, e); isFileDataFunction(fn) {
+			for _, file := range templateMatches(cfg, e) {
+				f(file, fn.Name())
+			}
+		}
+	}
+}
+
+// functionOf returns the function of the given entry or the default function when the entry has none
+func functionOf(cfg *hieraCfg, e *entry) hieraapi.Function {
+	if e.function == nil {
+		return cfg.defaults.function
+	}
+	return e.function
+}
+
+// dataDirOf returns the absolute data directory of the given entry without resolving its interpolation
+// expressions
+func dataDirOf(cfg *hieraCfg, e *entry) string {
+	dataDir := e.dataDir
+	if dataDir == `` {
+		dataDir = cfg.defaults.dataDir
+	}
+	if !filepath.IsAbs(dataDir) {
+		dataDir = filepath.Join(cfg.root, dataDir)
+	}
+	return dataDir
+}
+
+// templateMatches returns the files that match the locations of the given entry when each interpolation
+// expression in the data directory and in the locations is replaced by a '*'
+func templateMatches(cfg *hieraCfg, e *entry) []string {
+	dataDir := interpolationPattern.ReplaceAllString(dataDirOf(cfg, e), `*`)
+
+	files := make([]string, 0)
+	for _, l := range e.locations {
+		var template string
+		switch l := l.(type) {
+		case *path:
+			template = l.original
+		case *glob:
+			template = l.pattern
+		case *mappedPaths:
+			template = l.template
+		default:
+			continue
+		}
+		matches, _ := doublestar.Glob(filepath.Join(dataDir, interpolationPattern.ReplaceAllString(template, `*`)))
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files
+}
+
+// validateDataFile parses the given data file using the given function and checks its values
+func (v *validator) validateDataFile(file, fn string) {
+	if v.seen[file] {
+		return
+	}
+	v.seen[file] = true
+
+	if _, _, _, ok := v.parse(file); !ok {
+		return
+	}
+
+	var df *dataFile
+	if !v.try(file, func() { df = readDataFile(v.ic, file, fn) }) {
+		return
+	}
+	v.files = append(v.files, df)
+
+	root := issue.NewLocation(file, 0, 0)
+	df.hash.EachPair(func(k, value px.Value) {
+		kp := []interface{}{k.String()}
+		if k.String() == `lookup_options` {
+			v.checkLookupOptions(df, locationOf(df.positions, kp, root), value)
+		} else {
+			v.checkRichData(df, root, kp, k, value)
+		}
+	})
+}
+
+// readDataFile reads the given file using the given function, which is either yaml_data or json_data
+func readDataFile(ic hieraapi.Invocation, file, fn string) *dataFile {
+	df := &dataFile{path: file}
+	sc := newServerContext(ic, &sync.Map{}, map[string]px.Value{`path`: types.WrapString(file)})
+	var positions hieraapi.PositionsFunc
+	if fn == `json_data` {
+		df.hash, positions = provider.JSONDataWithPositions(sc)
+	} else {
+		df.hash, positions = provider.YamlDataWithPositions(sc)
+	}
+	df.positions = positions()
+	return df
+}
+
+// checkRichData checks that the given value, which has the given key, is RichData
+func (v *validator) checkRichData(df *dataFile, root issue.Location, vp []interface{}, key, value px.Value) {
+	location := locationOf(df.positions, vp, root)
+	if !px.IsInstance(richDataKeyType, key) {
+		v.add(location, `key %s %s`, pathString(vp), hieraapi.DescribeMismatch(richDataKeyType, key))
+	}
+	switch value := value.(type) {
+	case *types.Hash:
+		value.EachPair(func(k, e px.Value) {
+			v.checkRichData(df, root, append(vp[:len(vp):len(vp)], k.String()), k, e)
+		})
+	case *types.Array:
+		value.EachWithIndex(func(e px.Value, i int) {
+			v.checkRichData(df, root, append(vp[:len(vp):len(vp)], i), types.WrapInteger(int64(i)), e)
+		})
+	default:
+		if !px.IsInstance(types.DefaultRichDataType(), value) {
+			v.add(location, `%s %s`, pathString(vp), hieraapi.DescribeMismatch(types.DefaultRichDataType(), value))
+		}
+	}
+}
+
+// checkLookupOptions checks the lookup_options hash of the given data file and records the type, the convert_to
+// option, and the name of the merge strategy of each key. Options in files that are validated earlier take precedence.
+func (v *validator) checkLookupOptions(df *dataFile, location issue.Location, value px.Value) {
+	loType := types.NewHashType(types.DefaultStringType(), types.DefaultHashType(), nil)
+	if !px.IsInstance(loType, value) {
+		v.add(location, `lookup_options %s`, hieraapi.DescribeMismatch(loType, value))
+		return
+	}
+	value.(px.OrderedMap).EachPair(func(k, ov px.Value) {
+		key := k.String()
+		kp := []interface{}{`lookup_options`, key}
+		ov.(px.OrderedMap).EachPair(func(o, ovv px.Value) {
+			op := append(kp[:len(kp):len(kp)], o.String())
+			ol := locationOf(df.positions, op, location)
+			switch o.String() {
+			case `merge`:
+				if name := v.checkMerge(ol, pathString(op), ovv); name != `` {
+					if _, ok := v.merges[key]; !ok {
+						v.merges[key] = name
+					}
+				}
+			case `convert_to`:
+				if c := v.checkConvertTo(ol, pathString(op), ovv); c != nil {
+					if _, ok := v.conversions[key]; !ok {
+						v.conversions[key] = c
+					}
+				}
+			case `type`:
+				if t := v.checkDeclaredType(ol, pathString(op), ovv); t != nil {
+					if _, ok := v.types[key]; !ok {
+						v.types[key] = t
+					}
+				}
+			case `description`, `deprecated`:
+				if _, ok := ovv.(px.StringValue); !ok {
+					v.add(ol, `%s must be a string`, pathString(op))
+				}
+			case `alias_of`:
+				v.checkAlias(ol, pathString(op), key, ovv)
+			default:
+				v.add(ol, `%s`, unknownKey(o.String(), kp, knownLookupOptions))
+			}
+		})
+	})
+}
+
+// checkAlias checks that the given alias_of option is a valid key other than the key that it is given for
+func (v *validator) checkAlias(location issue.Location, subject, key string, alias px.Value) {
+	if _, ok := alias.(px.StringValue); !ok {
+		v.add(location, `%s must be a string`, subject)
+		return
+	}
+	var ak hieraapi.Key
+	if msg := catch(func() { ak = newKey(alias.String()) }); msg != `` {
+		v.add(location, `%s: %s`, subject, msg)
+		return
+	}
+	if ak.Root() == key {
+		v.add(location, `%s must not refer to the key itself`, subject)
+	}
+}
+
+// checkMerge checks that the given merge option denotes a known merge strategy and returns the name of the
+// strategy or an empty string when the option has problems
+func (v *validator) checkMerge(location issue.Location, subject string, merge px.Value) string {
+	var name px.Value = merge
+	var opts map[string]px.Value
+	if mh, ok := merge.(px.OrderedMap); ok {
+		name = mh.Get5(`strategy`, types.WrapString(`first`))
+		opts = mh.ToStringMap()
+		delete(opts, `strategy`)
+	}
+	if _, ok := name.(px.StringValue); !ok {
+		v.add(location, `%s must be a string or a hash with a strategy`, subject)
+		return ``
+	}
+	if msg := catch(func() { hieraapi.GetMergeStrategy(hieraapi.MergeStrategyName(name.String()), opts) }); msg != `` {
+		v.add(location, `%s: %s`, subject, msg)
+		return ``
+	}
+	return name.String()
+}
+
+// checkConvertTo checks that the given convert_to option is a type, optionally followed by arguments, and
+// returns the conversion or nil when the option has problems
+func (v *validator) checkConvertTo(location issue.Location, subject string, ct px.Value) (c *conversion) {
+	ts := ct
+	var args []px.Value
+	if ca, ok := ct.(*types.Array); ok {
+		if ca.Len() == 0 {
+			v.add(location, `%s must not be empty`, subject)
+			return nil
+		}
+		ts = ca.At(0)
+		args = ca.Slice(1, ca.Len()).AppendTo(make([]px.Value, 0, ca.Len()-1))
+	}
+	if _, ok := ts.(px.StringValue); !ok {
+		v.add(location, `%s must be a type name or an array with a type name and arguments`, subject)
+		return nil
+	}
+	if msg := catch(func() { c = &conversion{typ: v.ic.ParseType(ts.String()), args: args} }); msg != `` {
+		v.add(location, `%s '%s' is not a valid type: %s`, subject, ts, msg)
+		return nil
+	}
+	if _, ok := c.typ.(*types.TypeReferenceType); ok {
+		v.add(location, `%s '%s' is not a known type`, subject, ts)
+		return nil
+	}
+	return c
+}
+
+// checkDeclaredType checks that the given type option is the name of a known type and returns that type or nil
+// when the option has problems
+func (v *validator) checkDeclaredType(location issue.Location, subject string, to px.Value) (t px.Type) {
+	if _, ok := to.(px.StringValue); !ok {
+		v.add(location, `%s must be a type name`, subject)
+		return nil
+	}
+	if msg := catch(func() { t = v.ic.ParseType(to.String()) }); msg != `` {
+		v.add(location, `%s '%s' is not a valid type: %s`, subject, to, msg)
+		return nil
+	}
+	if _, ok := t.(*types.TypeReferenceType); ok {
+		v.add(location, `%s '%s' is not a known type`, subject, to)
+		return nil
+	}
+	return t
+}
+
+// validateDeclarations checks that the value of each key that has a type option in any of the validated files
+// is an instance of that type and that the value of each key that has a convert_to option can be converted.
+// Under the first merge strategy any file can provide the value, and under the unique strategy each file provides
+// some of its elements, so the value in each file is checked. The other strategies merge values that need not be
+// complete on their own, so their values are not checked, and neither are values that contain interpolation
+// expressions since their final value is unknown.
+func (v *validator) validateDeclarations() {
+	for _, df := range v.files {
+		df.hash.EachPair(func(k, value px.Value) {
+			if containsInterpolation(value) {
+				return
+			}
+			switch v.merges[k.String()] {
+			case ``, `first`:
+			case `unique`:
+				if _, ok := value.(*types.Array); !ok {
+					value = types.WrapValues([]px.Value{value})
+				}
+			default:
+				return
+			}
+			location := locationOf(df.positions, []interface{}{k.String()}, issue.NewLocation(df.path, 0, 0))
+			if t, ok := v.types[k.String()]; ok && !px.IsInstance(t, value) {
+				v.add(location, `value of '%s' does not match the type declared in lookup_options: %s`, k,
+					hieraapi.DescribeMismatch(t, value))
+			}
+			c, ok := v.conversions[k.String()]
+			if !ok {
+				return
+			}
+			if msg := catch(func() { px.New(v.ic, c.typ, append([]px.Value{value}, c.args...)...) }); msg != `` {
+				// Descriptions that span several lines list every rejected signature of the type's constructor
+				if strings.Contains(msg, "\n") {
+					v.add(location, `value of '%s' cannot be converted to %s`, k, c.typ)
+				} else {
+					v.add(location, `value of '%s' cannot be converted to %s: %s`, k, c.typ, msg)
+				}
+			}
+		})
+	}
+}
+
+// containsInterpolation returns true if the given value contains a string with an interpolation expression
+func containsInterpolation(value px.Value) (found bool) {
+	switch value := value.(type) {
+	case px.StringValue:
+		found = strings.Contains(value.String(), `%{`)
+	case *types.Hash:
+		value.EachValue(func(e px.Value) { found = found || containsInterpolation(e) })
+	case *types.Array:
+		value.Each(func(e px.Value) { found = found || containsInterpolation(e) })
+	}
+	return
+}
@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	log "github.com/sirupsen/logrus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// WasmCallTimeout is the time that a call to a WebAssembly plugin may take before the call is aborted
+var WasmCallTimeout = 5 * time.Second
+
+// Names of the functions that a WebAssembly plugin module must export in addition to its "memory"
+const (
+	wasmAllocFunc = `hiera_alloc`
+	wasmMetaFunc  = `hiera_meta`
+	wasmCallFunc  = `hiera_call`
+)
+
+const wasmPageSize = 65536
+
+// wazeroRuntime is the WebAssembly runtime that Hiera uses unless an application registers another one
+type wazeroRuntime struct{}
+
+var bundledWasmRuntime hieraapi.WasmRuntime = wazeroRuntime{}
+
+// wasmCompilationCache is shared by all runtimes so that a module is compiled only once per process
+var wasmCompilationCache = wazero.NewCompilationCache()
+
+// wazeroModule is a compiled module. The module is instantiated on demand and discarded when a call fails, since
+// a trap can leave the instance in an inconsistent state.
+type wazeroModule struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	instance api.Module
+	limits   hieraapi.WasmLimits
+}
+
+// callBudget is the number of function calls that remain for one call into the module. The call is aborted through
+// the cancel function when the budget is exhausted. Only function calls are counted, not instructions, so a loop
+// that doesn't call functions isn't bounded by the budget but only by WasmCallTimeout.
+type callBudget struct {
+	calls     uint64
+	cancel    context.CancelFunc
+	exhausted bool
+}
+
+type callBudgetKey struct{}
+
+// callCounter deducts one function call from the budget of the current call each time a function is called. The
+// counter is stateless since compiled modules, and hence their listeners, are shared through the compilation cache.
+type callCounter struct{}
+
+// Load compiles the given code into a module with its own runtime. The memory limit is imposed by the runtime and
+// the call limit is imposed by a listener that counts the function calls.
+func (wazeroRuntime) Load(code []byte, limits hieraapi.WasmLimits) (hieraapi.WasmModule, error) {
+	ctx := context.Background()
+	cfg := wazero.NewRuntimeConfig().WithCloseOnContextDone(true).WithCompilationCache(wasmCompilationCache)
+	if limits.MemoryBytes > 0 {
+		pages := limits.MemoryBytes / wasmPageSize
+		if pages == 0 {
+			pages = 1
+		}
+		if pages < 65536 {
+			cfg = cfg.WithMemoryLimitPages(uint32(pages))
+		}
+	}
+	rt := wazero.NewRuntimeWithConfig(ctx, cfg)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		_ = rt.Close(ctx)
+		return nil, err
+	}
+
+	m := &wazeroModule{runtime: rt, limits: limits}
+	cctx := ctx
+	if limits.Calls > 0 {
+		cctx = experimental.WithFunctionListenerFactory(ctx, callCounter{})
+	}
+	compiled, err := rt.CompileModule(cctx, code)
+	if err != nil {
+		_ = rt.Close(ctx)
+		return nil, err
+	}
+	m.compiled = compiled
+	for _, name := range []string{wasmAllocFunc, wasmMetaFunc, wasmCallFunc} {
+		if _, ok := compiled.ExportedFunctions()[name]; !ok {
+			_ = rt.Close(ctx)
+			return nil, fmt.Errorf(`module does not export the function %s`, name)
+		}
+	}
+	return m, nil
+}
+
+// NewFunctionListener returns the same listener for all functions
+func (cc callCounter) NewFunctionListener(api.FunctionDefinition) experimental.FunctionListener {
+	return cc
+}
+
+// Before deducts one function call from the budget found in the given context. Functions that are called while the
+// module is instantiated aren't counted.
+func (callCounter) Before(ctx context.Context, _ api.Module, _ api.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+	budget, ok := ctx.Value(callBudgetKey{}).(*callBudget)
+	if !ok || budget.exhausted {
+		return
+	}
+	if budget.calls == 0 {
+		budget.exhausted = true
+		budget.cancel()
+		return
+	}
+	budget.calls--
+}
+
+func (callCounter) After(context.Context, api.Module, api.FunctionDefinition, []uint64) {
+}
+
+func (callCounter) Abort(context.Context, api.Module, api.FunctionDefinition, error) {
+}
+
+func (m *wazeroModule) Meta() ([]byte, error) {
+	return m.invoke(func(ctx context.Context, mod api.Module) ([]uint64, error) {
+		return mod.ExportedFunction(wasmMetaFunc).Call(ctx)
+	})
+}
+
+func (m *wazeroModule) Call(request []byte) ([]byte, error) {
+	return m.invoke(func(ctx context.Context, mod api.Module) ([]uint64, error) {
+		r, err := mod.ExportedFunction(wasmAllocFunc).Call(ctx, uint64(len(request)))
+		if err != nil {
+			return nil, err
+		}
+		ptr := api.DecodeU32(r[0])
+		if !mod.Memory().Write(ptr, request) {
+			return nil, fmt.Errorf(`%s returned an invalid pointer %d`, wasmAllocFunc, ptr)
+		}
+		return mod.ExportedFunction(wasmCallFunc).Call(ctx, uint64(ptr), uint64(len(request)))
+	})
+}
+
+// invoke calls the given function with an instance of the module and the call budget of one call. The function
+// returns a pointer to the response in the high 32 bits and its length in the low 32 bits. A copy of the response is
+// returned.
+func (m *wazeroModule) invoke(f func(context.Context, api.Module) ([]uint64, error)) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), WasmCallTimeout)
+	defer cancel()
+
+	mod, err := m.instantiate(ctx)
+	if err != nil {
+		return nil, m.callError(ctx, nil, err)
+	}
+
+	budget := &callBudget{calls: m.limits.Calls, cancel: cancel}
+	r, err := f(context.WithValue(ctx, callBudgetKey{}, budget), mod)
+	if err != nil {
+		return nil, m.callError(ctx, budget, err)
+	}
+	ptr, size := uint32(r[0]>>32), uint32(r[0])
+	bs, ok := mod.Memory().Read(ptr, size)
+	if !ok {
+		m.discard()
+		return nil, fmt.Errorf(`response at %d with length %d is out of range`, ptr, size)
+	}
+	return append([]byte(nil), bs...), nil
+}
+
+// instantiate returns the current instance of the module or a new instance if there is none
+func (m *wazeroModule) instantiate(ctx context.Context) (api.Module, error) {
+	if m.instance != nil && !m.instance.IsClosed() {
+		return m.instance, nil
+	}
+	mc := wazero.NewModuleConfig().WithName(``).WithStartFunctions(`_initialize`).WithStderr(log.StandardLogger().Out)
+	mod, err := m.runtime.InstantiateModule(ctx, m.compiled, mc)
+	if err != nil {
+		return nil, err
+	}
+	m.instance = mod
+	return mod, nil
+}
+
+// callError discards the current instance and returns an error that explains why the call failed
+func (m *wazeroModule) callError(ctx context.Context, budget *callBudget, err error) error {
+	m.discard()
+	switch {
+	case budget != nil && budget.exhausted:
+		return fmt.Errorf(`call aborted after %d function calls: call limit exceeded`, m.limits.Calls)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf(`call aborted after %s: timeout`, WasmCallTimeout)
+	}
+	return err
+}
+
+func (m *wazeroModule) discard() {
+	if m.instance != nil {
+		_ = m.instance.Close(context.Background())
+		m.instance = nil
+	}
+}
+
+func (m *wazeroModule) Close() error {
+	return m.runtime.Close(context.Background())
+}
@@ -11,7 +11,10 @@ func main() {
 	cmd := cli.NewCommand()
 	err := cmd.Execute()
 	if err != nil {
-		fmt.Println(cmd.OutOrStderr(), err)
+		fmt.Fprintln(os.Stderr, err)
+		if ec, ok := err.(cli.ExitCoder); ok {
+			os.Exit(ec.ExitCode())
+		}
 		os.Exit(1)
 	}
 }
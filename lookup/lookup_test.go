@@ -1,16 +1,29 @@
 package main_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 
 	"github.com/lyraproj/hiera/cli"
+	"github.com/lyraproj/hiera/hiera"
 	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/hiera/provider"
 	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -153,6 +166,7 @@ func TestLookup_explain(t *testing.T) {
           Sub key: "a"
             Found key: "a" value: 'value of c.a'
         Found key: "interpolate_ca" value: 'This is value of c\.a'
+        Defined at [^\n]*/testdata/hiera/named_by_fact\.yaml:1
     Merged result: 'This is value of c\.a'
 \z`, filepath.ToSlash(string(result)))
 	})
@@ -202,6 +216,8 @@ branches:
                     exists: true
                     original: named_%\{data_file\}\.yaml
                     resolved: .*/testdata/hiera/named_by_fact\.yaml
+                texts:
+                  - Defined at [^\n]*/testdata/hiera/named_by_fact\.yaml:1
                 value: This is value of c\.a
             providerName: data_hash function 'yaml_data'
         event: 6
@@ -230,6 +246,7 @@ func TestLookup_explain_options(t *testing.T) {
             'convert_to' => 'Sensitive'
           \}
         \}
+        Defined at [^\n]*/testdata/hiera/common\.yaml:20
     data_hash function 'yaml_data'
       Path "[^"]*/testdata/hiera/named_by_fact\.yaml"
         Original path: "named_%\{data_file\}\.yaml"
@@ -264,6 +281,7 @@ func TestLookup_explain_explain_options(t *testing.T) {
             'convert_to' => 'Sensitive'
           \}
         \}
+        Defined at [^\n]*/testdata/hiera/common\.yaml:20
     data_hash function 'yaml_data'
       Path "[^"]*/testdata/hiera/named_by_fact\.yaml"
         Original path: "named_%\{data_file\}\.yaml"
@@ -290,6 +308,7 @@ Searching for "hash"
             'c' => 'C'
           \}
         \}
+        Defined at [^\n]*/testdata/hiera/common\.yaml:3
     data_hash function 'yaml_data'
       Path "[^"]*/testdata/hiera/named_by_fact\.yaml"
         Original path: "named_%\{data_file\}\.yaml"
@@ -301,6 +320,7 @@ Searching for "hash"
             'c' => 'overwritten C'
           \}
         \}
+        Defined at [^\n]*/testdata/hiera/named_by_fact\.yaml:3
     Merged result: \{
         'one' => 1,
         'two' => 'two',
@@ -314,6 +334,37 @@ Searching for "hash"
 	})
 }
 
+func TestLookup_showSources(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--show-sources`, `--facts`, `facts.yaml`, `hash`)
+		require.NoError(t, err)
+		require.Regexp(t,
+			`\Aone: 1 # Common: [^\n]*/testdata/hiera/common\.yaml:4
+three:
+    a: A # Common: [^\n]*/testdata/hiera/common\.yaml:7
+    b: B # Stuff: [^\n]*/testdata/hiera/named_by_fact\.yaml:7
+    c: C # Common: [^\n]*/testdata/hiera/common\.yaml:8
+two: two # Common: [^\n]*/testdata/hiera/common\.yaml:5
+\z`, filepath.ToSlash(string(result)))
+	})
+}
+
+func TestLookup_showSourcesJSON(t *testing.T) {
+	inTestdata(func() {
+		_, err := cli.ExecuteLookup(`--show-sources`, `--render-as`, `json`, `--facts`, `facts.yaml`, `hash`)
+		require.Error(t, err)
+		require.Regexp(t, `sources can only be shown when rendering as yaml`, err.Error())
+	})
+}
+
+func TestLookup_showSourcesExplain(t *testing.T) {
+	inTestdata(func() {
+		_, err := cli.ExecuteLookup(`--show-sources`, `--explain`, `--facts`, `facts.yaml`, `hash`)
+		require.Error(t, err)
+		require.Regexp(t, `sources cannot be shown together with an explanation`, err.Error())
+	})
+}
+
 func customLK(hc hieraapi.ServerContext, key string) px.Value {
 	return hc.Option(key)
 }
@@ -376,8 +427,360 @@ func TestDataHash_panic(t *testing.T) {
 	})
 }
 
+func TestLookupKey_pluginAddress(t *testing.T) {
+	router := http.NewServeMux()
+	router.HandleFunc(`/meta`, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"version":1,"functions":{"lookup_key":["external_lookup_key"]}}`))
+	})
+	router.HandleFunc(`/lookup_key/external_lookup_key`, func(w http.ResponseWriter, r *http.Request) {
+		var opts map[string]interface{}
+		q := r.URL.Query()
+		if q.Get(`callback`) != `` {
+			http.Error(w, `callback passed to a plugin that doesn't use callbacks`, http.StatusInternalServerError)
+			return
+		}
+		if err := json.Unmarshal([]byte(q.Get(`options`)), &opts); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if v, ok := opts[q.Get(`key`)]; ok {
+			_ = json.NewEncoder(w).Encode(v)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	inTestdata(func() {
+		host := strings.TrimPrefix(server.URL, `http://`)
+		result, err := cli.ExecuteLookup(`--config`, `plugin_address.yaml`, `--var`, `plugin_host=`+host, `a`)
+		require.NoError(t, err)
+		require.Equal(t, "option a\n", string(result))
+	})
+}
+
+func TestLookupKey_pluginCallback(t *testing.T) {
+	router := http.NewServeMux()
+	router.HandleFunc(`/meta`, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"version":1,"callbacks":true,"functions":{"lookup_key":["callback_lookup_key"]}}`))
+	})
+	router.HandleFunc(`/lookup_key/callback_lookup_key`, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		key := q.Get(`key`)
+		w.Header().Add(`X-Hiera-Explain`, `callback_lookup_key was called with `+key)
+		switch key {
+		case `i`:
+			// i asks hiera to interpolate a string
+			resp, err := http.Get(q.Get(`callback`) + `/interpolate?value=` + url.QueryEscape(`b is %{lookup('b')}`))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+			bts, _ := ioutil.ReadAll(resp.Body)
+			w.WriteHeader(resp.StatusCode)
+			_, _ = w.Write(bts)
+		case `a`, `r`:
+			// a performs a nested lookup of b and r performs a nested lookup of itself
+			nested := `b`
+			if key == `r` {
+				nested = `r`
+			}
+			resp, err := http.Get(q.Get(`callback`) + `/lookup/` + nested)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+			bts, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK {
+				http.Error(w, string(bts), resp.StatusCode)
+				return
+			}
+			var v string
+			_ = json.Unmarshal(bts, &v)
+			_ = json.NewEncoder(w).Encode(`a uses ` + v)
+		default:
+			var opts map[string]interface{}
+			_ = json.Unmarshal([]byte(q.Get(`options`)), &opts)
+			if v, ok := opts[key]; ok {
+				_ = json.NewEncoder(w).Encode(v)
+				return
+			}
+			http.NotFound(w, r)
+		}
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	inTestdata(func() {
+		host := `plugin_host=` + strings.TrimPrefix(server.URL, `http://`)
+		result, err := cli.ExecuteLookup(`--config`, `plugin_callback.yaml`, `--var`, host, `a`)
+		require.NoError(t, err)
+		require.Equal(t, "a uses option b\n", string(result))
+
+		result, err = cli.ExecuteLookup(`--config`, `plugin_callback.yaml`, `--var`, host, `--explain`, `a`)
+		require.NoError(t, err)
+		require.Contains(t, string(result), `callback_lookup_key was called with a`)
+		require.Contains(t, string(result), `callback_lookup_key was called with b`)
+
+		_, err = cli.ExecuteLookup(`--config`, `plugin_callback.yaml`, `--var`, host, `r`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Recursive lookup detected in \[r\]`, err.Error())
+		}
+
+		result, err = cli.ExecuteLookup(`--config`, `plugin_callback.yaml`, `--var`, host, `i`)
+		require.NoError(t, err)
+		require.Equal(t, "b is option b\n", string(result))
+	})
+}
+
+func TestLookupKey_pluginIntegrityViolation(t *testing.T) {
+	ensureTestPlugin(t)
+	inTestdata(func() {
+		_, err := cli.ExecuteLookup(`--config`, `untrusted_plugin.yaml`, `a`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Refusing to start plugin '.*hieratestplugin': digest [0-9a-f]{64} is not in the list of allowed digests`, err.Error())
+		}
+	})
+}
+
+func TestLookupKey_pluginProcess(t *testing.T) {
+	ensureTestPlugin(t)
+	for _, n := range []string{`HIERA_TEST_PASSED`, `HIERA_TEST_NOT_PASSED`} {
+		if v, ok := os.LookupEnv(n); ok {
+			defer func(n, v string) { _ = os.Setenv(n, v) }(n, v)
+		} else {
+			defer func(n string) { _ = os.Unsetenv(n) }(n)
+		}
+	}
+	require.NoError(t, os.Setenv(`HIERA_TEST_PASSED`, `passed through`))
+	require.NoError(t, os.Setenv(`HIERA_TEST_NOT_PASSED`, `not passed through`))
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--config`, `process_plugin.yaml`, `HIERA_TEST_GREETING`)
+		require.NoError(t, err)
+		require.Equal(t, "hello\n", string(result))
+
+		result, err = cli.ExecuteLookup(`--config`, `process_plugin.yaml`, `HIERA_TEST_PASSED`)
+		require.NoError(t, err)
+		require.Equal(t, "passed through\n", string(result))
+
+		_, err = cli.ExecuteLookup(`--config`, `process_plugin.yaml`, `HIERA_TEST_NOT_PASSED`)
+		require.Error(t, err)
+
+		if runtime.GOOS == `linux` {
+			// The limits are applied to the started plugin process
+			result, err = cli.ExecuteLookup(`--config`, `process_plugin.yaml`, `Max open files`)
+			require.NoError(t, err)
+			require.Equal(t, "256 256 files\n", string(result))
+		}
+	})
+}
+
+func TestLookupKey_pluginProcessConflict(t *testing.T) {
+	ensureTestPlugin(t)
+	inTestdata(func() {
+		// The second entry would share the process that the first entry started with another environment
+		_, err := cli.ExecuteLookup(`--config`, `conflicting_process_plugin.yaml`, `HIERA_TEST_NOT_SET`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `unable to start plugin .*hieratestplugin for hierarchy entry 'Farewell': the plugin is already running with a different plugin_process configuration`, err.Error())
+		}
+	})
+}
+
+func TestLookupKey_pluginIdleTimeout(t *testing.T) {
+	ensureTestPlugin(t)
+	inTestdata(func() {
+		configOptions := map[string]px.Value{hieraapi.HieraConfig: types.WrapString(`idle_plugin.yaml`)}
+		hiera.DoWithParent(context.Background(), provider.ConfigLookupKey, configOptions, func(c px.Context) {
+			pid1 := hiera.Lookup(hiera.NewInvocation(c, nil, nil), `pid`, nil, nil)
+			pid2 := hiera.Lookup(hiera.NewInvocation(c, nil, nil), `pid`, nil, nil)
+			require.Equal(t, pid1, pid2)
+		})
+	})
+}
+
+func TestLookupKey_stdioPlugin(t *testing.T) {
+	ensureTestPlugin(t)
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--config`, `stdio_plugin.yaml`, `a`)
+		require.NoError(t, err)
+		require.Equal(t, "option a\n", string(result))
+	})
+}
+
+func TestDataHash_stdioPluginPanic(t *testing.T) {
+	ensureTestPlugin(t)
+	inTestdata(func() {
+		_, err := cli.ExecuteLookup(`--config`, `stdio_panic_plugin.yaml`, `a`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `data_hash/test_panic 500 Internal Server Error: dit dit dit daah daah daah dit dit dit`, err.Error())
+		}
+	})
+}
+
+func TestPluginTest(t *testing.T) {
+	ensureTestPlugin(t)
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`plugin`, `test`, `--fixtures`, `plugin_fixtures.yaml`,
+			`--call-timeout`, `200ms`, `--shutdown-timeout`, `200ms`, filepath.Join(`plugin`, `hieratestplugin`))
+		out := string(result)
+		require.Contains(t, out, `ok:        meta-info "functions" is valid`)
+		require.Contains(t, out, `ok:        lookup_key test_lookup_key key a`)
+		require.Contains(t, out, `ok:        lookup_key test_lookup_key key b`)
+		require.Contains(t, out, `ok:        data_hash test_data_hash`)
+		require.Contains(t, out, `ok:        data_hash test_panic`)
+
+		// test_refuse_to_die never responds and the pending request then prevents a graceful shutdown
+		require.Regexp(t, `violation: data_hash test_refuse_to_die: .*Timeout`, out)
+		require.Contains(t, out, `violation: plugin did not terminate within 200ms after SIGINT`)
+		require.Error(t, err)
+	})
+}
+
+func TestPluginTest_stdio(t *testing.T) {
+	ensureTestPlugin(t)
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`plugin`, `test`, `--fixtures`, `plugin_fixtures.yaml`, `--transport`, `stdio`,
+			`--call-timeout`, `200ms`, `--shutdown-timeout`, `200ms`, filepath.Join(`plugin`, `hieratestplugin`))
+		out := string(result)
+		require.Contains(t, out, `ok:        meta-info "functions" is valid`)
+		require.Contains(t, out, `ok:        lookup_key test_lookup_key key a`)
+		require.Contains(t, out, `ok:        lookup_key test_lookup_key key b`)
+		require.Contains(t, out, `ok:        data_hash test_data_hash`)
+		require.Contains(t, out, `ok:        data_hash test_panic`)
+		require.Contains(t, out, `ok:        list_keys test_lookup_key responds to a call without options`)
+
+		// The pending call to test_refuse_to_die prevents the plugin from terminating when its stdin is closed
+		require.Regexp(t, `violation: data_hash test_refuse_to_die: timeout`, out)
+		require.Contains(t, out, `warning:   plugin did not terminate within 200ms after its stdin was closed`)
+		require.Error(t, err)
+	})
+}
+
+func TestLookupKey_wasmPlugin(t *testing.T) {
+	ensureWasmPlugin(t)
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--config`, `wasm_plugin.yaml`, `a`)
+		require.NoError(t, err)
+		require.Equal(t, "option a\n", string(result))
+
+		_, err = cli.ExecuteLookup(`--config`, `wasm_plugin.yaml`, `b`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `did not find a value for the name 'b'`, err.Error())
+		}
+	})
+}
+
+func TestLookupKey_wasmPluginLimits(t *testing.T) {
+	ensureWasmPlugin(t)
+	inTestdata(func() {
+		_, err := cli.ExecuteLookup(`--config`, `wasm_plugin.yaml`, `calls`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `call aborted after 100000 function calls: call limit exceeded`, err.Error())
+		}
+
+		// The Go runtime of the module fails when it cannot grow its memory
+		_, err = cli.ExecuteLookup(`--config`, `wasm_plugin.yaml`, `memory`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `wasm_lookup_key.wasm lookup_key/wasm_lookup_key 500 Internal Server Error: wasm error`, err.Error())
+		}
+
+		result, err := cli.ExecuteLookup(`--config`, `wasm_plugin_nolimits.yaml`, `memory`)
+		require.NoError(t, err)
+		require.Equal(t, "67108864\n", string(result))
+
+		// The module is instantiated again after a failed call
+		_, err = cli.ExecuteLookup(`--config`, `wasm_plugin.yaml`, `calls`, `a`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `call limit exceeded`, err.Error())
+		}
+		result, err = cli.ExecuteLookup(`--config`, `wasm_plugin.yaml`, `a`)
+		require.NoError(t, err)
+		require.Equal(t, "option a\n", string(result))
+	})
+}
+
+type testWasmRuntime struct {
+	code   []byte
+	limits hieraapi.WasmLimits
+}
+
+type testWasmModule struct{}
+
+func (rt *testWasmRuntime) Load(code []byte, limits hieraapi.WasmLimits) (hieraapi.WasmModule, error) {
+	rt.code = code
+	rt.limits = limits
+	return testWasmModule{}, nil
+}
+
+func (testWasmModule) Meta() ([]byte, error) {
+	return []byte(`{"version":1,"functions":{"lookup_key":["wasm_lookup_key"]}}`), nil
+}
+
+func (testWasmModule) Call(request []byte) ([]byte, error) {
+	var req struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(request, &req); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{`id`: req.ID, `status`: 200, `result`: `from registered runtime`})
+}
+
+func (testWasmModule) Close() error {
+	return nil
+}
+
+func TestLookupKey_registeredWasmRuntime(t *testing.T) {
+	ensureWasmPlugin(t)
+	inTestdata(func() {
+		rt := &testWasmRuntime{}
+		hieraapi.RegisterWasmRuntime(rt)
+		defer hieraapi.RegisterWasmRuntime(nil)
+
+		result, err := cli.ExecuteLookup(`--config`, `wasm_plugin.yaml`, `a`)
+		require.NoError(t, err)
+		require.Equal(t, "from registered runtime\n", string(result))
+		require.True(t, bytes.HasPrefix(rt.code, []byte("\x00asm")))
+		require.Equal(t, hieraapi.WasmLimits{MemoryBytes: 33554432, Calls: 100000}, rt.limits)
+	})
+}
+
 var once = sync.Once{}
 
+var wasmOnce = sync.Once{}
+
+// ensureWasmPlugin builds the WebAssembly test plugin into the plugin directory. The test is skipped when the Go
+// toolchain is older than 1.24, which is the first version that supports the go:wasmexport directive.
+func ensureWasmPlugin(t *testing.T) {
+	t.Helper()
+	out, err := exec.Command(`go`, `env`, `GOVERSION`).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var minor int
+	if _, err = fmt.Sscanf(strings.TrimSpace(string(out)), `go1.%d`, &minor); err == nil && minor < 24 {
+		t.Skipf(`building the WebAssembly test plugin requires Go 1.24 or later, found %s`, strings.TrimSpace(string(out)))
+	}
+	wasmOnce.Do(func() {
+		cmd := exec.Command(`go`, `build`, `-buildmode=c-shared`,
+			`-o`, filepath.Join(`..`, `plugin`, `wasm_lookup_key.wasm`), `.`)
+		cmd.Dir = filepath.Join(`testdata`, `hierawasmplugin`)
+		cmd.Env = append(os.Environ(), `GOOS=wasip1`, `GOARCH=wasm`)
+		cmd.Stderr = os.Stderr
+		cmd.Stdout = os.Stdout
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
 func ensureTestPlugin(t *testing.T) {
 	once.Do(func() {
 		t.Helper()
@@ -424,3 +827,761 @@ func inTestdata(f func()) {
 		panic(err)
 	}
 }
+
+func TestLookup_notHashPosition(t *testing.T) {
+	inTestdata(func() {
+		_, err := cli.ExecuteLookup(`--config`, `not_hash.yaml`, `one`)
+		require.Error(t, err)
+		require.Regexp(t, `File '[^']*hiera/not_hash\.yaml' does not contain a YAML hash \(file: [^,]*hiera/not_hash\.yaml, line: 3, column: 1\)`, filepath.ToSlash(err.Error()))
+	})
+}
+
+func TestLookup_interpolationFunctionInConfig(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--config`, `env_config.yaml`, `--allow-env`, `array`)
+		require.NoError(t, err)
+		require.Equal(t, "- one\n- two\n- three\n", string(result))
+
+		_, err = cli.ExecuteLookup(`--config`, `env_config.yaml`, `array`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Interpolation method 'env' must be enabled using the option Hiera::EnvInterpolation`, err.Error())
+		}
+
+		require.NoError(t, os.Setenv(`HIERA_TEST_DATA_FILE`, `named_by_fact`))
+		defer func() {
+			_ = os.Unsetenv(`HIERA_TEST_DATA_FILE`)
+		}()
+		result, err = cli.ExecuteLookup(`--config`, `env_config.yaml`, `--allow-env`, `hash.one`)
+		require.NoError(t, err)
+		require.Equal(t, "overwritten one\n", string(result))
+
+		_, err = cli.ExecuteLookup(`--config`, `lookup_config.yaml`, `array`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Interpolation using method syntax is not allowed in this context`, err.Error())
+		}
+	})
+}
+
+func TestLookup_strictInterpolation(t *testing.T) {
+	inTestdata(func() {
+		_, err := cli.ExecuteLookup(`--strict-interpolation`, `interpolate_a`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Unable to resolve interpolation expression 'data_file' in hierarchy entry 'Stuff'`, err.Error())
+		}
+
+		_, err = cli.ExecuteLookup(`--strict-interpolation`, `--var`, `data_file=by_fact`, `interpolate_a`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Unable to resolve interpolation expression 'a' in key 'interpolate_a'`, err.Error())
+		}
+
+		result, err := cli.ExecuteLookup(`--var`, `data_file=by_fact`, `interpolate_a`)
+		require.NoError(t, err)
+		require.Equal(t, "'This is '\n", string(result))
+	})
+}
+
+func TestLookup_strictInterpolationPerEntry(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--config`, `strict_config.yaml`, `interpolate_a`)
+		require.NoError(t, err)
+		require.Equal(t, "'This is '\n", string(result))
+
+		_, err = cli.ExecuteLookup(`--config`, `strict_config.yaml`, `interpolate_ca`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Unable to resolve interpolation expression 'c\.a' in key 'interpolate_ca'`, err.Error())
+		}
+
+		result, err = cli.ExecuteLookup(`--config`, `strict_config.yaml`, `--facts`, `facts.yaml`, `interpolate_ca`)
+		require.NoError(t, err)
+		require.Equal(t, "This is value of c.a\n", string(result))
+	})
+}
+
+func TestLookup_strictInterpolationLookupKey(t *testing.T) {
+	inTestdata(func() {
+		_, err := cli.ExecuteLookup(`--config`, `strict_lookup_key.yaml`, `--var`, `greeting=hello %{nobody}`, `greeting`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Unable to resolve interpolation expression 'nobody' in key 'greeting'`, err.Error())
+		}
+
+		result, err := cli.ExecuteLookup(`--config`, `strict_lookup_key.yaml`, `--var`, `greeting=hello %{who}`, `--var`, `who=world`, `greeting`)
+		require.NoError(t, err)
+		require.Equal(t, "hello world\n", string(result))
+	})
+}
+
+func TestLookup_lookupKeyInterpolatedOnce(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--config`, `strict_lookup_key.yaml`, `--var`, `text=%{literal('%')}{who}`, `--var`, `who=world`, `text`)
+		require.NoError(t, err)
+		require.Equal(t, "'%{who}'\n", string(result))
+	})
+}
+
+func TestLookup_wildcardsAndFilters(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`services.*.port`)
+		require.NoError(t, err)
+		require.Equal(t, "- 80\n- 5432\n", string(result))
+
+		result, err = cli.ExecuteLookup(`users[?role=admin].name`)
+		require.NoError(t, err)
+		require.Equal(t, "- alice\n- carol\n", string(result))
+
+		result, err = cli.ExecuteLookup(`users[?role!='admin'].name`)
+		require.NoError(t, err)
+		require.Equal(t, "- bob\n", string(result))
+
+		result, err = cli.ExecuteLookup(`users[*].role`)
+		require.NoError(t, err)
+		require.Equal(t, "- admin\n- user\n- admin\n", string(result))
+
+		result, err = cli.ExecuteLookup(`array.-1`)
+		require.NoError(t, err)
+		require.Equal(t, "three\n", string(result))
+
+		result, err = cli.ExecuteLookup(`array[-3]`)
+		require.NoError(t, err)
+		require.Equal(t, "one\n", string(result))
+
+		result, err = cli.ExecuteLookup(`--default`, `none`, `users[?role=guest].name`)
+		require.NoError(t, err)
+		require.Equal(t, "none\n", string(result))
+	})
+}
+
+func TestLookup_wildcardExplain(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--explain`, `services.*.port`)
+		require.NoError(t, err)
+		out := string(result)
+		require.Regexp(t, `Sub key: "\*\.port"`, out)
+		require.Regexp(t, `Found key: "web" value: \{`, out)
+		require.Regexp(t, `Found key: "db" value: \{`, out)
+		require.Regexp(t, `Found key: "port" value: 5432`, out)
+	})
+}
+
+func TestLookup_list(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--list`, `--facts`, `facts.yaml`)
+		require.NoError(t, err)
+		out := string(result)
+		require.Regexp(t, `(?m)^hash:\n  - 'Common: .*common\.yaml:3'\n  - 'Stuff: .*named_by_fact\.yaml:3'\n`, out)
+		require.Regexp(t, `(?m)^interpolate_ca:\n  - 'Stuff: .*named_by_fact\.yaml:1'\n`, out)
+		require.NotRegexp(t, `lookup_options`, out)
+
+		result, err = cli.ExecuteLookup(`--list`, `interpolate`)
+		require.NoError(t, err)
+		require.Regexp(t, `\Ainterpolate_a:\n  - 'Common: .*common\.yaml:1'\n\z`, string(result))
+
+		result, err = cli.ExecuteLookup(`--list`, `--facts`, `facts.yaml`, `--render-as`, `json`, `*s`)
+		require.NoError(t, err)
+		require.Regexp(t, `\A\{"services":\["Common: [^"]*common\.yaml:26"\],"users":\["Common: [^"]*common\.yaml:32"\]\}`, string(result))
+	})
+}
+
+func TestLookup_listPlugin(t *testing.T) {
+	ensureTestPlugin(t)
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--list`, `--config`, `stdio_plugin.yaml`)
+		require.NoError(t, err)
+		require.Equal(t, "a:\n  - Plugin\npluginTransport:\n  - Plugin\n", string(result))
+
+		// A plugin that doesn't advertise that it can list its keys contributes nothing
+		result, err = cli.ExecuteLookup(`--list`, `--config`, `lookup_key_plugin.yaml`)
+		require.NoError(t, err)
+		require.Equal(t, "{}\n", string(result))
+	})
+}
+
+func TestLookup_all(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--all`, `--facts`, `facts.yaml`)
+		require.NoError(t, err)
+		out := string(result)
+		require.Regexp(t, `(?m)^hash:\n    one: 1\n    three:\n        a: A\n        b: B\n        c: C\n    two: two\n`, out)
+		require.Regexp(t, `(?m)^interpolate_ca: This is value of c\.a$`, out)
+		require.Regexp(t, `(?m)^sense: Sensitive \[value redacted\]$`, out)
+		require.NotRegexp(t, `lookup_options`, out)
+
+		result, err = cli.ExecuteLookup(`--all`, `--show-sensitive`, `--render-as`, `json`, `s*`)
+		require.NoError(t, err)
+		require.Equal(t, `{"sense":"Don't reveal this","services":{"web":{"port":80},"db":{"port":5432}}}`+"\n", string(result))
+	})
+}
+
+func TestLookup_allDottedRootKey(t *testing.T) {
+	inTestdata(func() {
+		// The root keys "a.b" and "c[0]" are not dotted or indexed keys
+		result, err := cli.ExecuteLookup(`--all`, `--config`, `dotted_root.yaml`, `--render-as`, `json`)
+		require.NoError(t, err)
+		require.Equal(t, `{"a":{"b":"nested"},"a.b":"dotted","c[0]":"bracketed"}`+"\n", string(result))
+
+		result, err = cli.ExecuteLookup(`--config`, `dotted_root.yaml`, `"a.b"`)
+		require.NoError(t, err)
+		require.Equal(t, "dotted\n", string(result))
+	})
+}
+
+func TestLookup_diff(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`diff`, `--to-vars`, `facts.yaml`)
+		require.NoError(t, err)
+		require.Regexp(t, `\A`+
+			`\+ hash\.three\.b: 'B'\n    to:   Stuff: .*named_by_fact\.yaml:7\n`+
+			`~ interpolate_a: 'This is ' => 'This is value of a'\n    from: Common: .*common\.yaml:1\n    to:   Common: .*common\.yaml:1\n`+
+			`\+ interpolate_ca: 'This is value of c\.a'\n    to:   Stuff: .*named_by_fact\.yaml:1\n\z`, string(result))
+
+		result, err = cli.ExecuteLookup(`diff`, `--vars`, `facts.yaml`, `--to-config`, `strict_config.yaml`, `--render-as`, `json`, `hash`)
+		require.NoError(t, err)
+		require.Regexp(t, `\A\[\{"change":"changed","key":"hash\.one","from":1,"to":"overwritten one","from_source":"Common: [^"]*common\.yaml:4","to_source":"Stuff: [^"]*named_by_fact\.yaml:4"\},`, string(result))
+	})
+}
+
+func TestLookup_diffExitCode(t *testing.T) {
+	inTestdata(func() {
+		_, err := cli.ExecuteLookup(`diff`, `--exit-code`, `--to-vars`, `facts.yaml`, `interpolate_a`)
+		if assert.Error(t, err) {
+			require.Equal(t, `differences found`, err.Error())
+			require.Equal(t, 1, err.(cli.ExitCoder).ExitCode())
+		}
+
+		result, err := cli.ExecuteLookup(`diff`, `--exit-code`, `--to-vars`, `facts.yaml`, `array`)
+		require.NoError(t, err)
+		require.Equal(t, ``, string(result))
+
+		// The output is the rendered result only, so that it can be parsed by the caller
+		result, err = cli.ExecuteLookup(`diff`, `--exit-code`, `--render-as`, `json`, `--to-vars`, `facts.yaml`, `interpolate_a`)
+		if assert.Error(t, err) {
+			require.Equal(t, 1, err.(cli.ExitCoder).ExitCode())
+		}
+		var changes []map[string]interface{}
+		require.NoError(t, json.Unmarshal(result, &changes))
+		require.Equal(t, `interpolate_a`, changes[0][`key`])
+
+		_, err = cli.ExecuteLookup(`diff`, `--exit-code`, `--to-vars`, `no_such_file.yaml`)
+		if assert.Error(t, err) {
+			require.Equal(t, 2, err.(cli.ExitCoder).ExitCode())
+		}
+
+		_, err = cli.ExecuteLookup(`diff`, `--exit-code`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `diff requires at least one of`, err.Error())
+			require.Equal(t, 2, err.(cli.ExitCoder).ExitCode())
+		}
+	})
+}
+
+func TestLookup_matrix(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`matrix`, `--vars-dir`, `matrix`, `interpolate_a`)
+		require.NoError(t, err)
+		require.Equal(t, `VALUE            COUNT  NODES
+'This is alpha'  2      node1, node3
+'This is beta'   1      node2
+`, string(result))
+
+		result, err = cli.ExecuteLookup(`matrix`, `--vars-dir`, `matrix`, `interpolate_ca`)
+		require.NoError(t, err)
+		require.Equal(t, `VALUE          COUNT  NODES
+'This is cee'  1      node1
+(not found)    2      node2, node3
+`, string(result))
+
+		result, err = cli.ExecuteLookup(`matrix`, `--vars-dir`, `matrix`, `--render-as`, `json`, `interpolate_ca`)
+		require.NoError(t, err)
+		require.Equal(t,
+			`{"nodes":{"node1":"This is cee","node2":null,"node3":null},"groups":[{"value":"This is cee","nodes":["node1"]},{"value":null,"nodes":["node2","node3"]}]}`+"\n",
+			string(result))
+	})
+}
+
+func TestLookup_matrixNoVarsDir(t *testing.T) {
+	inTestdata(func() {
+		_, err := cli.ExecuteLookup(`matrix`, `interpolate_a`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `required flag\(s\) "vars-dir" not set`, err.Error())
+		}
+	})
+}
+
+func TestLookup_validate(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`validate`)
+		require.NoError(t, err)
+		require.Equal(t, ``, string(result))
+
+		result, err = cli.ExecuteLookup(`validate`, `--config`, `validate/hiera.yaml`, `--var`, `node=a`)
+		if assert.Error(t, err) {
+			require.Equal(t, `7 problem(s) found`, err.Error())
+			require.Equal(t, 1, err.(cli.ExitCoder).ExitCode())
+		}
+		require.Equal(t, `validate/hiera.yaml:16:5: unknown key 'pth' in hierarchy.3, did you mean 'path'?
+validate/hiera.yaml:17:5: hierarchy.3.datadir expects a String value, got Integer
+validate/data/common.yaml:4:5: unknown key 'merg' in lookup_options.port, did you mean 'merge'?
+validate/data/common.yaml:6:5: lookup_options.ports.convert_to 'NoSuchType' is not a known type
+validate/data/common.yaml:8:5: lookup_options.hosts.merge: Unknown merge strategy 'sideways'
+validate/data/common.yaml:10:1: value of 'port' cannot be converted to Integer
+validate/data/common.yaml:13:1: key true expects a value of type String or Numeric, got Boolean
+`, string(result))
+	})
+}
+
+func TestLookup_validateAllFiles(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`validate`, `--config`, `validate/hiera.yaml`, `--all-files`, `--render-as`, `json`)
+		require.Error(t, err)
+		require.Regexp(t, `\A\[\{"file":"validate/hiera\.yaml","line":16,"column":5,"message":"unknown key 'pth' in hierarchy\.3, did you mean 'path'\?"\},`, string(result))
+		require.Contains(t, string(result), `{"file":"validate/data/nodes/b.yaml","line":1,"column":0,"message":"syntax error: did not find expected ',' or ']'"},`)
+		var problems []map[string]interface{}
+		require.NoError(t, json.Unmarshal(result[:bytes.IndexByte(result, '\n')], &problems))
+		require.Equal(t, 8, len(problems))
+	})
+}
+
+func TestLookup_lint(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`lint`, `--config`, `lint/hiera.yaml`, `--vars-dir`, `lint/scopes`)
+		if assert.Error(t, err) {
+			require.Equal(t, 1, err.(cli.ExitCoder).ExitCode())
+		}
+		require.Equal(t, `lint/data/common.yaml:4:3: lookup_options for 'no_such_key' refers to a key that is not defined [unused_lookup_options]
+lint/data/common.yaml:10:1: interpolation '%{lookup("db_host")}' refers to an unknown key 'db_host' [unknown_interpolation]
+lint/data/common.yaml:11:1: interpolation '%{fqdn}' refers to an unknown scope variable 'fqdn' [unknown_interpolation]
+lint/data/roles/db.yaml:1:1: 'backup' has the same value as in lint/data/nodes/c.yaml:1 [redundant]
+lint/data/roles/web.yaml:2:1: 'timezone' never wins under the first merge strategy for any scope [shadowed]
+lint/hiera.yaml:12:5: interpolation '%{site}' refers to an unknown scope variable 'site' [unknown_interpolation]
+`, string(result))
+	})
+}
+
+func TestLookup_lintWithoutScopes(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`lint`, `--config`, `lint/hiera.yaml`, `--render-as`, `json`)
+		require.Error(t, err)
+		var findings []map[string]interface{}
+		require.NoError(t, json.Unmarshal(result[:bytes.IndexByte(result, '\n')], &findings))
+		require.Equal(t, 2, len(findings))
+		require.Equal(t, `unused_lookup_options`, findings[0][`kind`])
+		require.Equal(t, `unknown_interpolation`, findings[1][`kind`])
+
+		result, err = cli.ExecuteLookup(`lint`)
+		require.NoError(t, err)
+		require.Equal(t, ``, string(result))
+	})
+}
+
+func TestLookup_declaredType(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--config`, `schema/hiera.yaml`, `--var`, `node=b`, `port`, `hosts`)
+		require.NoError(t, err)
+		require.Equal(t, "8081\n", string(result))
+
+		result, err = cli.ExecuteLookup(`--config`, `schema/hiera.yaml`, `--var`, `node=b`, `hosts`)
+		require.NoError(t, err)
+		require.Equal(t, "- c\n- a\n- b\n", string(result))
+
+		_, err = cli.ExecuteLookup(`--config`, `schema/hiera.yaml`, `--var`, `node=a`, `port`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `value of key 'port' from data_hash function 'yaml_data' in hierarchy entry 'Nodes' does not match the type declared in lookup_options: expects an Integer value, got String`, err.Error())
+		}
+
+		_, err = cli.ExecuteLookup(`--config`, `schema/hiera.yaml`, `--var`, `node=a`, `hosts`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `value of key 'hosts' from data_hash function 'yaml_data' in hierarchy entry 'Nodes' does not match`, err.Error())
+		}
+
+		result, err = cli.ExecuteLookup(`--config`, `schema/hiera.yaml`, `--var`, `node=b`, `--render-as`, `json`, `db`)
+		require.NoError(t, err)
+		require.Equal(t, "{\"port\":5432,\"host\":\"localhost\"}\n", string(result))
+
+		_, err = cli.ExecuteLookup(`--config`, `schema/hiera.yaml`, `--var`, `node=a`, `db`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `value of key 'db' from the merged value does not match the type declared in lookup_options: entry 'port' expects an Integer value, got String`, err.Error())
+		}
+	})
+}
+
+func TestLookup_validateDeclaredType(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`validate`, `--config`, `schema/hiera.yaml`, `--all-files`)
+		require.Error(t, err)
+		require.Equal(t, `schema/data/common.yaml:12:5: lookup_options.owner.type 'NoSuchType' is not a known type
+schema/data/common.yaml:13:5: lookup_options.owner.description must be a string
+schema/data/nodes/a.yaml:1:1: value of 'port' does not match the type declared in lookup_options: expects an Integer value, got String
+schema/data/nodes/a.yaml:2:1: value of 'hosts' does not match the type declared in lookup_options: index '1' expects a String value, got Integer
+`, string(result))
+	})
+}
+
+func TestLookup_aliasAndDeprecated(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`--config`, `deprecated/hiera.yaml`, `--var`, `node=a`, `listen_port`, `timeout`, `db_host`)
+		require.NoError(t, err)
+		require.Equal(t, "8080\n", string(result))
+
+		result, err = cli.ExecuteLookup(`--config`, `deprecated/hiera.yaml`, `--var`, `node=a`, `timeout`)
+		require.NoError(t, err)
+		require.Equal(t, "30\n", string(result))
+
+		result, err = cli.ExecuteLookup(`--config`, `deprecated/hiera.yaml`, `--var`, `node=a`, `db_host`)
+		require.NoError(t, err)
+		require.Equal(t, "db.example.com\n", string(result))
+
+		// The type declared for the root of a dotted alias applies to the value of the root
+		result, err = cli.ExecuteLookup(`--config`, `deprecated/hiera.yaml`, `listen_port`)
+		require.NoError(t, err)
+		require.Equal(t, "8080\n", string(result))
+
+		result, err = cli.ExecuteLookup(`--config`, `deprecated/hiera.yaml`, `server.port`)
+		require.NoError(t, err)
+		require.Equal(t, "8080\n", string(result))
+
+		result, err = cli.ExecuteLookup(`--config`, `deprecated/hiera.yaml`, `--explain`, `listen_port`)
+		require.NoError(t, err)
+		require.Contains(t, string(result), `Searching for "listen_port"
+  Key 'listen_port' is deprecated: use server.port instead
+  Key 'listen_port' is an alias of 'server.port'
+`)
+	})
+}
+
+func TestLookup_lintDeprecated(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`lint`, `--config`, `deprecated/hiera.yaml`)
+		require.Error(t, err)
+		require.Equal(t, `deprecated/data/nodes/a.yaml:1:1: 'listen_port' is deprecated: use server.port instead [deprecated_key]
+deprecated/data/nodes/a.yaml:2:1: 'db_host' is an alias of 'database_host' so this value is never used [deprecated_key]
+deprecated/data/common.yaml:15:1: 'timeout' is deprecated: timeouts are configured per service [deprecated_key]
+`, string(result))
+	})
+}
+
+func TestLookup_validateAliasAndDeprecated(t *testing.T) {
+	inTestdata(func() {
+		result, err := cli.ExecuteLookup(`validate`, `--config`, `deprecated/invalid_hiera.yaml`)
+		require.Error(t, err)
+		require.Equal(t, `deprecated/invalid/common.yaml:3:5: lookup_options.a.alias_of must not refer to the key itself
+deprecated/invalid/common.yaml:4:5: lookup_options.a.deprecated must be a string
+deprecated/invalid/common.yaml:6:5: lookup_options.b.alias_of: lookup() key 'x..y' contains an empty segment
+deprecated/invalid/common.yaml:8:5: lookup_options.c.alias_of must be a string
+`, string(result))
+	})
+}
+
+// inTestdataCopy calls the given function with a copy of the given directory under testdata as the current
+// directory
+func inTestdataCopy(dir string, f func()) {
+	tmp, err := ioutil.TempDir(``, `hiera`)
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmp)
+	}()
+	src := filepath.Join(`testdata`, dir)
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(tmp, path[len(src):])
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		bs, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = ioutil.WriteFile(target, bs, 0644)
+		}
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+	cw, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	if err = os.Chdir(tmp); err != nil {
+		panic(err)
+	}
+	defer func() {
+		_ = os.Chdir(cw)
+	}()
+	f()
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	bs, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(bs)
+}
+
+func TestLookup_set(t *testing.T) {
+	inTestdataCopy(`edit`, func() {
+		result, err := cli.ExecuteLookup(`set`, `defaults.port`, `8080`, `--level`, `Common`)
+		require.NoError(t, err)
+		require.Regexp(t, `\Aset 'defaults\.port' in .*data/common\.yaml\n\z`, string(result))
+
+		_, err = cli.ExecuteLookup(`set`, `web.tls`, `true`, `--level`, `Common`)
+		require.NoError(t, err)
+		_, err = cli.ExecuteLookup(`set`, `defaults.hosts.2`, `c`, `--level`, `Common`)
+		require.NoError(t, err)
+		_, err = cli.ExecuteLookup(`set`, `timezone`, `'8080'`, `--level`, `Common`)
+		require.NoError(t, err)
+		_, err = cli.ExecuteLookup(`set`, `db.options`, `{ssl => true, pool => [1, 2]}`, `--level`, `Common`)
+		require.NoError(t, err)
+		require.Equal(t, `---
+# Defaults for all nodes
+defaults: &defaults
+  port: 8080 # the default port
+  hosts:
+    - a
+    - b
+    - c
+
+web:
+  <<: *defaults
+  name: web
+  tls: true
+
+# The time zone
+timezone: "8080"
+
+db:
+  options:
+    ssl: true
+    pool:
+      - 1
+      - 2
+`, readFile(t, `data/common.yaml`))
+
+		result, err = cli.ExecuteLookup(`defaults.port`)
+		require.NoError(t, err)
+		require.Equal(t, "8080\n", string(result))
+	})
+}
+
+func TestLookup_setCreatesFile(t *testing.T) {
+	inTestdataCopy(`edit`, func() {
+		result, err := cli.ExecuteLookup(`set`, `timezone`, `EST`, `--level`, `Nodes`, `--var`, `node=b`)
+		require.NoError(t, err)
+		require.Regexp(t, `\Aset 'timezone' in .*data/nodes/b\.yaml\n\z`, string(result))
+		require.Equal(t, "timezone: EST\n", readFile(t, `data/nodes/b.yaml`))
+
+		_, err = cli.ExecuteLookup(`set`, `timezone`, `EST`, `--level`, `Nodes`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Hierarchy entry 'Nodes' has no data file for the current scope: interpolation '%\{node\}' has no value`, err.Error())
+		}
+	})
+}
+
+func TestLookup_setJSON(t *testing.T) {
+	inTestdataCopy(`edit`, func() {
+		_, err := cli.ExecuteLookup(`set`, `limits.memory`, `4.5`, `--level`, `Json`)
+		require.NoError(t, err)
+		_, err = cli.ExecuteLookup(`unset`, `owner`, `--level`, `Json`)
+		require.NoError(t, err)
+		require.Equal(t, `{
+  "limits": {
+    "cpu": 2,
+    "memory": 4.5
+  }
+}
+`, readFile(t, `data/common.json`))
+	})
+}
+
+func TestLookup_unset(t *testing.T) {
+	inTestdataCopy(`edit`, func() {
+		result, err := cli.ExecuteLookup(`unset`, `defaults.hosts.0`, `--level`, `Common`)
+		require.NoError(t, err)
+		require.Regexp(t, `\Aunset 'defaults\.hosts\.0' in .*data/common\.yaml\n\z`, string(result))
+		_, err = cli.ExecuteLookup(`unset`, `timezone`, `--level`, `Common`)
+		require.NoError(t, err)
+		require.Equal(t, `---
+# Defaults for all nodes
+defaults: &defaults
+  port: 80 # the default port
+  hosts:
+    - b
+
+web:
+  <<: *defaults
+  name: web
+`, readFile(t, `data/common.yaml`))
+
+		_, err = cli.ExecuteLookup(`unset`, `timezone`, `--level`, `Common`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Unable to edit key 'timezone' in '.*data/common\.yaml': the key is not set`, err.Error())
+		}
+
+		_, err = cli.ExecuteLookup(`unset`, `defaults`, `--level`, `Common`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `the value is the anchor '&defaults'`, err.Error())
+		}
+
+		_, err = cli.ExecuteLookup(`unset`, `web.name`, `--level`, `Unknown`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `No hierarchy entry named 'Unknown'`, err.Error())
+		}
+	})
+}
+
+// relativeDiff strips the temporary directory from the file names of a unified diff
+func relativeDiff(diff []byte) string {
+	return regexp.MustCompile(`(?m)^(---|\+\+\+) .*/data/`).ReplaceAllString(string(diff), `$1 data/`)
+}
+
+func TestLookup_mvDryRun(t *testing.T) {
+	inTestdataCopy(`refactor`, func() {
+		before := readFile(t, `data/common.yaml`)
+		result, err := cli.ExecuteLookup(`mv`, `ntp_servers`, `clock_servers`, `--dry-run`)
+		require.NoError(t, err)
+		require.Equal(t, `--- data/nodes/a.yaml
++++ data/nodes/a.yaml
+@@ -1,4 +1,4 @@
+-ntp_servers:
++clock_servers:
+ - ntp3
+ relay: '%{alias("mail.relay")}'
+ dns: 10.0.0.1
+--- data/roles/web.yaml
++++ data/roles/web.yaml
+@@ -1,4 +1,4 @@
+ role: web
+-ntp: "%{join(lookup('ntp_servers'), ', ')}"
+-first_ntp: "%{lookup('ntp_servers.0') | 'pool.ntp.org'}"
+-second_ntp: "%{lookup('clock') | lookup('ntp_servers.1')}"
++ntp: "%{join(lookup('clock_servers'), ', ')}"
++first_ntp: "%{lookup('clock_servers.0') | 'pool.ntp.org'}"
++second_ntp: "%{lookup('clock') | lookup('clock_servers.1')}"
+--- data/common.yaml
++++ data/common.yaml
+@@ -1,13 +1,13 @@
+ ---
+ lookup_options:
+-  ntp_servers:
++  clock_servers:
+     merge: unique
+   time_servers:
+-    alias_of: ntp_servers
++    alias_of: clock_servers
+ # The NTP servers
+-ntp_servers:
++clock_servers:
+ - ntp1
+ - ntp2
+-banner: 'NTP servers: %{lookup("ntp_servers")}'
++banner: 'NTP servers: %{lookup("clock_servers")}'
+ mail:
+   relay: smtp.example.com
+`, relativeDiff(result))
+		require.Equal(t, before, readFile(t, `data/common.yaml`))
+	})
+}
+
+func TestLookup_mv(t *testing.T) {
+	inTestdataCopy(`refactor`, func() {
+		result, err := cli.ExecuteLookup(`mv`, `ntp_servers`, `clock_servers`)
+		require.NoError(t, err)
+		require.Regexp(t, `\Aupdated .*data/nodes/a\.yaml\nupdated .*data/roles/web\.yaml\nupdated .*data/common\.yaml\n\z`, string(result))
+
+		result, err = cli.ExecuteLookup(`--var`, `node=a`, `time_servers`)
+		require.NoError(t, err)
+		require.Equal(t, "- ntp3\n- ntp1\n- ntp2\n", string(result))
+
+		result, err = cli.ExecuteLookup(`--var`, `node=a`, `first_ntp`)
+		require.NoError(t, err)
+		require.Equal(t, "ntp3\n", string(result))
+
+		_, err = cli.ExecuteLookup(`mv`, `mail.relay`, `smtp.relay`)
+		require.NoError(t, err)
+		require.Equal(t, `---
+lookup_options:
+  clock_servers:
+    merge: unique
+  time_servers:
+    alias_of: clock_servers
+# The NTP servers
+clock_servers:
+- ntp1
+- ntp2
+banner: 'NTP servers: %{lookup("clock_servers")}'
+smtp:
+  relay: smtp.example.com
+`, readFile(t, `data/common.yaml`))
+
+		result, err = cli.ExecuteLookup(`--var`, `node=a`, `relay`)
+		require.NoError(t, err)
+		require.Equal(t, "smtp.example.com\n", string(result))
+
+		_, err = cli.ExecuteLookup(`mv`, `banner`, `smtp.relay`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Unable to edit key 'banner' in '.*data/common\.yaml': the new key 'smtp\.relay' is already set`, err.Error())
+		}
+	})
+}
+
+func TestLookup_move(t *testing.T) {
+	inTestdataCopy(`refactor`, func() {
+		result, err := cli.ExecuteLookup(`move`, `dns`, `--from`, `Nodes`, `--to`, `Hosts`, `--dry-run`)
+		require.NoError(t, err)
+		require.Equal(t, `--- data/nodes/a.yaml
++++ data/nodes/a.yaml
+@@ -1,4 +1,3 @@
+ ntp_servers:
+ - ntp3
+ relay: '%{alias("mail.relay")}'
+-dns: 10.0.0.1
+--- /dev/null
++++ data/hosts/a.yaml
+@@ -0,0 +1 @@
++dns: 10.0.0.1
+--- data/nodes/b.yaml
++++ /dev/null
+@@ -1 +0,0 @@
+-dns: 10.0.0.1
+--- /dev/null
++++ data/hosts/b.yaml
+@@ -0,0 +1 @@
++dns: 10.0.0.1
+`, relativeDiff(result))
+
+		result, err = cli.ExecuteLookup(`move`, `dns`, `--from`, `Nodes`, `--to`, `Hosts`)
+		require.NoError(t, err)
+		require.Regexp(t, `\Aupdated .*data/nodes/a\.yaml\ncreated .*data/hosts/a\.yaml\ndeleted .*data/nodes/b\.yaml\ncreated .*data/hosts/b\.yaml\n\z`, string(result))
+
+		// The only key of data/nodes/b.yaml was moved so the file is deleted rather than left as an empty hash
+		_, err = os.Stat(`data/nodes/b.yaml`)
+		require.True(t, os.IsNotExist(err))
+
+		result, err = cli.ExecuteLookup(`--var`, `node=b`, `dns`)
+		require.NoError(t, err)
+		require.Equal(t, "10.0.0.1\n", string(result))
+
+		_, err = cli.ExecuteLookup(`move`, `role`, `--from`, `Roles`, `--to`, `Common`)
+		require.NoError(t, err)
+		require.Regexp(t, `(?m)^role: web$`, readFile(t, `data/common.yaml`))
+	})
+}
+
+func TestLookup_moveConflict(t *testing.T) {
+	inTestdataCopy(`refactor`, func() {
+		_, err := cli.ExecuteLookup(`set`, `dns`, `10.0.0.2`, `--level`, `Common`)
+		require.NoError(t, err)
+		before := readFile(t, `data/nodes/a.yaml`)
+
+		_, err = cli.ExecuteLookup(`move`, `dns`, `--from`, `Nodes`, `--to`, `Common`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `Unable to edit key 'dns' in '.*data/common\.yaml': it is already set to a different value`, err.Error())
+		}
+		require.Equal(t, before, readFile(t, `data/nodes/a.yaml`))
+
+		_, err = cli.ExecuteLookup(`move`, `dns`, `--from`, `Nodes`, `--to`, `Unknown`)
+		if assert.Error(t, err) {
+			require.Regexp(t, `No hierarchy entry named 'Unknown'`, err.Error())
+		}
+	})
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/lyraproj/dgo/vf"
 
 	"github.com/lyraproj/dgo/dgo"
+	"github.com/lyraproj/hiera/stdioplugin"
 	"github.com/lyraproj/hierasdk/hiera"
 	"github.com/lyraproj/hierasdk/plugin"
 	"github.com/lyraproj/hierasdk/register"
+	"github.com/lyraproj/hierasdk/routes"
 )
 
 func main() {
@@ -16,9 +24,51 @@ func main() {
 	register.DataHash(`test_data_hash`, sampleHash)
 	register.DataHash(`test_refuse_to_die`, refuseToDie)
 	register.DataHash(`test_panic`, panicAttack)
+	register.LookupKey(`test_pid`, pid)
+	register.LookupKey(`test_env`, env)
+	register.LookupKey(`test_limits`, limits)
+	if os.Getenv(`HIERA_TEST_EXTRA_FUNCTION`) != `` {
+		register.LookupKey(`test_extra`, env)
+	}
+	if os.Getenv(`HIERA_PLUGIN_TRANSPORT`) == `stdio` {
+		serveStdio()
+		return
+	}
 	plugin.ServeAndExit()
 }
 
+// serveStdio serves the registered functions over stdin and stdout. It also demonstrates a lookup_key function
+// that can list its keys.
+func serveStdio() {
+	handler, functions := routes.Register()
+	functions = functions.With(`list_keys`, vf.Strings(`test_lookup_key`))
+	mux := http.NewServeMux()
+	mux.Handle(`/`, handler)
+	mux.HandleFunc(`/list_keys/test_lookup_key`, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Type`, `application/json`)
+		if err := json.NewEncoder(w).Encode(optionKeys(r.URL.Query().Get(`options`))); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	os.Exit(stdioplugin.Serve(os.Args[0], mux, functions, false, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// optionKeys returns the sorted keys of the given JSON encoded options, i.e. the keys that lookupOption finds
+func optionKeys(options string) []string {
+	var opts map[string]interface{}
+	if options != `` {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			panic(err)
+		}
+	}
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // lookupOption returns the option for the given key or nil if no such option exist
 func lookupOption(c hiera.ProviderContext, key string) dgo.Value {
 	return c.Option(key)
@@ -43,3 +93,29 @@ func refuseToDie(c hiera.ProviderContext) dgo.Map {
 func panicAttack(c hiera.ProviderContext) dgo.Map {
 	panic(errors.New(`dit dit dit daah daah daah dit dit dit`))
 }
+
+// pid returns the process id of the plugin
+func pid(c hiera.ProviderContext, key string) dgo.Value {
+	return vf.Integer(int64(os.Getpid()))
+}
+
+// startupLimits are the resource limits of the plugin process at the time when it started
+var startupLimits, _ = ioutil.ReadFile(`/proc/self/limits`)
+
+// limits returns the line of the startup limits that starts with the given key, e.g. "Max open files"
+func limits(c hiera.ProviderContext, key string) dgo.Value {
+	for _, line := range strings.Split(string(startupLimits), "\n") {
+		if strings.HasPrefix(line, key) {
+			return vf.String(strings.Join(strings.Fields(line[len(key):]), ` `))
+		}
+	}
+	return nil
+}
+
+// env returns the value of the environment variable named by the given key or nil if no such variable exists
+func env(c hiera.ProviderContext, key string) dgo.Value {
+	if v, ok := os.LookupEnv(key); ok {
+		return vf.String(v)
+	}
+	return nil
+}
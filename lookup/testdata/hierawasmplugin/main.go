@@ -0,0 +1,94 @@
+//go:build wasip1
+
+// Command hierawasmplugin is a WebAssembly plugin that is used by the tests. It is built with:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o wasm_lookup_key.wasm
+package main
+
+import (
+	"encoding/json"
+	"unsafe"
+)
+
+func main() {}
+
+// allocated keeps the buffers that the host writes requests to alive until they are used
+var allocated = make(map[uint32][]byte)
+
+// response keeps the last response alive until the next call
+var response []byte
+
+//go:wasmexport hiera_alloc
+func hieraAlloc(size uint32) uint32 {
+	buf := make([]byte, size)
+	ptr := uint32(uintptr(unsafe.Pointer(unsafe.SliceData(buf))))
+	allocated[ptr] = buf
+	return ptr
+}
+
+//go:wasmexport hiera_meta
+func hieraMeta() uint64 {
+	return respond([]byte(`{"version":1,"functions":{"lookup_key":["wasm_lookup_key"]}}`))
+}
+
+//go:wasmexport hiera_call
+func hieraCall(ptr, size uint32) uint64 {
+	request := allocated[ptr][:size]
+	delete(allocated, ptr)
+
+	var req struct {
+		ID     int64             `json:"id"`
+		Method string            `json:"method"`
+		Params map[string]string `json:"params"`
+	}
+	resp := map[string]interface{}{}
+	if err := json.Unmarshal(request, &req); err != nil {
+		resp[`status`] = 400
+		resp[`error`] = err.Error()
+	} else {
+		resp[`id`] = req.ID
+		status, result := lookupKey(req.Params[`key`], req.Params[`options`])
+		resp[`status`] = status
+		if status == 200 {
+			resp[`result`] = result
+		} else {
+			resp[`error`] = result
+		}
+	}
+	bs, _ := json.Marshal(resp)
+	return respond(bs)
+}
+
+func respond(bs []byte) uint64 {
+	response = bs
+	return uint64(uintptr(unsafe.Pointer(unsafe.SliceData(bs))))<<32 | uint64(len(bs))
+}
+
+// lookupKey returns the option with the given key. The keys "calls" and "memory" exhaust the limits of the module.
+func lookupKey(key, options string) (int, interface{}) {
+	switch key {
+	case `calls`:
+		for {
+			spin()
+		}
+	case `memory`:
+		hog = make([]byte, 64*1024*1024)
+		return 200, len(hog)
+	}
+	var opts map[string]interface{}
+	if options != `` {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return 500, err.Error()
+		}
+	}
+	if v, ok := opts[key]; ok {
+		return 200, v
+	}
+	return 404, `not found`
+}
+
+var hog []byte
+
+//go:noinline
+func spin() {
+}
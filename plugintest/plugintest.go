@@ -0,0 +1,670 @@
+// Package plugintest verifies that a Hiera plugin executable conforms to the protocol that Hiera expects.
+//
+// The Run function starts the plugin, validates its meta-info handshake, calls each advertised function, and
+// finally verifies that the plugin shuts down gracefully. The Test function does the same and reports each
+// protocol violation as an error on a TB such as a *testing.T.
+package plugintest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/hierasdk/hiera"
+
+	// Initializes hieraapi.NewKey
+	_ "github.com/lyraproj/hiera/internal"
+)
+
+// StartTimeout is the time that Hiera waits for a plugin to write its meta-info on stdout
+const StartTimeout = 3 * time.Second
+
+// ShutdownTimeout is the time that Hiera waits for a plugin to terminate after it has been sent a SIGINT
+const ShutdownTimeout = 3 * time.Second
+
+// CallTimeout is the time that a call to a plugin function may take
+const CallTimeout = 5 * time.Second
+
+// Fixture describes a call to a plugin function and the expected outcome of that call.
+type Fixture struct {
+	// Function is the name of the function to call
+	Function string `json:"function" yaml:"function"`
+
+	// Options are passed to the function as the options of the hierarchy entry
+	Options map[string]interface{} `json:"options,omitempty" yaml:"options,omitempty"`
+
+	// Key is the key to pass to a lookup_key or data_dig function. The key of a data_dig function is split
+	// into its dot separated segments.
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+
+	// Expect is the value that the function must return
+	Expect interface{} `json:"expect,omitempty" yaml:"expect,omitempty"`
+
+	// NotFound is true when the function is expected to respond with "not found"
+	NotFound bool `json:"not_found,omitempty" yaml:"not_found,omitempty"`
+
+	// Error is true when the function is expected to fail
+	Error bool `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Options control how the plugin is started
+type Options struct {
+	// Transport is the transport that the plugin is asked to use, "unix", "tcp", or "stdio". Default is "unix".
+	Transport string
+
+	// Env contains extra environment variables for the plugin process
+	Env []string
+
+	// StartTimeout, CallTimeout, and ShutdownTimeout replace the default timeouts when they are non zero
+	StartTimeout    time.Duration
+	CallTimeout     time.Duration
+	ShutdownTimeout time.Duration
+}
+
+func timeout(t, dflt time.Duration) time.Duration {
+	if t == 0 {
+		t = dflt
+	}
+	return t
+}
+
+// TB is the part of testing.TB that the Test function uses to report the outcome. A *testing.T is a TB.
+type TB interface {
+	Helper()
+	Fatal(args ...interface{})
+	Error(args ...interface{})
+	Log(args ...interface{})
+}
+
+// Report is the outcome of a conformance run. Violations are deviations from the protocol that will make Hiera
+// fail to use the plugin. Warnings are deviations that Hiera tolerates.
+type Report struct {
+	Plugin     string
+	Functions  map[string][]string
+	Passed     []string
+	Warnings   []string
+	Violations []string
+}
+
+// OK returns true if the report contains no violations
+func (r *Report) OK() bool {
+	return len(r.Violations) == 0
+}
+
+func (r *Report) pass(format string, args ...interface{}) {
+	r.Passed = append(r.Passed, fmt.Sprintf(format, args...))
+}
+
+func (r *Report) warn(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (r *Report) violation(format string, args ...interface{}) {
+	r.Violations = append(r.Violations, fmt.Sprintf(format, args...))
+}
+
+// Test runs the conformance test for the plugin executable at the given path and reports each violation as an
+// error on the given TB.
+func Test(t TB, path string, fixtures ...Fixture) {
+	t.Helper()
+	r, err := Run(path, nil, fixtures...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range r.Warnings {
+		t.Log(`warning: ` + w)
+	}
+	for _, v := range r.Violations {
+		t.Error(v)
+	}
+}
+
+// Run starts the plugin executable at the given path, validates its meta-info, calls each advertised function using
+// the given fixtures, and verifies that the plugin shuts down gracefully. A function that has no fixtures is called
+// once with no options. An error is returned when the plugin cannot be started at all.
+func Run(path string, opts *Options, fixtures ...Fixture) (*Report, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	transport := opts.Transport
+	if transport == `` {
+		transport = `unix`
+	}
+
+	socketDir, err := ioutil.TempDir(``, `plugintest`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(socketDir)
+	}()
+
+	cmd := exec.Command(path)
+	cmd.Env = append([]string{
+		`HIERA_MAGIC_COOKIE=` + strconv.Itoa(hiera.MagicCookie),
+		`HIERA_PLUGIN_SOCKET_DIR=` + socketDir,
+		`HIERA_PLUGIN_TRANSPORT=` + transport}, opts.Env...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	callTimeout := timeout(opts.CallTimeout, CallTimeout)
+	var c caller
+	var stdin io.WriteCloser
+	var rest func(*bufio.Reader)
+	if transport == `stdio` {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return nil, err
+		}
+		sc := newStdioClient(stdin, callTimeout)
+		c = sc
+		rest = sc.readResponses
+	} else {
+		c = &socketClient{timeout: callTimeout}
+		rest = func(br *bufio.Reader) {
+			// Ignore other stuff that is written on plugin's stdout
+			_, _ = ioutil.ReadAll(br)
+		}
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf(`unable to start plugin %s: %s`, path, err.Error())
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	r := &Report{Plugin: path}
+	meta := r.readMeta(stdout, timeout(opts.StartTimeout, StartTimeout), rest)
+	if meta == nil || !r.handshake(meta, c, transport) {
+		_ = cmd.Process.Kill()
+		<-exited
+		return r, nil
+	}
+
+	r.callFunctions(c, fixtures)
+	r.shutdown(cmd.Process, exited, stdin, timeout(opts.ShutdownTimeout, ShutdownTimeout))
+	return r, nil
+}
+
+// readMeta reads the meta-info that the plugin writes on stdout. The reader is then passed on to the given rest
+// function which consumes everything else that the plugin writes. A nil map is returned when no valid meta-info
+// was read within the given timeout.
+func (r *Report) readMeta(stdout io.Reader, timeout time.Duration, rest func(*bufio.Reader)) map[string]interface{} {
+	metaCh := make(chan interface{}, 1)
+	go func() {
+		var meta map[string]interface{}
+		br := bufio.NewReader(stdout)
+		line, err := br.ReadBytes('\n')
+		if err == nil {
+			err = json.Unmarshal(line, &meta)
+		}
+		if err != nil {
+			metaCh <- err
+		} else {
+			metaCh <- meta
+		}
+		rest(br)
+	}()
+
+	select {
+	case <-time.After(timeout):
+		r.violation(`plugin did not write its meta-info on stdout within %s`, timeout)
+	case mv := <-metaCh:
+		if err, ok := mv.(error); ok {
+			r.violation(`meta-info is not a valid JSON object on one line: %s`, err.Error())
+		} else {
+			return mv.(map[string]interface{})
+		}
+	}
+	return nil
+}
+
+// handshake validates the meta-info of the plugin. The address of a socket client is initialized from the meta-info.
+func (r *Report) handshake(meta map[string]interface{}, c caller, transport string) bool {
+	ok := true
+	if v, vok := meta[`version`].(float64); !vok || int(v) != hiera.ProtoVersion {
+		r.violation(`meta-info "version" must be %d, got %v`, hiera.ProtoVersion, meta[`version`])
+		ok = false
+	} else {
+		r.pass(`meta-info "version" is %d`, hiera.ProtoVersion)
+	}
+
+	if sc, isSocket := c.(*socketClient); isSocket {
+		ok = r.socketHandshake(meta, sc, transport) && ok
+	} else if _, found := meta[`address`]; found {
+		r.warn(`meta-info "address" is ignored when the stdio transport is used`)
+	}
+
+	r.Functions = make(map[string][]string)
+	fm, fok := meta[`functions`].(map[string]interface{})
+	if !fok || len(fm) == 0 {
+		r.violation(`meta-info "functions" is missing or empty`)
+		return false
+	}
+	for luType, names := range fm {
+		switch luType {
+		case `data_dig`, `data_hash`, `list_keys`, `lookup_key`:
+		default:
+			r.violation(`meta-info "functions" contains unknown function type %s`, luType)
+			ok = false
+			continue
+		}
+		na, nok := names.([]interface{})
+		if !nok {
+			r.violation(`meta-info "functions" entry %s must be an array of names`, luType)
+			ok = false
+			continue
+		}
+		for _, n := range na {
+			if s, sok := n.(string); sok && s != `` {
+				r.Functions[luType] = append(r.Functions[luType], s)
+			} else {
+				r.violation(`meta-info "functions" entry %s contains an invalid name %v`, luType, n)
+				ok = false
+			}
+		}
+	}
+	for _, n := range r.Functions[`list_keys`] {
+		if !containsString(r.Functions[`lookup_key`], n) {
+			r.violation(`meta-info "functions" entry list_keys contains %s which is not a lookup_key function`, n)
+			ok = false
+		}
+	}
+	if ok {
+		r.pass(`meta-info "functions" is valid`)
+	}
+	return ok
+}
+
+// socketHandshake validates the address and network of the meta-info and initializes the given client with them
+func (r *Report) socketHandshake(meta map[string]interface{}, c *socketClient, transport string) bool {
+	ok := true
+	if c.addr, _ = meta[`address`].(string); c.addr == `` {
+		r.violation(`meta-info "address" is missing or not a string`)
+		ok = false
+	} else {
+		r.pass(`meta-info "address" is %s`, c.addr)
+	}
+
+	switch c.network, _ = meta[`network`].(string); c.network {
+	case `unix`, `tcp`:
+		if c.network != transport {
+			r.warn(`meta-info "network" is %s although the transport %s was requested`, c.network, transport)
+		} else {
+			r.pass(`meta-info "network" is %s`, c.network)
+		}
+	case ``:
+		r.warn(`meta-info "network" is missing, Hiera will assume tcp`)
+		c.network = `tcp`
+	default:
+		r.violation(`meta-info "network" must be unix or tcp, got %s`, c.network)
+		ok = false
+	}
+
+	return ok
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// callFunctions calls each advertised function with the fixtures that are given for it
+func (r *Report) callFunctions(c caller, fixtures []Fixture) {
+	luTypes := make(map[string]string)
+	for luType, names := range r.Functions {
+		if luType == `list_keys` {
+			// A list_keys function has the same name as the lookup_key function whose keys it lists
+			continue
+		}
+		for _, n := range names {
+			luTypes[n] = luType
+		}
+	}
+
+	called := make(map[string]bool)
+	for _, f := range fixtures {
+		luType, ok := luTypes[f.Function]
+		if !ok {
+			r.violation(`fixture function %s is not advertised by the plugin`, f.Function)
+			continue
+		}
+		called[f.Function] = true
+		r.callFixture(c, luType, f)
+	}
+
+	names := make([]string, 0, len(luTypes))
+	for n := range luTypes {
+		if !called[n] {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		r.probe(c, luTypes[n], n)
+	}
+	for _, n := range r.Functions[`list_keys`] {
+		r.probe(c, `list_keys`, n)
+	}
+}
+
+// callFixture calls the function given by the fixture and verifies the outcome
+func (r *Report) callFixture(c caller, luType string, f Fixture) {
+	what := fmt.Sprintf(`%s %s`, luType, f.Function)
+	if luType != `data_hash` && luType != `list_keys` {
+		what += ` key ` + f.Key
+	}
+	status, value, err := call(c, luType, f.Function, f.Options, f.Key)
+	if err != nil {
+		r.violation(`%s: %s`, what, err.Error())
+		return
+	}
+	switch {
+	case f.Error:
+		if status == http.StatusOK || status == http.StatusNotFound {
+			r.violation(`%s: expected an error, got status %d`, what, status)
+			return
+		}
+	case f.NotFound:
+		if status != http.StatusNotFound {
+			r.violation(`%s: expected status 404 (not found), got %d`, what, status)
+			return
+		}
+	default:
+		if status != http.StatusOK {
+			r.violation(`%s: expected status 200, got %d`, what, status)
+			return
+		}
+		if !r.validResult(what, luType, value) {
+			return
+		}
+		if expect := normalize(f.Expect); !reflect.DeepEqual(expect, value) {
+			r.violation(`%s: expected %v, got %v`, what, expect, value)
+			return
+		}
+	}
+	r.pass(`%s`, what)
+}
+
+// validResult verifies that the value returned by a data_hash function is a JSON object and that the value
+// returned by a list_keys function is a JSON array of strings
+func (r *Report) validResult(what, luType string, value interface{}) bool {
+	switch luType {
+	case `data_hash`:
+		if _, ok := value.(map[string]interface{}); !ok {
+			r.violation(`%s: data_hash must return a JSON object, got %T`, what, value)
+			return false
+		}
+	case `list_keys`:
+		a, ok := value.([]interface{})
+		if ok {
+			for _, k := range a {
+				if _, ok = k.(string); !ok {
+					break
+				}
+			}
+		}
+		if !ok {
+			r.violation(`%s: list_keys must return a JSON array of strings, got %v`, what, value)
+			return false
+		}
+	}
+	return true
+}
+
+// probe calls a function that has no fixtures once without options
+func (r *Report) probe(c caller, luType, name string) {
+	what := fmt.Sprintf(`%s %s`, luType, name)
+	status, value, err := call(c, luType, name, nil, `plugintest_probe`)
+	if err != nil {
+		r.violation(`%s: %s`, what, err.Error())
+		return
+	}
+	switch status {
+	case http.StatusOK:
+		if !r.validResult(what, luType, value) {
+			return
+		}
+		r.pass(`%s responds to a call without options`, what)
+	case http.StatusNotFound:
+		r.pass(`%s responds with not found to a call without options`, what)
+	default:
+		r.warn(`%s responds with status %d to a call without options, consider adding a fixture`, what, status)
+	}
+}
+
+// shutdown verifies that the plugin terminates in time. A plugin that uses the stdio transport should terminate when
+// its stdin is closed. Other plugins, and stdio plugins that don't terminate, are sent a SIGINT.
+func (r *Report) shutdown(process *os.Process, exited chan error, stdin io.Closer, timeout time.Duration) {
+	if stdin != nil {
+		_ = stdin.Close()
+		select {
+		case <-exited:
+			r.pass(`plugin terminated when its stdin was closed`)
+			return
+		case <-time.After(timeout):
+			r.warn(`plugin did not terminate within %s after its stdin was closed`, timeout)
+		}
+	}
+	if runtime.GOOS == `windows` {
+		// SIGINT on windows will fail
+		_ = process.Kill()
+		<-exited
+		return
+	}
+	if err := process.Signal(syscall.SIGINT); err != nil {
+		r.violation(`unable to send SIGINT to plugin: %s`, err.Error())
+		_ = process.Kill()
+		<-exited
+		return
+	}
+	select {
+	case <-exited:
+		r.pass(`plugin terminated gracefully on SIGINT`)
+	case <-time.After(timeout):
+		r.violation(`plugin did not terminate within %s after SIGINT`, timeout)
+		_ = process.Kill()
+		<-exited
+	}
+}
+
+// caller performs calls to a plugin using the same requests as Hiera
+type caller interface {
+	// send sends a request with the given path and parameters and returns the status and the body of the response
+	send(path string, params url.Values) (int, []byte, error)
+}
+
+// call calls the given function and returns the status and the decoded value of the response
+func call(c caller, luType, name string, options map[string]interface{}, key string) (int, interface{}, error) {
+	params := make(url.Values)
+	if len(options) > 0 {
+		bs, err := json.Marshal(normalize(options))
+		if err != nil {
+			return 0, nil, err
+		}
+		params.Add(`options`, string(bs))
+	}
+	switch luType {
+	case `lookup_key`:
+		params.Add(`key`, key)
+	case `data_dig`:
+		bs, err := json.Marshal(hieraapi.NewKey(key).Parts())
+		if err != nil {
+			return 0, nil, err
+		}
+		params.Add(`key`, string(bs))
+	}
+
+	status, bs, err := c.send(luType+`/`+name, params)
+	if err != nil || status != http.StatusOK {
+		return status, nil, err
+	}
+	var value interface{}
+	if err = json.Unmarshal(bs, &value); err != nil {
+		return 0, nil, fmt.Errorf(`response with status 200 is not valid JSON: %s`, err.Error())
+	}
+	return status, value, nil
+}
+
+// socketClient sends requests over HTTP to a plugin that uses the unix or tcp transport
+type socketClient struct {
+	network string
+	addr    string
+	timeout time.Duration
+}
+
+func (c *socketClient) send(path string, params url.Values) (int, []byte, error) {
+	host := `plugin`
+	if c.network == `tcp` {
+		host = c.addr
+	}
+	us := (&url.URL{Scheme: `http`, Host: host, Path: `/` + path, RawQuery: params.Encode()}).String()
+	hc := &http.Client{
+		Timeout: c.timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, c.network, c.addr)
+			}}}
+	resp, err := hc.Get(us)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, bs, nil
+}
+
+// stdioClient sends requests to a plugin that uses the stdio transport. Requests are written on the stdin of the
+// plugin and the responses are read from its stdout by readResponses.
+type stdioClient struct {
+	in        *json.Encoder
+	nextID    int64
+	timeout   time.Duration
+	responses chan *stdioResponse
+}
+
+type stdioResponse struct {
+	ID     int64           `json:"id"`
+	Status int             `json:"status"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+func newStdioClient(stdin io.Writer, timeout time.Duration) *stdioClient {
+	return &stdioClient{in: json.NewEncoder(stdin), timeout: timeout, responses: make(chan *stdioResponse, 16)}
+}
+
+// readResponses reads responses from the given reader until it is closed or until it contains something that isn't
+// a response. Responses are dropped when no one is waiting for them.
+func (c *stdioClient) readResponses(br *bufio.Reader) {
+	defer close(c.responses)
+	dec := json.NewDecoder(br)
+	for {
+		resp := &stdioResponse{}
+		if dec.Decode(resp) != nil {
+			return
+		}
+		select {
+		case c.responses <- resp:
+		default:
+		}
+	}
+}
+
+func (c *stdioClient) send(path string, params url.Values) (int, []byte, error) {
+	c.nextID++
+	id := c.nextID
+	ps := make(map[string]string, len(params))
+	for k := range params {
+		ps[k] = params.Get(k)
+	}
+	if err := c.in.Encode(map[string]interface{}{`id`: id, `method`: path, `params`: ps}); err != nil {
+		return 0, nil, err
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case resp, ok := <-c.responses:
+			if !ok {
+				return 0, nil, errors.New(`plugin closed its stdout`)
+			}
+			if resp.ID != id {
+				// Response to an earlier request that timed out
+				continue
+			}
+			if resp.Status == http.StatusOK {
+				return resp.Status, resp.Result, nil
+			}
+			return resp.Status, []byte(resp.Error), nil
+		case <-timer.C:
+			return 0, nil, fmt.Errorf(`timeout: no response within %s`, c.timeout)
+		}
+	}
+}
+
+// normalize converts the given value into what it would be after a JSON round trip so that it can be
+// compared with a decoded response.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = normalize(e)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = normalize(e)
+		}
+		return a
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	default:
+		return v
+	}
+}
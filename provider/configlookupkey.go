@@ -1,15 +1,21 @@
 package provider
 
 import (
+	"fmt"
+
 	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/issue/issue"
 	"github.com/lyraproj/pcore/px"
 	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/pcore/utils"
 )
 
 var first = types.WrapString(`first`)
 
 // ConfigLookupKey performs a lookup based on a hierarchy of providers that has been specified
-// in a yaml based configuration stored on disk.
+// in a yaml based configuration stored on disk. When the lookup_options of the key declares a type, the
+// merged value must be an instance of that type. A key that is an alias of another key resolves through
+// that key and a key that is deprecated resolves normally, but each lookup of it is reported.
 func ConfigLookupKey(pc hieraapi.ServerContext, key string) px.Value {
 	ic := pc.Invocation()
 	cfg := ic.Config()
@@ -17,6 +23,8 @@ func ConfigLookupKey(pc hieraapi.ServerContext, key string) px.Value {
 
 	k := hieraapi.NewKey(key)
 	return ic.WithLookup(k, func() px.Value {
+		k = resolveAlias(pc, ic, cfg, k)
+
 		var lo map[string]px.Value
 		merge := pc.Option(`merge`)
 		if merge != nil {
@@ -75,12 +83,33 @@ func ConfigLookupKey(pc hieraapi.ServerContext, key string) px.Value {
 			}
 		}
 
-		var v px.Value
+		var valueType px.Type
+		if to, ok := cfg.LookupOptions(k)[`type`]; ok {
+			valueType = ic.ParseType(to.String())
+		}
+
+		// A contribution can only be blamed for a type mismatch when the strategy is first, where the one
+		// contribution is the value, or unique, where each element of the value comes from a contribution. The other
+		// strategies merge partial values that need not match the declared type on their own.
+		blame := valueType != nil && (merge.String() == `first` || merge.String() == `unique` && len(k.Parts()) == 1)
+		var v, offendingValue px.Value
+		var offender hieraapi.DataProvider
 		hf := func() {
 			ms := hieraapi.GetMergeStrategy(hieraapi.MergeStrategyName(merge.String()), mergeOpts)
 			v = ms.Lookup(cfg.Hierarchy(), ic, func(prv interface{}) px.Value {
 				pr := prv.(hieraapi.DataProvider)
-				return pr.Lookup(k, ic, ms)
+				pv := pr.Lookup(k, ic, ms)
+				if blame && offender == nil && pv != nil {
+					cv := pv
+					if _, ok := cv.(*types.Array); !ok && merge.String() == `unique` {
+						cv = types.WrapValues([]px.Value{cv})
+					}
+					if merge.String() == `first` || !px.IsInstance(valueType, cv) {
+						offender = pr
+						offendingValue = cv
+					}
+				}
+				return pv
 			})
 		}
 
@@ -90,6 +119,19 @@ func ConfigLookupKey(pc hieraapi.ServerContext, key string) px.Value {
 			hf()
 		}
 
+		if v != nil && valueType != nil && !px.IsInstance(valueType, v) {
+			source := `the merged value`
+			if offender == nil || merge.String() == `first` {
+				// The value of the one contribution is the value
+				offendingValue = v
+			}
+			if offender != nil {
+				source = providerName(offender)
+			}
+			panic(px.Error(hieraapi.KeyTypeMismatch, issue.H{
+				`key`: k.Source(), `provider`: source, `detail`: hieraapi.DescribeMismatch(valueType, offendingValue)}))
+		}
+
 		if v != nil && convertToType != nil {
 			av := []px.Value{v}
 			if convertToArgs != nil {
@@ -97,6 +139,57 @@ func ConfigLookupKey(pc hieraapi.ServerContext, key string) px.Value {
 			}
 			v = px.New(ic, convertToType, av...)
 		}
+
+		if v != nil && len(k.Parts()) > 1 {
+			// The key is an alias of a dotted key. The type and convert_to of the root apply to the value of the
+			// root, just as they do when the dotted key is looked up directly.
+			v = k.Dig(ic, v)
+		}
 		return v
 	})
 }
+
+// providerName returns the name of the given provider qualified with the name of its hierarchy entry when
+// the provider has one
+func providerName(pr hieraapi.DataProvider) string {
+	if ep, ok := pr.(interface{ HierarchyEntry() hieraapi.Entry }); ok {
+		return fmt.Sprintf(`%s in hierarchy entry '%s'`, pr.FullName(), ep.HierarchyEntry().Name())
+	}
+	return pr.FullName()
+}
+
+// resolveAlias follows the alias_of options in the lookup_options of the given key and returns the key that
+// provides the value. Each deprecated key along the way is reported. An alias that is a dotted key is not
+// followed any further.
+func resolveAlias(pc hieraapi.ServerContext, ic hieraapi.Invocation, cfg hieraapi.ResolvedConfig, k hieraapi.Key) hieraapi.Key {
+	names := []string{k.Source()}
+	for {
+		lo := cfg.LookupOptions(k)
+		if dm, ok := lo[`deprecated`]; ok {
+			reportDeprecated(pc, ic, k.Source(), dm.String())
+		}
+		ao, ok := lo[`alias_of`]
+		if !ok || len(k.Parts()) > 1 {
+			return k
+		}
+		ak := hieraapi.NewKey(ao.String())
+		if utils.ContainsString(names, ak.Source()) {
+			panic(px.Error(hieraapi.EndlessRecursion, issue.H{`name_stack`: append(names, ak.Source())}))
+		}
+		names = append(names, ak.Source())
+		ic.ReportText(func() string { return fmt.Sprintf(`Key '%s' is an alias of '%s'`, k.Source(), ak.Source()) })
+		k = ak
+	}
+}
+
+// reportDeprecated reports a lookup of a deprecated key to the explainer, as a warning in the log, and to the
+// DeprecationHandler given by the option HieraDeprecationHandler, if any.
+func reportDeprecated(pc hieraapi.ServerContext, ic hieraapi.Invocation, key, message string) {
+	ic.ReportText(func() string { return fmt.Sprintf(`Key '%s' is deprecated: %s`, key, message) })
+	ic.Logger().Logf(px.WARNING, `key '%s' is deprecated: %s`, key, message)
+	if rv, ok := pc.Option(hieraapi.HieraDeprecationHandler).(*types.RuntimeValue); ok {
+		if dh, ok := rv.Interface().(hieraapi.DeprecationHandler); ok {
+			dh(key, message)
+		}
+	}
+}
@@ -12,20 +12,28 @@ import (
 )
 
 func JSONData(c hieraapi.ServerContext) px.OrderedMap {
+	data, _ := JSONDataWithPositions(c)
+	return data
+}
+
+// JSONDataWithPositions is like JSONData but also returns a function that produces the positions of the values in the
+// file. The file is parsed a second time only when that function is called.
+func JSONDataWithPositions(c hieraapi.ServerContext) (px.OrderedMap, hieraapi.PositionsFunc) {
 	pv := c.Option(`path`)
 	if pv == nil {
 		panic(px.Error(hieraapi.MissingRequiredOption, issue.H{`option`: `path`}))
 	}
 	path := pv.String()
 	if bin, ok := types.BinaryFromFile2(path); ok {
-		rdr := bytes.NewBuffer(bin.Bytes())
+		bs := bin.Bytes()
 		vc := px.NewCollector()
-		serialization.JsonToData(path, rdr, vc)
+		serialization.JsonToData(path, bytes.NewBuffer(bs), vc)
 		v := vc.Value()
 		if data, ok := v.(px.OrderedMap); ok {
-			return data
+			return data, LazyPositions(path, bs)
 		}
-		panic(px.Error(hieraapi.JSONNOtHash, issue.H{`path`: path}))
+		_, root := ParsePositions(path, bs)
+		panic(px.Error2(root, hieraapi.JSONNOtHash, issue.H{`path`: path}))
 	}
-	return px.EmptyMap
+	return px.EmptyMap, nil
 }
@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"sync"
+
+	"github.com/lyraproj/hiera/hieraapi"
+	"github.com/lyraproj/issue/issue"
+	"gopkg.in/yaml.v3"
+)
+
+// ParsePositions parses the given YAML or JSON content and returns the positions of all values in the
+// contained hash together with the location of the document root. The positions are nil and the root
+// location has no line when the content cannot be parsed.
+func ParsePositions(path string, content []byte) (hieraapi.Positions, issue.Location) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(content, doc); err != nil || len(doc.Content) != 1 {
+		return nil, issue.NewLocation(path, 0, 0)
+	}
+	root := doc.Content[0]
+	ps := hieraapi.Positions{}
+	addPositions(path, nil, root, ps)
+	return ps, issue.NewLocation(path, root.Line, root.Column)
+}
+
+// LazyPositions returns a function that parses the given content the first time it is called and then returns
+// the positions of all values in the contained hash.
+func LazyPositions(path string, content []byte) hieraapi.PositionsFunc {
+	var once sync.Once
+	var ps hieraapi.Positions
+	return func() hieraapi.Positions {
+		once.Do(func() {
+			ps, _ = ParsePositions(path, content)
+		})
+		return ps
+	}
+}
+
+func addPositions(path string, vp []interface{}, n *yaml.Node, ps hieraapi.Positions) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			if k.Kind != yaml.ScalarNode || k.Tag == `!!merge` {
+				continue
+			}
+			kp := append(vp[:len(vp):len(vp)], k.Value)
+			ps.Add(kp, issue.NewLocation(path, k.Line, k.Column))
+			addPositions(path, kp, n.Content[i+1], ps)
+		}
+	case yaml.SequenceNode:
+		for i, e := range n.Content {
+			ep := append(vp[:len(vp):len(vp)], i)
+			ps.Add(ep, issue.NewLocation(path, e.Line, e.Column))
+			addPositions(path, ep, e, ps)
+		}
+	}
+}
@@ -9,17 +9,26 @@ import (
 )
 
 func YamlData(ctx hieraapi.ServerContext) px.OrderedMap {
+	data, _ := YamlDataWithPositions(ctx)
+	return data
+}
+
+// YamlDataWithPositions is like YamlData but also returns a function that produces the positions of the values in the
+// file. The file is parsed a second time only when that function is called.
+func YamlDataWithPositions(ctx hieraapi.ServerContext) (px.OrderedMap, hieraapi.PositionsFunc) {
 	pv := ctx.Option(`path`)
 	if pv == nil {
 		panic(px.Error(hieraapi.MissingRequiredOption, issue.H{`option`: `path`}))
 	}
 	path := pv.String()
 	if bin, ok := types.BinaryFromFile2(path); ok {
-		v := yaml.Unmarshal(ctx.(hieraapi.ServerContext).Invocation(), bin.Bytes())
+		bs := bin.Bytes()
+		v := yaml.Unmarshal(ctx.(hieraapi.ServerContext).Invocation(), bs)
 		if data, ok := v.(px.OrderedMap); ok {
-			return data
+			return data, LazyPositions(path, bs)
 		}
-		panic(px.Error(hieraapi.YamlNotHash, issue.H{`path`: path}))
+		_, root := ParsePositions(path, bs)
+		panic(px.Error2(root, hieraapi.YamlNotHash, issue.H{`path`: path}))
 	}
-	return px.EmptyMap
+	return px.EmptyMap, nil
 }
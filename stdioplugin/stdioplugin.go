@@ -0,0 +1,125 @@
+// Package stdioplugin serves the functions of a Hiera plugin using the "stdio" transport.
+//
+// The plugin package of the hierasdk only serves functions over a socket. A plugin that calls ServeAndExit instead
+// of plugin.ServeAndExit serves its registered functions over stdin and stdout when Hiera asks for the "stdio"
+// transport, and falls back to plugin.ServeAndExit for all other transports.
+package stdioplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/lyraproj/dgo/dgo"
+	"github.com/lyraproj/dgo/vf"
+	"github.com/lyraproj/hierasdk/hiera"
+	"github.com/lyraproj/hierasdk/plugin"
+	"github.com/lyraproj/hierasdk/routes"
+)
+
+// Request is a call from Hiera. The Method and Params are the path and the query parameters that would be used
+// with a socket transport.
+type Request struct {
+	ID     int64             `json:"id"`
+	Method string            `json:"method"`
+	Params map[string]string `json:"params"`
+}
+
+// Response is the reply to the Request with the same ID. The Status has the same meaning as an HTTP status. The
+// Explain messages are contributed to the output of a lookup explanation.
+type Response struct {
+	ID      int64           `json:"id"`
+	Status  int             `json:"status"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Explain []string        `json:"explain,omitempty"`
+}
+
+// ExplainHeader is the response header that a handler adds once for each explain message. It is the same header
+// that a plugin uses with a socket transport.
+const ExplainHeader = `X-Hiera-Explain`
+
+// ServeAndExit serves the functions that have been registered using the hierasdk register package and then exits.
+// The "stdio" transport is used when the environment variable HIERA_PLUGIN_TRANSPORT says so. All other transports
+// are served by plugin.ServeAndExit.
+func ServeAndExit() {
+	if os.Getenv(`HIERA_PLUGIN_TRANSPORT`) != `stdio` {
+		plugin.ServeAndExit()
+		return
+	}
+	handler, functions := routes.Register()
+	os.Exit(Serve(os.Args[0], handler, functions, false, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Serve writes the meta-info for the given functions on stdout and then passes each request that is read from
+// stdin on to the given handler. The meta-info declares that the plugin uses the callback channel when callbacks
+// is true. Requests are served concurrently and each response is written on stdout as soon as it is available.
+// Serve returns the exit code for the plugin process when stdin is closed.
+func Serve(name string, handler http.Handler, functions dgo.Map, callbacks bool, stdin io.Reader, stdout, stderr io.Writer) int {
+	if cookie, _ := strconv.Atoi(os.Getenv(`HIERA_MAGIC_COOKIE`)); cookie != hiera.MagicCookie {
+		_, _ = fmt.Fprintf(stderr,
+			"%s is meant to be used as a Hiera RESTful plugin. It should not be started from a command shell\n", name)
+		return 1
+	}
+
+	out := json.NewEncoder(stdout)
+	meta := vf.Map(`version`, hiera.ProtoVersion, `functions`, functions)
+	if callbacks {
+		meta = meta.Merge(vf.Map(`callbacks`, true))
+	}
+	if err := out.Encode(meta); err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	var outLock sync.Mutex
+	var wg sync.WaitGroup
+	exitCode := 0
+	in := json.NewDecoder(stdin)
+	for {
+		var req Request
+		if err := in.Decode(&req); err != nil {
+			if err != io.EOF {
+				_, _ = fmt.Fprintln(stderr, err)
+				exitCode = 1
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := Call(handler, &req)
+			outLock.Lock()
+			defer outLock.Unlock()
+			if err := out.Encode(resp); err != nil {
+				_, _ = fmt.Fprintln(stderr, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return exitCode
+}
+
+// Call passes the given request on to the handler and returns the response
+func Call(handler http.Handler, req *Request) *Response {
+	q := url.Values{}
+	for k, v := range req.Params {
+		q.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, `/`+req.Method+`?`+q.Encode(), nil))
+
+	resp := &Response{ID: req.ID, Status: rec.Code, Explain: rec.Header()[ExplainHeader]}
+	if rec.Code == http.StatusOK {
+		resp.Result = json.RawMessage(rec.Body.Bytes())
+	} else {
+		resp.Error = rec.Body.String()
+	}
+	return resp
+}
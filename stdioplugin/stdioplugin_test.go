@@ -0,0 +1,95 @@
+package stdioplugin_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lyraproj/dgo/dgo"
+	"github.com/lyraproj/dgo/vf"
+	"github.com/lyraproj/hiera/stdioplugin"
+	"github.com/lyraproj/hierasdk/hiera"
+	"github.com/lyraproj/hierasdk/register"
+	"github.com/lyraproj/hierasdk/routes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe(t *testing.T) {
+	register.LookupKey(`upper`, func(_ hiera.ProviderContext, key string) dgo.Value {
+		if key == `missing` {
+			return nil
+		}
+		return vf.String(strings.ToUpper(key))
+	})
+	handler, functions := routes.Register()
+
+	require.NoError(t, os.Setenv(`HIERA_MAGIC_COOKIE`, strconv.Itoa(hiera.MagicCookie)))
+	defer func() {
+		_ = os.Unsetenv(`HIERA_MAGIC_COOKIE`)
+	}()
+
+	in := strings.NewReader(`{"id":1,"method":"lookup_key/upper","params":{"key":"a"}}
+{"id":2,"method":"lookup_key/upper","params":{"key":"missing"}}
+`)
+	out := bytes.Buffer{}
+	errOut := bytes.Buffer{}
+	require.Equal(t, 0, stdioplugin.Serve(`upper`, handler, functions, false, in, &out, &errOut))
+	require.Empty(t, errOut.String())
+
+	dec := json.NewDecoder(&out)
+	var meta map[string]interface{}
+	require.NoError(t, dec.Decode(&meta))
+	require.Equal(t, map[string]interface{}{`lookup_key`: []interface{}{`upper`}}, meta[`functions`])
+	require.NotContains(t, meta, `address`)
+	require.NotContains(t, meta, `callbacks`)
+
+	// Responses may arrive in any order
+	resps := map[int64]stdioplugin.Response{}
+	for i := 0; i < 2; i++ {
+		var resp stdioplugin.Response
+		require.NoError(t, dec.Decode(&resp))
+		resps[resp.ID] = resp
+	}
+	require.Equal(t, 200, resps[1].Status)
+	require.Equal(t, `"A"`, strings.TrimSpace(string(resps[1].Result)))
+	require.Equal(t, 404, resps[2].Status)
+}
+
+func TestServe_noCookie(t *testing.T) {
+	errOut := bytes.Buffer{}
+	require.Equal(t, 1, stdioplugin.Serve(`upper`, nil, vf.Map(), false, strings.NewReader(``), &bytes.Buffer{}, &errOut))
+	require.Contains(t, errOut.String(), `should not be started from a command shell`)
+}
+
+func TestServe_explainAndCallbacks(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add(stdioplugin.ExplainHeader, `first`)
+		w.Header().Add(stdioplugin.ExplainHeader, `second`)
+		_, _ = w.Write([]byte(`"` + r.URL.Query().Get(`callback`) + `"`))
+	})
+
+	require.NoError(t, os.Setenv(`HIERA_MAGIC_COOKIE`, strconv.Itoa(hiera.MagicCookie)))
+	defer func() {
+		_ = os.Unsetenv(`HIERA_MAGIC_COOKIE`)
+	}()
+
+	in := strings.NewReader(`{"id":1,"method":"lookup_key/cb","params":{"key":"a","callback":"http://127.0.0.1:1/x"}}
+`)
+	out := bytes.Buffer{}
+	functions := vf.Map(`lookup_key`, vf.Strings(`cb`))
+	require.Equal(t, 0, stdioplugin.Serve(`cb`, handler, functions, true, in, &out, &bytes.Buffer{}))
+
+	dec := json.NewDecoder(&out)
+	var meta map[string]interface{}
+	require.NoError(t, dec.Decode(&meta))
+	require.Equal(t, true, meta[`callbacks`])
+
+	var resp stdioplugin.Response
+	require.NoError(t, dec.Decode(&resp))
+	require.Equal(t, `"http://127.0.0.1:1/x"`, string(resp.Result))
+	require.Equal(t, []string{`first`, `second`}, resp.Explain)
+}